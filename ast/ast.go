@@ -10,6 +10,7 @@ import (
 type Node interface {
 	TokenLiteral() string
 	String() string
+	Pos() token.Position // ノードの先頭トークンのソース上の位置
 }
 
 // 文ノード: 値を返さない
@@ -38,6 +39,13 @@ func (p *Program) String() string {
 	return out.String()
 }
 
+func (p *Program) Pos() token.Position {
+	if len(p.Statements) > 0 {
+		return p.Statements[0].Pos()
+	}
+	return token.Position{}
+}
+
 func (p *Program) TokenLiteral() string {
 	if len(p.Statements) > 0 {
 		return p.Statements[0].TokenLiteral()
@@ -60,6 +68,7 @@ type LetStatement struct {
 
 func (ls *LetStatement) statementNode()       {}
 func (ls *LetStatement) TokenLiteral() string { return ls.Token.Literal }
+func (ls *LetStatement) Pos() token.Position  { return ls.Token.Pos }
 func (ls *LetStatement) String() string {
 	var out bytes.Buffer
 	out.WriteString(ls.TokenLiteral() + " ")
@@ -84,6 +93,7 @@ type Identifier struct {
 
 func (i *Identifier) expressionNode()      {}
 func (i *Identifier) TokenLiteral() string { return i.Token.Literal }
+func (i *Identifier) Pos() token.Position  { return i.Token.Pos }
 func (i *Identifier) String() string       { return i.Value }
 
 // -----------------------------------------------------
@@ -99,6 +109,7 @@ type ReturnStatement struct {
 
 func (rs *ReturnStatement) statementNode()       {}
 func (rs *ReturnStatement) TokenLiteral() string { return rs.Token.Literal }
+func (rs *ReturnStatement) Pos() token.Position  { return rs.Token.Pos }
 func (rs *ReturnStatement) String() string {
 	var out bytes.Buffer
 	out.WriteString(rs.TokenLiteral() + " ")
@@ -111,6 +122,91 @@ func (rs *ReturnStatement) String() string {
 
 // -----------------------------------------------------
 
+// -----------------------------------------------------
+// WHILE文を表すASTノード
+// while (<condition>) <body>
+// while (i < 10) { i += 1; }
+type WhileStatement struct {
+	Token     token.Token // token.WHILE = "while"
+	Condition Expression  // i < 10
+	Body      *BlockStatement
+}
+
+func (ws *WhileStatement) statementNode()       {}
+func (ws *WhileStatement) TokenLiteral() string { return ws.Token.Literal }
+func (ws *WhileStatement) Pos() token.Position  { return ws.Token.Pos }
+func (ws *WhileStatement) String() string {
+	var out bytes.Buffer
+	out.WriteString("while (")
+	out.WriteString(ws.Condition.String())
+	out.WriteString(") ")
+	out.WriteString(ws.Body.String())
+	return out.String()
+}
+
+// -----------------------------------------------------
+
+// -----------------------------------------------------
+// FOR文を表すASTノード
+// for (<identifier> in <expression>) <body>
+// for (<identifier>, <identifier> in <expression>) <body>
+// for (x in [1, 2, 3]) { puts(x); }
+// for (k, v in {"a": 1}) { puts(k, v); }
+type ForStatement struct {
+	Token    token.Token // token.FOR = "for"
+	Key      *Identifier // 変数が二つのときの一つ目（添字やキー）。一つのときはnil
+	Value    *Identifier // 要素（変数が一つでハッシュを走査するときはキー）
+	Iterable Expression  // 走査する対象
+	Body     *BlockStatement
+}
+
+func (fs *ForStatement) statementNode()       {}
+func (fs *ForStatement) TokenLiteral() string { return fs.Token.Literal }
+func (fs *ForStatement) Pos() token.Position  { return fs.Token.Pos }
+func (fs *ForStatement) String() string {
+	var out bytes.Buffer
+	out.WriteString("for (")
+	if fs.Key != nil {
+		out.WriteString(fs.Key.String() + ", ")
+	}
+	out.WriteString(fs.Value.String())
+	out.WriteString(" in ")
+	out.WriteString(fs.Iterable.String())
+	out.WriteString(") ")
+	out.WriteString(fs.Body.String())
+	return out.String()
+}
+
+// -----------------------------------------------------
+
+// -----------------------------------------------------
+// BREAK文を表すASTノード
+// break;
+type BreakStatement struct {
+	Token token.Token // token.BREAK = "break"
+}
+
+func (bs *BreakStatement) statementNode()       {}
+func (bs *BreakStatement) TokenLiteral() string { return bs.Token.Literal }
+func (bs *BreakStatement) Pos() token.Position  { return bs.Token.Pos }
+func (bs *BreakStatement) String() string       { return bs.Token.Literal + ";" }
+
+// -----------------------------------------------------
+
+// -----------------------------------------------------
+// CONTINUE文を表すASTノード
+// continue;
+type ContinueStatement struct {
+	Token token.Token // token.CONTINUE = "continue"
+}
+
+func (cs *ContinueStatement) statementNode()       {}
+func (cs *ContinueStatement) TokenLiteral() string { return cs.Token.Literal }
+func (cs *ContinueStatement) Pos() token.Position  { return cs.Token.Pos }
+func (cs *ContinueStatement) String() string       { return cs.Token.Literal + ";" }
+
+// -----------------------------------------------------
+
 // -----------------------------------------------------
 // 式文を表すASTノード
 // 式単体で文扱い。要するに「式のwrapper」としての型
@@ -123,6 +219,7 @@ type ExpressionStatement struct {
 
 func (es *ExpressionStatement) statementNode()       {}
 func (es *ExpressionStatement) TokenLiteral() string { return es.Token.Literal }
+func (es *ExpressionStatement) Pos() token.Position  { return es.Token.Pos }
 func (es *ExpressionStatement) String() string {
 	if es.Expression != nil {
 		return es.Expression.String()
@@ -142,10 +239,26 @@ type IntegerLiteral struct {
 
 func (il *IntegerLiteral) expressionNode()      {}
 func (il *IntegerLiteral) TokenLiteral() string { return il.Token.Literal }
+func (il *IntegerLiteral) Pos() token.Position  { return il.Token.Pos }
 func (il *IntegerLiteral) String() string       { return il.Token.Literal }
 
 // -----------------------------------------------------
 
+// -----------------------------------------------------
+// 浮動小数点数リテラルを表すASTノード
+// 1.5, 1e-9
+type FloatLiteral struct {
+	Token token.Token // token.FLOAT
+	Value float64     // 1.5
+}
+
+func (fl *FloatLiteral) expressionNode()      {}
+func (fl *FloatLiteral) TokenLiteral() string { return fl.Token.Literal }
+func (fl *FloatLiteral) Pos() token.Position  { return fl.Token.Pos }
+func (fl *FloatLiteral) String() string       { return fl.Token.Literal }
+
+// -----------------------------------------------------
+
 // -----------------------------------------------------
 // 前置演算子を表すASTノード
 // <prefix operator> <expression>;
@@ -159,6 +272,7 @@ type PrefixExpression struct {
 
 func (pe *PrefixExpression) expressionNode()      {}
 func (pe *PrefixExpression) TokenLiteral() string { return pe.Token.Literal }
+func (pe *PrefixExpression) Pos() token.Position  { return pe.Token.Pos }
 func (pe *PrefixExpression) String() string {
 	var out bytes.Buffer
 	out.WriteString("(")
@@ -183,6 +297,7 @@ type InfixExpression struct {
 
 func (oe *InfixExpression) expressionNode()      {}
 func (oe *InfixExpression) TokenLiteral() string { return oe.Token.Literal }
+func (oe *InfixExpression) Pos() token.Position  { return oe.Left.Pos() }
 func (oe *InfixExpression) String() string {
 	var out bytes.Buffer
 	// out.WriteString("(")
@@ -195,6 +310,37 @@ func (oe *InfixExpression) String() string {
 
 // -----------------------------------------------------
 
+// -----------------------------------------------------
+// 代入を表すASTノード
+// <identifier> <assign operator> <expression>;
+// <expression>[<expression>] <assign operator> <expression>;
+// x = 5, x += 1, arr[0] = 5
+type AssignExpression struct {
+	Token    token.Token // 代入演算子トークン
+	Target   Expression  // 代入先（IdentifierかIndexExpression）
+	Operator string      // =, +=, -=, *=, /=
+	Value    Expression  // 5
+}
+
+func (ae *AssignExpression) expressionNode()      {}
+func (ae *AssignExpression) TokenLiteral() string { return ae.Token.Literal }
+func (ae *AssignExpression) Pos() token.Position  { return ae.Target.Pos() }
+func (ae *AssignExpression) String() string {
+	var out bytes.Buffer
+	out.WriteString(ae.Target.String())
+	out.WriteString(" " + ae.Operator + " ")
+	out.WriteString(ae.Value.String())
+	return out.String()
+}
+
+// 複合代入演算子に対応する二項演算子を返す
+// 単純な代入「=」のときは空文字列を返す
+func (ae *AssignExpression) BinaryOperator() string {
+	return strings.TrimSuffix(ae.Operator, "=")
+}
+
+// -----------------------------------------------------
+
 // -----------------------------------------------------
 // BOOLEAN型のトークンを表すASTノード
 // false
@@ -205,6 +351,7 @@ type Boolean struct {
 
 func (b *Boolean) expressionNode()      {}
 func (b *Boolean) TokenLiteral() string { return b.Token.Literal }
+func (b *Boolean) Pos() token.Position  { return b.Token.Pos }
 func (b *Boolean) String() string       { return b.Token.Literal }
 
 // -----------------------------------------------------
@@ -222,6 +369,7 @@ type IfExpression struct {
 
 func (ie *IfExpression) expressionNode()      {}
 func (ie *IfExpression) TokenLiteral() string { return ie.Token.Literal }
+func (ie *IfExpression) Pos() token.Position  { return ie.Token.Pos }
 func (ie *IfExpression) String() string {
 	var out bytes.Buffer
 	out.WriteString("if")
@@ -247,6 +395,7 @@ type BlockStatement struct {
 
 func (bs *BlockStatement) statementNode()       {}
 func (bs *BlockStatement) TokenLiteral() string { return bs.Token.Literal }
+func (bs *BlockStatement) Pos() token.Position  { return bs.Token.Pos }
 func (bs *BlockStatement) String() string {
 	var out bytes.Buffer
 	for _, s := range bs.Statements {
@@ -267,10 +416,12 @@ type FunctionLiteral struct {
 	Token      token.Token     // 'fn' トークン
 	Parameters []*Identifier   // x, y
 	Body       *BlockStatement // x + y;
+	Name       string          // let文で束縛される名前（無名関数なら空）
 }
 
 func (fl *FunctionLiteral) expressionNode()      {}
 func (fl *FunctionLiteral) TokenLiteral() string { return fl.Token.Literal }
+func (fl *FunctionLiteral) Pos() token.Position  { return fl.Token.Pos }
 func (fl *FunctionLiteral) String() string {
 	var out bytes.Buffer
 	params := []string{}
@@ -296,10 +447,12 @@ type CallExpression struct {
 	Token     token.Token // '(' トークン
 	Function  Expression  // Identifier または FunctionLiteral
 	Arguments []Expression
+	Tail      bool // 関数本体の末尾位置にある呼び出しか（呼び出し結果がそのまま関数の戻り値になる）
 }
 
 func (ce *CallExpression) expressionNode()      {}
 func (ce *CallExpression) TokenLiteral() string { return ce.Token.Literal }
+func (ce *CallExpression) Pos() token.Position  { return ce.Function.Pos() }
 func (ce *CallExpression) String() string {
 	var out bytes.Buffer
 	args := []string{}
@@ -327,6 +480,7 @@ type StringLiteral struct {
 
 func (sl *StringLiteral) expressionNode()      {}
 func (sl *StringLiteral) TokenLiteral() string { return sl.Token.Literal }
+func (sl *StringLiteral) Pos() token.Position  { return sl.Token.Pos }
 func (sl *StringLiteral) String() string       { return sl.Token.Literal }
 
 // -----------------------------------------------------
@@ -342,6 +496,7 @@ type ArrayLiteral struct {
 
 func (al *ArrayLiteral) expressionNode()      {}
 func (al *ArrayLiteral) TokenLiteral() string { return al.Token.Literal }
+func (al *ArrayLiteral) Pos() token.Position  { return al.Token.Pos }
 func (al *ArrayLiteral) String() string {
 	var out bytes.Buffer
 	elements := []string{}
@@ -356,6 +511,36 @@ func (al *ArrayLiteral) String() string {
 
 // -----------------------------------------------------
 
+// -----------------------------------------------------
+// タプルリテラルを表すASTノード
+// ( <sequence of Expressions> )
+// 括弧の式と区別するため、要素が一つのときは(x,)のようにコンマを付ける
+// (1, "two"), (x,), ()
+type TupleLiteral struct {
+	Token    token.Token // '(' トークン
+	Elements []Expression
+}
+
+func (tl *TupleLiteral) expressionNode()      {}
+func (tl *TupleLiteral) TokenLiteral() string { return tl.Token.Literal }
+func (tl *TupleLiteral) Pos() token.Position  { return tl.Token.Pos }
+func (tl *TupleLiteral) String() string {
+	var out bytes.Buffer
+	elements := []string{}
+	for _, el := range tl.Elements {
+		elements = append(elements, el.String())
+	}
+	out.WriteString("(")
+	out.WriteString(strings.Join(elements, ", "))
+	if len(elements) == 1 {
+		out.WriteString(",")
+	}
+	out.WriteString(")")
+	return out.String()
+}
+
+// -----------------------------------------------------
+
 // -----------------------------------------------------
 // 添字演算子式を表すASTノード
 // <expression> [ <expression> ]
@@ -369,6 +554,7 @@ type IndexExpression struct {
 
 func (ie *IndexExpression) expressionNode()      {}
 func (ie *IndexExpression) TokenLiteral() string { return ie.Token.Literal }
+func (ie *IndexExpression) Pos() token.Position  { return ie.Left.Pos() }
 func (ie *IndexExpression) String() string {
 	var out bytes.Buffer
 	out.WriteString("(")
@@ -388,15 +574,17 @@ func (ie *IndexExpression) String() string {
 type HashLiteral struct {
 	Token token.Token // '{' トークン
 	Pairs map[Expression]Expression
+	Keys  []Expression // Pairsのキーをソースに書かれた順に並べたもの
 }
 
 func (hl *HashLiteral) expressionNode()      {}
 func (hl *HashLiteral) TokenLiteral() string { return hl.Token.Literal }
+func (hl *HashLiteral) Pos() token.Position  { return hl.Token.Pos }
 func (hl *HashLiteral) String() string {
 	var out bytes.Buffer
 	pairs := []string{}
-	for key, value := range hl.Pairs {
-		pairs = append(pairs, key.String()+": "+value.String())
+	for _, key := range hl.Keys {
+		pairs = append(pairs, key.String()+": "+hl.Pairs[key].String())
 	}
 	out.WriteString("{")
 	out.WriteString(strings.Join(pairs, ", "))
@@ -4,6 +4,7 @@ import (
 	"bytes"
 	"encoding/binary"
 	"fmt"
+	"sort"
 )
 
 type Instructions []byte
@@ -11,35 +12,54 @@ type Instructions []byte
 type Opcode byte
 
 const (
-	OpConstant      Opcode = iota // sets constant value in constant pool.
-	OpAdd                         // pops 2 topmost elements from off the stack and adds them, pushes back on the top of the stack.
-	OpSub                         // pops 2 topmost elements from off the stack and subtracts them, pushes back on the top of the stack.
-	OpMul                         // pops 2 topmost elements from off the stack and multiplies them, pushes back on the top of the stack.
-	OpDiv                         // pops 2 topmost elements from off the stack and divides them, pushes back on the top of the stack.
-	OpPop                         // makes the stack clean after every expression statement.
-	OpTrue                        // pushes an *object.Boolean(true) on to the stack.
-	OpFalse                       // pushed an *object.Boolean(false) on to the stack.
-	OpEqual                       // pops 2 topmost elements from off the stack and compares them, pushes back the result on the top of the stack.
-	OpNotEqual                    // pops 2 topmost elements from off the stack and compares them, pushes back the result on the top of the stack.
-	OpGreaterThan                 // pops 2 topmost elements from off the stack and compares them, pushes back the result on the top of the stack.
-	OpMinus                       // pops 1 topmost element from off the stack and negates it, pushes back the result on the top of the stack.
-	OpBang                        // pops 1 topmost element from off the stack and negates it, pushes back the result on the top of the stack.
-	OpJumpNotTruthy               // jumps to a certain address if the topmost element on the stack is not truthy
-	OpJump                        // jumps whatever the topmost element of the stack is.
-	OpNull                        // pushes an *object.Null on to the stack.
-	OpGetGlobal                   // gets global variable bound to its operand.
-	OpSetGlobal                   // sets global variable bound to its operand.
-	OpGetLocal                    // gets global variable bound to its operand.
-	OpSetLocal                    // sets local variable bound to its operand.
-	OpArray                       // tells how many elements the array has.
-	OpHash                        // tells how many keys and values the hash has.
-	OpIndex                       // pops 2 topmost elements off from the stack and performs the index operation, puts the result back on.
-	OpCall                        // calls function.
-	OpReturnValue                 // returns from function with return value. The returned value sits on top of the stack.
-	OpReturn                      // return from function with no explicit return value, but implicit vm.Null.
-	OpGetBuiltin                  // loads builtin function on to the stack.
-	OpClosure                     // tells VM to wrap the specified *object.CompiledFunction in an *object.Closure.
-	OpGetFree                     // tells the VM to retrieve free variables for the closure function.
+	OpConstant           Opcode = iota // sets constant value in constant pool.
+	OpAdd                              // pops 2 topmost elements from off the stack and adds them, pushes back on the top of the stack.
+	OpSub                              // pops 2 topmost elements from off the stack and subtracts them, pushes back on the top of the stack.
+	OpMul                              // pops 2 topmost elements from off the stack and multiplies them, pushes back on the top of the stack.
+	OpDiv                              // pops 2 topmost elements from off the stack and divides them, pushes back on the top of the stack.
+	OpPop                              // makes the stack clean after every expression statement.
+	OpTrue                             // pushes an *object.Boolean(true) on to the stack.
+	OpFalse                            // pushed an *object.Boolean(false) on to the stack.
+	OpEqual                            // pops 2 topmost elements from off the stack and compares them, pushes back the result on the top of the stack.
+	OpNotEqual                         // pops 2 topmost elements from off the stack and compares them, pushes back the result on the top of the stack.
+	OpGreaterThan                      // pops 2 topmost elements from off the stack and compares them, pushes back the result on the top of the stack.
+	OpMinus                            // pops 1 topmost element from off the stack and negates it, pushes back the result on the top of the stack.
+	OpBang                             // pops 1 topmost element from off the stack and negates it, pushes back the result on the top of the stack.
+	OpJumpNotTruthy                    // jumps to a certain address if the topmost element on the stack is not truthy
+	OpJump                             // jumps whatever the topmost element of the stack is.
+	OpNull                             // pushes an *object.Null on to the stack.
+	OpGetGlobal                        // gets global variable bound to its operand.
+	OpSetGlobal                        // sets global variable bound to its operand.
+	OpGetLocal                         // gets global variable bound to its operand.
+	OpSetLocal                         // sets local variable bound to its operand.
+	OpArray                            // tells how many elements the array has.
+	OpHash                             // tells how many keys and values the hash has.
+	OpIndex                            // pops 2 topmost elements off from the stack and performs the index operation, puts the result back on.
+	OpCall                             // calls function.
+	OpReturnValue                      // returns from function with return value. The returned value sits on top of the stack.
+	OpReturn                           // return from function with no explicit return value, but implicit vm.Null.
+	OpGetBuiltin                       // loads builtin function on to the stack.
+	OpClosure                          // tells VM to wrap the specified *object.CompiledFunction in an *object.Closure.
+	OpGetFree                          // tells the VM to retrieve free variables for the closure function.
+	OpLessThan                         // pops 2 topmost elements from off the stack and compares them, pushes back the result on the top of the stack.
+	OpLessThanOrEqual                  // pops 2 topmost elements from off the stack and compares them, pushes back the result on the top of the stack.
+	OpGreaterThanOrEqual               // pops 2 topmost elements from off the stack and compares them, pushes back the result on the top of the stack.
+	OpMod                              // pops 2 topmost elements from off the stack and takes the remainder, pushes back on the top of the stack.
+	OpBitAnd                           // pops 2 topmost integers from off the stack and ANDs their bits, pushes back on the top of the stack.
+	OpBitOr                            // pops 2 topmost integers from off the stack and ORs their bits, pushes back on the top of the stack.
+	OpBitXor                           // pops 2 topmost integers from off the stack and XORs their bits, pushes back on the top of the stack.
+	OpShiftLeft                        // pops 2 topmost integers from off the stack and shifts the first left, pushes back on the top of the stack.
+	OpShiftRight                       // pops 2 topmost integers from off the stack and shifts the first right, pushes back on the top of the stack.
+	OpSetFree                          // sets the free variable of the current closure specified by its operand.
+	OpSetIndex                         // pops a value, an index and a collection off the stack, stores the value at the index and pushes the value back.
+	OpDup2                             // duplicates the 2 topmost elements of the stack.
+	OpIter                             // pops a collection off the stack and pushes an *object.Iterator over it.
+	OpIterNext                         // advances the iterator on the top of the stack, pushing the next element(s) or popping it and jumping to its operand when exhausted.
+	OpCaptureLocal                     // pushes the upvalue cell for the local binding specified by its operand, to be captured by OpClosure.
+	OpCaptureFree                      // pushes the upvalue cell of the current closure specified by its operand, to be captured by OpClosure.
+	OpCurrentClosure                   // pushes the closure being executed, so that a function can call itself.
+	OpTailCall                         // calls a function like OpCall, reusing the current frame as the call is directly followed by OpReturnValue.
+	OpTuple                            // tells how many elements the tuple has.
 )
 
 type Definition struct {
@@ -48,35 +68,54 @@ type Definition struct {
 }
 
 var definitions = map[Opcode]*Definition{
-	OpConstant:      {"OpConstant", []int{2}},
-	OpAdd:           {"OpAdd", []int{}},
-	OpSub:           {"OpSub", []int{}},
-	OpMul:           {"OpMul", []int{}},
-	OpDiv:           {"OpDiv", []int{}},
-	OpPop:           {"OpPop", []int{}},
-	OpTrue:          {"OpTrue", []int{}},
-	OpFalse:         {"OpFalse", []int{}},
-	OpEqual:         {"OpEqual", []int{}},
-	OpNotEqual:      {"OpNotEqual", []int{}},
-	OpGreaterThan:   {"OpGreaterThan", []int{}},
-	OpMinus:         {"OpMinus", []int{}},
-	OpBang:          {"OpBang", []int{}},
-	OpJumpNotTruthy: {"OpJumpNotTruthy", []int{2}},
-	OpJump:          {"OpJump", []int{2}},
-	OpNull:          {"OpNull", []int{}},
-	OpGetGlobal:     {"OpGetGlobal", []int{2}},
-	OpSetGlobal:     {"OpSetGlobal", []int{2}},
-	OpGetLocal:      {"OpGetLocal", []int{1}},
-	OpSetLocal:      {"OpSetLocal", []int{1}},
-	OpArray:         {"OpArray", []int{2}},
-	OpHash:          {"OpHash", []int{2}},
-	OpIndex:         {"OpIndex", []int{}},
-	OpCall:          {"OpCall", []int{1}},
-	OpReturnValue:   {"OpReturnValue", []int{}},
-	OpReturn:        {"OpReturn", []int{}},
-	OpGetBuiltin:    {"OpGetBuiltin", []int{1}},
-	OpClosure:       {"OpClosure", []int{2, 1}},
-	OpGetFree:       {"OpGetFree", []int{1}},
+	OpConstant:           {"OpConstant", []int{2}},
+	OpAdd:                {"OpAdd", []int{}},
+	OpSub:                {"OpSub", []int{}},
+	OpMul:                {"OpMul", []int{}},
+	OpDiv:                {"OpDiv", []int{}},
+	OpPop:                {"OpPop", []int{}},
+	OpTrue:               {"OpTrue", []int{}},
+	OpFalse:              {"OpFalse", []int{}},
+	OpEqual:              {"OpEqual", []int{}},
+	OpNotEqual:           {"OpNotEqual", []int{}},
+	OpGreaterThan:        {"OpGreaterThan", []int{}},
+	OpMinus:              {"OpMinus", []int{}},
+	OpBang:               {"OpBang", []int{}},
+	OpJumpNotTruthy:      {"OpJumpNotTruthy", []int{2}},
+	OpJump:               {"OpJump", []int{2}},
+	OpNull:               {"OpNull", []int{}},
+	OpGetGlobal:          {"OpGetGlobal", []int{2}},
+	OpSetGlobal:          {"OpSetGlobal", []int{2}},
+	OpGetLocal:           {"OpGetLocal", []int{1}},
+	OpSetLocal:           {"OpSetLocal", []int{1}},
+	OpArray:              {"OpArray", []int{2}},
+	OpHash:               {"OpHash", []int{2}},
+	OpIndex:              {"OpIndex", []int{}},
+	OpCall:               {"OpCall", []int{1}},
+	OpReturnValue:        {"OpReturnValue", []int{}},
+	OpReturn:             {"OpReturn", []int{}},
+	OpGetBuiltin:         {"OpGetBuiltin", []int{2}},
+	OpClosure:            {"OpClosure", []int{2, 1}},
+	OpGetFree:            {"OpGetFree", []int{1}},
+	OpLessThan:           {"OpLessThan", []int{}},
+	OpLessThanOrEqual:    {"OpLessThanOrEqual", []int{}},
+	OpGreaterThanOrEqual: {"OpGreaterThanOrEqual", []int{}},
+	OpMod:                {"OpMod", []int{}},
+	OpBitAnd:             {"OpBitAnd", []int{}},
+	OpBitOr:              {"OpBitOr", []int{}},
+	OpBitXor:             {"OpBitXor", []int{}},
+	OpShiftLeft:          {"OpShiftLeft", []int{}},
+	OpShiftRight:         {"OpShiftRight", []int{}},
+	OpSetFree:            {"OpSetFree", []int{1}},
+	OpSetIndex:           {"OpSetIndex", []int{}},
+	OpDup2:               {"OpDup2", []int{}},
+	OpIter:               {"OpIter", []int{}},
+	OpIterNext:           {"OpIterNext", []int{2, 1}},
+	OpCaptureLocal:       {"OpCaptureLocal", []int{1}},
+	OpCaptureFree:        {"OpCaptureFree", []int{1}},
+	OpCurrentClosure:     {"OpCurrentClosure", []int{}},
+	OpTailCall:           {"OpTailCall", []int{1}},
+	OpTuple:              {"OpTuple", []int{2}},
 }
 
 func Lookup(op byte) (*Definition, error) {
@@ -165,3 +204,21 @@ func ReadUint16(ins Instructions) uint16 {
 func ReadUint8(ins Instructions) uint8 {
 	return uint8(ins[0])
 }
+
+// LineEntry tells that the instructions starting at Offset were generated from the source line Line.
+type LineEntry struct {
+	Offset int
+	Line   int
+}
+
+// LineTable maps instruction offsets to source lines. Entries are sorted by Offset.
+type LineTable []LineEntry
+
+// LineFor returns the source line of the instruction at offset, or 0 if it is unknown.
+func (lt LineTable) LineFor(offset int) int {
+	i := sort.Search(len(lt), func(i int) bool { return lt[i].Offset > offset })
+	if i == 0 {
+		return 0
+	}
+	return lt[i-1].Line
+}
@@ -16,6 +16,8 @@ func TestMake(t *testing.T) {
 		// OpGetLocal 0xFF
 		{OpClosure, []int{65534, 255}, []byte{byte(OpClosure), 255, 254, 255}},
 		// OpClosure 0xFF 0xFE 0xFF
+		{OpGetBuiltin, []int{300}, []byte{byte(OpGetBuiltin), 1, 44}},
+		// OpGetBuiltin 0x01 0x2C
 	}
 
 	for _, tt := range tests {
@@ -81,3 +83,30 @@ func TestReadOperands(t *testing.T) {
 		}
 	}
 }
+
+func TestLineTable(t *testing.T) {
+	lines := LineTable{
+		{Offset: 0, Line: 1},
+		{Offset: 3, Line: 2},
+		{Offset: 7, Line: 5},
+	}
+	tests := []struct {
+		offset   int
+		expected int
+	}{
+		{0, 1},
+		{2, 1},
+		{3, 2},
+		{6, 2},
+		{7, 5},
+		{100, 5},
+	}
+	for _, tt := range tests {
+		if got := lines.LineFor(tt.offset); got != tt.expected {
+			t.Errorf("wrong line for offset %d. want=%d, got=%d", tt.offset, tt.expected, got)
+		}
+	}
+	if got := (LineTable{}).LineFor(0); got != 0 {
+		t.Errorf("empty line table should return 0, got=%d", got)
+	}
+}
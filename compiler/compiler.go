@@ -5,7 +5,6 @@ import (
 	"monkey/ast"
 	"monkey/code"
 	"monkey/object"
-	"sort"
 )
 
 type Compiler struct {
@@ -13,6 +12,8 @@ type Compiler struct {
 	symbolTable *SymbolTable       // holds symbol table, where each identifier is associated with information like its scope.
 	scopes      []CompilationScope // is stack of compilation scopes.
 	scopeIndex  int
+	line        int              // is the source line of the node being compiled, recorded into the line table on emit.
+	builtins    *object.Registry // holds the builtins the symbol table resolves names to.
 }
 
 type EmittedInstruction struct {
@@ -24,34 +25,61 @@ type CompilationScope struct {
 	instructions        code.Instructions  // holds generated bytecode which will be executed by VM.
 	lastInstruction     EmittedInstruction // is the very last instruction the compiler emitted and
 	previousInstruction EmittedInstruction // is the one before of lastInstruction.
+	lines               code.LineTable     // maps the emitted instructions back to source lines.
+	loops               []*loop            // is the stack of loops enclosing the code being compiled, innermost last.
 }
 
+// loop keeps track of the jump targets of a loop being compiled.
+type loop struct {
+	start    int   // is the position of the loop condition, where `continue` jumps to.
+	breaks   []int // are the positions of the OpJump emitted for `break`, back-patched to the end of the loop.
+	iterator bool  // reports whether an iterator sits on the stack for the loop, which `break` has to pop.
+}
+
+// New returns a compiler for programs using the standard builtins.
 func New() *Compiler {
+	return NewWithBuiltins(object.NewStandardRegistry())
+}
+
+// NewWithBuiltins returns a compiler for programs using the builtins of r.
+// The bytecode it produces refers to builtins by their index in r, so it has to be run with the same registry.
+func NewWithBuiltins(r *object.Registry) *Compiler {
 	mainScope := CompilationScope{
 		instructions:        code.Instructions{},
 		lastInstruction:     EmittedInstruction{},
 		previousInstruction: EmittedInstruction{},
 	}
 	symbolTable := NewSymbolTable()
-	for i, v := range object.Builtins {
-		symbolTable.DefineBuiltin(i, v.Name)
-	}
+	symbolTable.DefineBuiltins(r)
 	return &Compiler{
 		constants:   []object.Object{},
 		symbolTable: symbolTable,
 		scopes:      []CompilationScope{mainScope},
 		scopeIndex:  0,
+		builtins:    r,
 	}
 }
 
+// NewWithState returns a compiler which continues from the symbol table and constants of a previous compilation.
+// The builtins are the ones defined in s with DefineBuiltins, the standard ones if there are none.
 func NewWithState(s *SymbolTable, constants []object.Object) *Compiler {
-	compiler := New()
+	builtins := s.Builtins()
+	if builtins == nil {
+		builtins = object.NewStandardRegistry()
+	}
+	compiler := NewWithBuiltins(builtins)
 	compiler.symbolTable = s
 	compiler.constants = constants
 	return compiler
 }
 
 func (c *Compiler) Compile(node ast.Node) error {
+	// instructions emitted while compiling this node are attributed to its source line.
+	if line := node.Pos().Line; line > 0 {
+		outerLine := c.line
+		c.line = line
+		defer func() { c.line = outerLine }()
+	}
 	switch node := node.(type) {
 	case *ast.Program:
 		for _, s := range node.Statements {
@@ -67,17 +95,8 @@ func (c *Compiler) Compile(node ast.Node) error {
 		}
 		c.emit(code.OpPop)
 	case *ast.InfixExpression:
-		if node.Operator == "<" {
-			err := c.Compile(node.Right)
-			if err != nil {
-				return err
-			}
-			err = c.Compile(node.Left)
-			if err != nil {
-				return err
-			}
-			c.emit(code.OpGreaterThan)
-			return nil
+		if node.Operator == "&&" || node.Operator == "||" {
+			return c.compileLogicalExpression(node)
 		}
 		err := c.Compile(node.Left)
 		if err != nil {
@@ -87,24 +106,9 @@ func (c *Compiler) Compile(node ast.Node) error {
 		if err != nil {
 			return err
 		}
-		switch node.Operator {
-		case "+":
-			c.emit(code.OpAdd)
-		case "-":
-			c.emit(code.OpSub)
-		case "*":
-			c.emit(code.OpMul)
-		case "/":
-			c.emit(code.OpDiv)
-		case ">":
-			c.emit(code.OpGreaterThan)
-		case "==":
-			c.emit(code.OpEqual)
-		case "!=":
-			c.emit(code.OpNotEqual)
-		default:
-			return fmt.Errorf("unknown operator: %s", node.Operator)
-		}
+		return c.emitBinaryOperator(node.Operator)
+	case *ast.AssignExpression:
+		return c.compileAssignExpression(node)
 	case *ast.PrefixExpression:
 		err := c.Compile(node.Right)
 		if err != nil {
@@ -121,6 +125,9 @@ func (c *Compiler) Compile(node ast.Node) error {
 	case *ast.IntegerLiteral:
 		integer := &object.Integer{Value: node.Value}
 		c.emit(code.OpConstant, c.addConstant(integer))
+	case *ast.FloatLiteral:
+		float := &object.Float{Value: node.Value}
+		c.emit(code.OpConstant, c.addConstant(float))
 	case *ast.Boolean:
 		if node.Value {
 			c.emit(code.OpTrue)
@@ -148,18 +155,18 @@ func (c *Compiler) Compile(node ast.Node) error {
 			}
 		}
 		c.emit(code.OpArray, len(node.Elements))
+	case *ast.TupleLiteral:
+		for _, el := range node.Elements {
+			err := c.Compile(el)
+			if err != nil {
+				return err
+			}
+		}
+		c.emit(code.OpTuple, len(node.Elements))
 	case *ast.HashLiteral:
-		// Since Golang does not guarantee a consistent order when iterating through the keys and values of a map,
-		// we need to manually sort the keys before we can compile them.
-		keys := []ast.Expression{}
-		for k := range node.Pairs {
-			keys = append(keys, k)
-		}
-		sort.Slice(keys, func(i, j int) bool {
-			return keys[i].String() < keys[j].String()
-		})
+		// the pairs are compiled in source order, which is the order the hash keeps them in.
 		// the order of the key first then the value is important for reconstructing this hash on our VM.
-		for _, k := range keys {
+		for _, k := range node.Keys {
 			err := c.Compile(k) // key first
 			if err != nil {
 				return err
@@ -183,8 +190,11 @@ func (c *Compiler) Compile(node ast.Node) error {
 			return err
 		}
 
+		// a block which does not end with an expression (e.g. `let` or `break`) produces null.
 		if c.lastInstructionIs(code.OpPop) {
 			c.removeLastPop()
+		} else {
+			c.emit(code.OpNull)
 		}
 
 		// Emit an `OpJump` with a bogus value
@@ -204,11 +214,33 @@ func (c *Compiler) Compile(node ast.Node) error {
 
 			if c.lastInstructionIs(code.OpPop) {
 				c.removeLastPop()
+			} else {
+				c.emit(code.OpNull)
 			}
 		}
 		// back-patching method: replace the operand of `OpJump` after emitting Alternative part.
 		afterAlternativePos := len(c.currentInstructions())
 		c.changeOperand(jumpPos, afterAlternativePos)
+	case *ast.WhileStatement:
+		return c.compileWhileStatement(node)
+	case *ast.ForStatement:
+		return c.compileForStatement(node)
+	case *ast.BreakStatement:
+		loops := c.scopes[c.scopeIndex].loops
+		if len(loops) == 0 {
+			return fmt.Errorf("break outside loop")
+		}
+		current := loops[len(loops)-1]
+		if current.iterator {
+			c.emit(code.OpPop)
+		}
+		current.breaks = append(current.breaks, c.emit(code.OpJump, 9999))
+	case *ast.ContinueStatement:
+		loops := c.scopes[c.scopeIndex].loops
+		if len(loops) == 0 {
+			return fmt.Errorf("continue outside loop")
+		}
+		c.emit(code.OpJump, loops[len(loops)-1].start)
 	case *ast.BlockStatement:
 		for _, s := range node.Statements {
 			err := c.Compile(s)
@@ -234,7 +266,13 @@ func (c *Compiler) Compile(node ast.Node) error {
 		}
 		c.loadSymbol(symbol)
 	case *ast.FunctionLiteral:
+		// a global function refers to itself through its global binding like any other global,
+		// so that reassigning the name is seen the same way as in the evaluator.
+		local := c.symbolTable.Outer != nil
 		c.enterScope() // entering new scope.
+		if node.Name != "" && local {
+			c.symbolTable.DefineFunctionName(node.Name)
+		}
 		for _, p := range node.Parameters {
 			c.symbolTable.Define(p.Value)
 		}
@@ -250,14 +288,17 @@ func (c *Compiler) Compile(node ast.Node) error {
 		}
 		freeSymbols := c.symbolTable.FreeSymbols
 		numLocals := c.symbolTable.numDefinitions
+		lines := c.currentLines()
 		instructions := c.leaveScope()
-		for _, s := range freeSymbols { // put free variables onto the stack
-			c.loadSymbol(s)
+		for _, s := range freeSymbols { // put the cells of free variables onto the stack
+			c.captureSymbol(s)
 		}
 		compiledFn := &object.CompiledFunction{
 			Instructions:  instructions,
 			NumLocals:     numLocals,
 			NumParameters: len(node.Parameters),
+			Name:          node.Name,
+			Lines:         lines,
 		}
 		fnIndex := c.addConstant(compiledFn)
 		c.emit(code.OpClosure, fnIndex, len(freeSymbols))
@@ -266,6 +307,10 @@ func (c *Compiler) Compile(node ast.Node) error {
 		if err != nil {
 			return err
 		}
+		// the main program has no frame of its own to reuse, so a top-level `return f()` stays a plain call.
+		if c.lastInstructionIs(code.OpCall) && c.scopeIndex > 0 {
+			c.convertToTailCall(c.scopes[c.scopeIndex].lastInstruction)
+		}
 		c.emit(code.OpReturnValue)
 	case *ast.CallExpression:
 		err := c.Compile(node.Function)
@@ -283,16 +328,36 @@ func (c *Compiler) Compile(node ast.Node) error {
 	return nil
 }
 
+// DefineGlobal declares a global binding whose value is provided by the host program rather than by a let statement,
+// e.g. a Go function registered with vm.Register. It has to be called before compiling code that refers to name.
+func (c *Compiler) DefineGlobal(name string) Symbol {
+	return c.globalSymbolTable().Define(name)
+}
+
 func (c *Compiler) Bytecode() *Bytecode { // returns the bytecode the compiler produced.
 	return &Bytecode{
 		Instructions: c.currentInstructions(),
 		Constants:    c.constants,
+		Lines:        c.currentLines(),
+		Globals:      c.globalSymbolTable().Globals(),
+		Builtins:     c.builtins,
 	}
 }
 
 type Bytecode struct {
 	Instructions code.Instructions // holds generated bytecode which will be executed by VM.
 	Constants    []object.Object   // serves as constant pool. each object is already evaluated by compiler.
+	Lines        code.LineTable    // maps Instructions back to source lines.
+	Globals      map[string]int    // maps the names of global bindings to their index in the globals store.
+	Builtins     *object.Registry  // holds the builtins OpGetBuiltin refers to by index.
+}
+
+func (c *Compiler) globalSymbolTable() *SymbolTable { // returns the outermost symbol table, which holds global bindings.
+	s := c.symbolTable
+	for s.Outer != nil {
+		s = s.Outer
+	}
+	return s
 }
 
 func (c *Compiler) addConstant(obj object.Object) int {
@@ -304,9 +369,25 @@ func (c *Compiler) emit(op code.Opcode, operands ...int) int {
 	ins := code.Make(op, operands...)
 	pos := c.addInstruction(ins)
 	c.setLastInstruction(op, pos)
+	c.addLine(pos)
 	return pos
 }
 
+func (c *Compiler) addLine(pos int) { // records that the instruction at pos comes from the current source line.
+	if c.line == 0 {
+		return
+	}
+	lines := c.scopes[c.scopeIndex].lines
+	if len(lines) > 0 && lines[len(lines)-1].Line == c.line {
+		return
+	}
+	c.scopes[c.scopeIndex].lines = append(lines, code.LineEntry{Offset: pos, Line: c.line})
+}
+
+func (c *Compiler) currentLines() code.LineTable {
+	return c.scopes[c.scopeIndex].lines
+}
+
 func (c *Compiler) addInstruction(ins []byte) int {
 	posNewInstruction := len(c.currentInstructions())
 	updatedInstructions := append(c.currentInstructions(), ins...)
@@ -335,6 +416,11 @@ func (c *Compiler) removeLastPop() {
 	new_ := old[:last.Position]
 	c.scopes[c.scopeIndex].instructions = new_
 	c.scopes[c.scopeIndex].lastInstruction = previous
+	lines := c.currentLines() // drop the line entries of the removed instruction.
+	for len(lines) > 0 && lines[len(lines)-1].Offset >= last.Position {
+		lines = lines[:len(lines)-1]
+	}
+	c.scopes[c.scopeIndex].lines = lines
 }
 
 func (c *Compiler) replaceInstruction(pos int, newInstruction []byte) { // What is this function doing ?
@@ -344,6 +430,230 @@ func (c *Compiler) replaceInstruction(pos int, newInstruction []byte) { // What
 	}
 }
 
+// compileWhileStatement compiles a while loop as
+//
+//	start: condition; JNT end; body; Jump start; end:
+//
+// `continue` jumps to start and `break` jumps to end. Like any other statement, the loop
+// produces null: end pushes and pops OpNull so nothing is left on the stack.
+func (c *Compiler) compileWhileStatement(node *ast.WhileStatement) error {
+	l := &loop{start: len(c.currentInstructions())}
+	err := c.Compile(node.Condition)
+	if err != nil {
+		return err
+	}
+	jumpNotTruthyPos := c.emit(code.OpJumpNotTruthy, 9999)
+
+	scope := &c.scopes[c.scopeIndex]
+	scope.loops = append(scope.loops, l)
+	err = c.Compile(node.Body)
+	scope = &c.scopes[c.scopeIndex]
+	scope.loops = scope.loops[:len(scope.loops)-1]
+	if err != nil {
+		return err
+	}
+	c.emit(code.OpJump, l.start)
+
+	afterLoopPos := len(c.currentInstructions())
+	c.changeOperand(jumpNotTruthyPos, afterLoopPos)
+	for _, pos := range l.breaks {
+		c.changeOperand(pos, afterLoopPos)
+	}
+	c.emit(code.OpNull)
+	c.emit(code.OpPop)
+	return nil
+}
+
+// compileForStatement compiles a for-in loop as
+//
+//	iterable; Iter; start: IterNext end n; store variables; body; Jump start; end:
+//
+// The iterator stays on the stack while the loop runs. OpIterNext pops it once exhausted
+// and `break` pops it before jumping to end. `continue` jumps to start. As with while,
+// end pushes and pops OpNull so the loop produces null.
+func (c *Compiler) compileForStatement(node *ast.ForStatement) error {
+	err := c.Compile(node.Iterable)
+	if err != nil {
+		return err
+	}
+	c.emit(code.OpIter)
+
+	numVars := 1
+	if node.Key != nil {
+		numVars = 2
+	}
+	l := &loop{start: len(c.currentInstructions()), iterator: true}
+	iterNextPos := c.emit(code.OpIterNext, 9999, numVars)
+
+	// OpIterNext pushes the key below the value, so the value is stored first.
+	value := c.symbolTable.Define(node.Value.Value)
+	c.storeSymbol(value)
+	if node.Key != nil {
+		key := c.symbolTable.Define(node.Key.Value)
+		c.storeSymbol(key)
+	}
+
+	scope := &c.scopes[c.scopeIndex]
+	scope.loops = append(scope.loops, l)
+	err = c.Compile(node.Body)
+	scope = &c.scopes[c.scopeIndex]
+	scope.loops = scope.loops[:len(scope.loops)-1]
+	if err != nil {
+		return err
+	}
+	c.emit(code.OpJump, l.start)
+
+	afterLoopPos := len(c.currentInstructions())
+	// changeOperand only rewrites the first operand, so the whole instruction is rebuilt here.
+	c.replaceInstruction(iterNextPos, code.Make(code.OpIterNext, afterLoopPos, numVars))
+	for _, pos := range l.breaks {
+		c.changeOperand(pos, afterLoopPos)
+	}
+	c.emit(code.OpNull)
+	c.emit(code.OpPop)
+	return nil
+}
+
+// compileAssignExpression compiles an assignment so that the assigned value is left on the stack.
+// Compound assignments load the current value first and apply the binary operator before storing.
+func (c *Compiler) compileAssignExpression(node *ast.AssignExpression) error {
+	operator := node.BinaryOperator()
+	switch target := node.Target.(type) {
+	case *ast.Identifier:
+		symbol, ok := c.symbolTable.Resolve(target.Value)
+		if !ok {
+			return fmt.Errorf("undefined variable %s", target.Value)
+		}
+		if symbol.Scope == BuiltinScope {
+			return fmt.Errorf("cannot assign to builtin %s", target.Value)
+		}
+		if symbol.Scope == FunctionScope {
+			return fmt.Errorf("cannot assign to function %s inside its own body", target.Value)
+		}
+		if operator != "" {
+			c.loadSymbol(symbol)
+		}
+		err := c.Compile(node.Value)
+		if err != nil {
+			return err
+		}
+		if operator != "" {
+			err = c.emitBinaryOperator(operator)
+			if err != nil {
+				return err
+			}
+		}
+		c.storeSymbol(symbol)
+		c.loadSymbol(symbol)
+	case *ast.IndexExpression:
+		err := c.Compile(target.Left)
+		if err != nil {
+			return err
+		}
+		err = c.Compile(target.Index)
+		if err != nil {
+			return err
+		}
+		if operator != "" {
+			// keep the collection and the index for OpSetIndex while reading the current element.
+			c.emit(code.OpDup2)
+			c.emit(code.OpIndex)
+		}
+		err = c.Compile(node.Value)
+		if err != nil {
+			return err
+		}
+		if operator != "" {
+			err = c.emitBinaryOperator(operator)
+			if err != nil {
+				return err
+			}
+		}
+		c.emit(code.OpSetIndex)
+	default:
+		return fmt.Errorf("cannot assign to %s", node.Target.String())
+	}
+	return nil
+}
+
+// emitBinaryOperator emits the opcode for a binary (infix) operator whose operands are already on the stack.
+func (c *Compiler) emitBinaryOperator(operator string) error {
+	switch operator {
+	case "+":
+		c.emit(code.OpAdd)
+	case "-":
+		c.emit(code.OpSub)
+	case "*":
+		c.emit(code.OpMul)
+	case "/":
+		c.emit(code.OpDiv)
+	case "%":
+		c.emit(code.OpMod)
+	case "&":
+		c.emit(code.OpBitAnd)
+	case "|":
+		c.emit(code.OpBitOr)
+	case "^":
+		c.emit(code.OpBitXor)
+	case "<<":
+		c.emit(code.OpShiftLeft)
+	case ">>":
+		c.emit(code.OpShiftRight)
+	case ">":
+		c.emit(code.OpGreaterThan)
+	case "<":
+		c.emit(code.OpLessThan)
+	case ">=":
+		c.emit(code.OpGreaterThanOrEqual)
+	case "<=":
+		c.emit(code.OpLessThanOrEqual)
+	case "==":
+		c.emit(code.OpEqual)
+	case "!=":
+		c.emit(code.OpNotEqual)
+	default:
+		return fmt.Errorf("unknown operator: %s", operator)
+	}
+	return nil
+}
+
+// compileLogicalExpression compiles `&&` and `||` into jumps so that the right operand is only evaluated when needed.
+// Either way the result is a boolean:
+//
+//	a && b:  a; JNT false; b; JNT false; True; Jump end; false: False; end:
+//	a || b:  a; Bang; JNT true; b; JNT false; true: True; Jump end; false: False; end:
+func (c *Compiler) compileLogicalExpression(node *ast.InfixExpression) error {
+	err := c.Compile(node.Left)
+	if err != nil {
+		return err
+	}
+	var jumpTruePos int
+	if node.Operator == "||" {
+		c.emit(code.OpBang)
+		jumpTruePos = c.emit(code.OpJumpNotTruthy, 9999)
+	}
+	jumpFalsePos := []int{}
+	if node.Operator == "&&" {
+		jumpFalsePos = append(jumpFalsePos, c.emit(code.OpJumpNotTruthy, 9999))
+	}
+	err = c.Compile(node.Right)
+	if err != nil {
+		return err
+	}
+	jumpFalsePos = append(jumpFalsePos, c.emit(code.OpJumpNotTruthy, 9999))
+	if node.Operator == "||" {
+		c.changeOperand(jumpTruePos, len(c.currentInstructions()))
+	}
+	c.emit(code.OpTrue)
+	jumpEndPos := c.emit(code.OpJump, 9999)
+	for _, pos := range jumpFalsePos {
+		c.changeOperand(pos, len(c.currentInstructions()))
+	}
+	c.emit(code.OpFalse)
+	c.changeOperand(jumpEndPos, len(c.currentInstructions()))
+	return nil
+}
+
 func (c *Compiler) changeOperand(opPos int, operand int) { // recreate the instructions with the new operand and replace them.
 	op := code.Opcode(c.currentInstructions()[opPos])
 	newInstruction := code.Make(op, operand)
@@ -377,6 +687,49 @@ func (c *Compiler) replaceLastPopWithReturn() {
 	lastPos := c.scopes[c.scopeIndex].lastInstruction.Position
 	c.replaceInstruction(lastPos, code.Make(code.OpReturnValue)) // this is meaningless ?
 	c.scopes[c.scopeIndex].lastInstruction.Opcode = code.OpReturnValue
+	previous := c.scopes[c.scopeIndex].previousInstruction
+	if previous.Opcode == code.OpCall && previous.Position+2 == lastPos {
+		c.convertToTailCall(previous)
+	}
+}
+
+// convertToTailCall rewrites the OpCall ins, which is directly followed by OpReturnValue, into OpTailCall.
+// Both take the number of arguments as their operand, so only the opcode changes.
+func (c *Compiler) convertToTailCall(ins EmittedInstruction) {
+	c.currentInstructions()[ins.Position] = byte(code.OpTailCall)
+	scope := &c.scopes[c.scopeIndex]
+	if scope.lastInstruction.Position == ins.Position {
+		scope.lastInstruction.Opcode = code.OpTailCall
+	}
+	if scope.previousInstruction.Position == ins.Position {
+		scope.previousInstruction.Opcode = code.OpTailCall
+	}
+}
+
+// storeSymbol emits the instruction which pops the topmost element and binds it to s.
+func (c *Compiler) storeSymbol(s Symbol) {
+	switch s.Scope {
+	case GlobalScope:
+		c.emit(code.OpSetGlobal, s.Index)
+	case LocalScope:
+		c.emit(code.OpSetLocal, s.Index)
+	case FreeScope:
+		c.emit(code.OpSetFree, s.Index)
+	}
+}
+
+// captureSymbol emits the instruction which pushes the upvalue cell holding s, so that
+// the closure shares the variable with the enclosing function instead of copying its value.
+func (c *Compiler) captureSymbol(s Symbol) {
+	switch s.Scope {
+	case LocalScope:
+		c.emit(code.OpCaptureLocal, s.Index)
+	case FreeScope:
+		c.emit(code.OpCaptureFree, s.Index)
+	case FunctionScope:
+		// the closure itself never changes, so OpClosure captures it in a closed cell.
+		c.emit(code.OpCurrentClosure)
+	}
 }
 
 func (c *Compiler) loadSymbol(s Symbol) {
@@ -389,5 +742,7 @@ func (c *Compiler) loadSymbol(s Symbol) {
 		c.emit(code.OpGetBuiltin, s.Index)
 	case FreeScope:
 		c.emit(code.OpGetFree, s.Index)
+	case FunctionScope:
+		c.emit(code.OpCurrentClosure)
 	}
 }
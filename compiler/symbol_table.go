@@ -1,12 +1,15 @@
 package compiler
 
+import "monkey/object"
+
 type SymbolScope string
 
 const (
-	BuiltinScope SymbolScope = "BUILTIN"
-	GlobalScope  SymbolScope = "GLOBAL"
-	LocalScope   SymbolScope = "LOCAL"
-	FreeScope    SymbolScope = "FREE"
+	BuiltinScope  SymbolScope = "BUILTIN"
+	GlobalScope   SymbolScope = "GLOBAL"
+	LocalScope    SymbolScope = "LOCAL"
+	FreeScope     SymbolScope = "FREE"
+	FunctionScope SymbolScope = "FUNCTION"
 )
 
 type Symbol struct {
@@ -20,6 +23,7 @@ type SymbolTable struct {
 	store          map[string]Symbol
 	numDefinitions int
 	FreeSymbols    []Symbol
+	builtins       *object.Registry // is the registry DefineBuiltins defined the builtins of.
 }
 
 func NewSymbolTable() *SymbolTable {
@@ -68,6 +72,40 @@ func (s *SymbolTable) DefineBuiltin(index int, name string) Symbol {
 	return symbol
 }
 
+// DefineBuiltins defines every builtin of r under its registered name, indexed as in r,
+// and remembers r as the registry the code compiled with s refers to.
+func (s *SymbolTable) DefineBuiltins(r *object.Registry) {
+	for i, name := range r.Names() {
+		s.DefineBuiltin(i, name)
+	}
+	s.builtins = r
+}
+
+// Builtins returns the registry whose builtins were defined with DefineBuiltins, or nil.
+func (s *SymbolTable) Builtins() *object.Registry {
+	return s.builtins
+}
+
+// DefineFunctionName defines the name a function literal is bound to inside its own body,
+// so that the function can refer to itself through OpCurrentClosure without capturing the binding.
+func (s *SymbolTable) DefineFunctionName(name string) Symbol {
+	symbol := Symbol{Name: name, Index: 0, Scope: FunctionScope}
+	s.store[name] = symbol
+	return symbol
+}
+
+// Globals returns the index in the globals store of every global binding, by name.
+// It is called on the outermost symbol table.
+func (s *SymbolTable) Globals() map[string]int {
+	globals := make(map[string]int)
+	for name, symbol := range s.store {
+		if symbol.Scope == GlobalScope {
+			globals[name] = symbol.Index
+		}
+	}
+	return globals
+}
+
 func (s *SymbolTable) defineFree(original Symbol) Symbol {
 	s.FreeSymbols = append(s.FreeSymbols, original)
 	symbol := Symbol{Name: original.Name, Index: len(s.FreeSymbols) - 1}
@@ -2,19 +2,32 @@ package evaluator
 
 import (
 	"fmt"
+	"math"
 	"monkey/ast"
 	"monkey/object"
 )
 
 var (
-	NULL  = &object.Null{}
-	TRUE  = &object.Boolean{Value: true}
-	FALSE = &object.Boolean{Value: false}
+	NULL  = object.NULL
+	TRUE  = object.TRUE
+	FALSE = object.FALSE
+
+	// ループの制御を伝えるためのシグナル
+	BREAK    = &object.Break{}
+	CONTINUE = &object.Continue{}
 )
 
 // ast.Node型を受け取り評価して、適切なobject.Objectを返す
+// envに制限（object.Budget）が課されていれば、ノードを一つ評価するたびに1ステップ消費する
 func Eval(node ast.Node, env *object.Environment) object.Object {
 
+	// 制限に達したら評価を打ち切る
+	if budget := env.Budget(); budget != nil {
+		if err := budget.Step(); err != nil {
+			return newError("%s", err)
+		}
+	}
+
 	// 引数nodeの型によって処理を振り分ける
 	switch node := node.(type) {
 
@@ -27,38 +40,54 @@ func Eval(node ast.Node, env *object.Environment) object.Object {
 		return Eval(node.Expression, env)
 	case *ast.LetStatement:
 		val := Eval(node.Value, env)
-		if isError(val) {
+		if isSignal(val) {
 			return val
 		}
 		env.Set(node.Name.Value, val)
 	case *ast.ReturnStatement:
 		val := Eval(node.ReturnValue, env)
-		if isError(val) {
+		if isSignal(val) {
 			return val
 		}
 		return &object.ReturnValue{Value: val}
+	case *ast.WhileStatement:
+		return evalWhileStatement(node, env)
+	case *ast.ForStatement:
+		return evalForStatement(node, env)
+	case *ast.BreakStatement:
+		return BREAK
+	case *ast.ContinueStatement:
+		return CONTINUE
 
 	// 式だった
 	case *ast.IntegerLiteral:
 		return &object.Integer{Value: node.Value}
+	case *ast.FloatLiteral:
+		return &object.Float{Value: node.Value}
 	case *ast.Boolean:
 		return nativeBoolToBooleanObject(node.Value)
 	case *ast.PrefixExpression:
 		right := Eval(node.Right, env)
-		if isError(right) {
+		if isSignal(right) {
 			return right
 		}
-		return evalPrefixExpression(node.Operator, right)
+		return allocate(evalPrefixExpression(node.Operator, right), env)
+	case *ast.AssignExpression:
+		return evalAssignExpression(node, env)
 	case *ast.InfixExpression:
 		left := Eval(node.Left, env)
-		if isError(left) {
+		if isSignal(left) {
 			return left
 		}
+		// &&と||は左辺だけで結果が決まれば右辺を評価しない
+		if node.Operator == "&&" || node.Operator == "||" {
+			return evalLogicalExpression(node, left, env)
+		}
 		right := Eval(node.Right, env)
-		if isError(right) {
+		if isSignal(right) {
 			return right
 		}
-		return evalInfixExpression(node.Operator, left, right)
+		return allocate(evalInfixExpression(node.Operator, left, right), env)
 	case *ast.IfExpression:
 		return evalIfExpression(node, env)
 	case *ast.Identifier:
@@ -69,34 +98,44 @@ func Eval(node ast.Node, env *object.Environment) object.Object {
 		return &object.Function{Parameters: params, Body: body, Env: env}
 	case *ast.CallExpression:
 		function := Eval(node.Function, env)
-		if isError(function) {
+		if isSignal(function) {
 			return function
 		}
 		args := evalExpressions(node.Arguments, env)
-		if len(args) == 1 && isError(args[0]) {
+		if len(args) == 1 && isSignal(args[0]) {
 			return args[0]
 		}
-		return applyFunction(function, args)
+		if node.Tail {
+			// 末尾呼び出しはここでは実行せず、呼び出し元のapplyFunctionに任せる
+			return &object.TailCall{Fn: function, Args: args}
+		}
+		return applyFunction(function, args, env)
 	case *ast.StringLiteral:
 		return &object.String{Value: node.Value}
 	case *ast.ArrayLiteral:
 		elements := evalExpressions(node.Elements, env)
-		if len(elements) == 1 && isError(elements[0]) {
+		if len(elements) == 1 && isSignal(elements[0]) {
+			return elements[0]
+		}
+		return allocate(&object.Array{Elements: elements}, env)
+	case *ast.TupleLiteral:
+		elements := evalExpressions(node.Elements, env)
+		if len(elements) == 1 && isSignal(elements[0]) {
 			return elements[0]
 		}
-		return &object.Array{Elements: elements}
+		return allocate(&object.Tuple{Elements: elements}, env)
 	case *ast.IndexExpression:
 		left := Eval(node.Left, env)
-		if isError(left) {
+		if isSignal(left) {
 			return left
 		}
 		index := Eval(node.Index, env)
-		if isError(index) {
+		if isSignal(index) {
 			return index
 		}
 		return evalIndexExpression(left, index)
 	case *ast.HashLiteral:
-		return evalHashLiteral(node, env)
+		return allocate(evalHashLiteral(node, env), env)
 	}
 
 	return nil
@@ -155,24 +194,31 @@ func evalBangOperatorExpression(right object.Object) object.Object {
 func evalMinusPrefixOperatorExpression(right object.Object) object.Object {
 
 	// 演算子-のサポートしていない型に対して作用させようとしているときにはErrorObjectを返す
-	if right.Type() != object.INTEGER_OBJ {
+	switch right := right.(type) {
+	case *object.Integer, *object.BigInt:
+		return object.NegateInteger(right)
+	case *object.Float:
+		return &object.Float{Value: -right.Value}
+	default:
 		return newError("unknown operator: -%s", right.Type())
 	}
-	value := right.(*object.Integer).Value
-	return &object.Integer{Value: -value}
 }
 
 // 中置式を構成するオペランドに応じて適切な評価関数へ処理を振り分けるヘルパー関数
 func evalInfixExpression(operator string, left, right object.Object) object.Object {
 	switch {
-	case left.Type() == object.INTEGER_OBJ && right.Type() == object.INTEGER_OBJ:
+	case object.IsInteger(left) && object.IsInteger(right):
 		return evalIntegerInfixExpression(operator, left, right)
-	case left.Type() == object.STRING_OBJ && right.Type() == object.STRING_OBJ:
-		return evalStringInfixExpression(operator, left, right)
+	case object.IsNumber(left) && object.IsNumber(right):
+		// 整数と浮動小数点数が混在する場合は浮動小数点数として計算する
+		return evalFloatInfixExpression(operator, left, right)
 	case operator == "==":
-		return nativeBoolToBooleanObject(left == right)
+		// 数値以外はobject.Equalで中身を比べる
+		return nativeBoolToBooleanObject(object.Equal(left, right))
 	case operator == "!=":
-		return nativeBoolToBooleanObject(left != right)
+		return nativeBoolToBooleanObject(!object.Equal(left, right))
+	case left.Type() == object.STRING_OBJ && right.Type() == object.STRING_OBJ:
+		return evalStringInfixExpression(operator, left, right)
 	case left.Type() != right.Type():
 		return newError("type mismatch: %s %s %s",
 			left.Type(), operator, right.Type())
@@ -183,22 +229,96 @@ func evalInfixExpression(operator string, left, right object.Object) object.Obje
 }
 
 // 整数による中置式を評価してObjectを返すヘルパー関数
+// int64の範囲を超える結果はBigIntに昇格する
 func evalIntegerInfixExpression(operator string, left, right object.Object) object.Object {
-	leftVal := left.(*object.Integer).Value
-	rightVal := right.(*object.Integer).Value
 	switch operator {
 	case "+":
-		return &object.Integer{Value: leftVal + rightVal}
+		return object.AddIntegers(left, right)
+	case "-":
+		return object.SubIntegers(left, right)
+	case "*":
+		return object.MulIntegers(left, right)
+	case "/":
+		return integerResult(object.DivIntegers(left, right))
+	case "%":
+		return integerResult(object.ModIntegers(left, right))
+	case "&":
+		return object.AndIntegers(left, right)
+	case "|":
+		return object.OrIntegers(left, right)
+	case "^":
+		return object.XorIntegers(left, right)
+	case "<<":
+		return integerResult(object.ShiftLeft(left, right))
+	case ">>":
+		return integerResult(object.ShiftRight(left, right))
+	case "<":
+		return nativeBoolToBooleanObject(object.CompareIntegers(left, right) < 0)
+	case ">":
+		return nativeBoolToBooleanObject(object.CompareIntegers(left, right) > 0)
+	case "<=":
+		return nativeBoolToBooleanObject(object.CompareIntegers(left, right) <= 0)
+	case ">=":
+		return nativeBoolToBooleanObject(object.CompareIntegers(left, right) >= 0)
+	case "==":
+		return nativeBoolToBooleanObject(object.CompareIntegers(left, right) == 0)
+	case "!=":
+		return nativeBoolToBooleanObject(object.CompareIntegers(left, right) != 0)
+	default:
+		return newError("unknown operator: %s %s %s",
+			left.Type(), operator, right.Type())
+	}
+}
+
+// 整数演算ヘルパーの結果をObjectに変換する
+// エラーはErrorObjectにする
+func integerResult(result object.Object, err error) object.Object {
+	if err != nil {
+		return newError("%s", err)
+	}
+	return result
+}
+
+// &&と||を評価するヘルパー関数
+// 結果は常に真偽値になる
+func evalLogicalExpression(node *ast.InfixExpression, left object.Object, env *object.Environment) object.Object {
+	if node.Operator == "&&" && !isTruthy(left) {
+		return FALSE
+	}
+	if node.Operator == "||" && isTruthy(left) {
+		return TRUE
+	}
+	right := Eval(node.Right, env)
+	if isSignal(right) {
+		return right
+	}
+	return nativeBoolToBooleanObject(isTruthy(right))
+}
+
+// 浮動小数点数による中置式を評価してObjectを返すヘルパー関数
+// 整数のオペランドは浮動小数点数に変換してから計算する
+func evalFloatInfixExpression(operator string, left, right object.Object) object.Object {
+	leftVal := object.ToFloat(left)
+	rightVal := object.ToFloat(right)
+	switch operator {
+	case "+":
+		return &object.Float{Value: leftVal + rightVal}
 	case "-":
-		return &object.Integer{Value: leftVal - rightVal}
+		return &object.Float{Value: leftVal - rightVal}
 	case "*":
-		return &object.Integer{Value: leftVal * rightVal}
+		return &object.Float{Value: leftVal * rightVal}
 	case "/":
-		return &object.Integer{Value: leftVal / rightVal}
+		return &object.Float{Value: leftVal / rightVal}
+	case "%":
+		return &object.Float{Value: math.Mod(leftVal, rightVal)}
 	case "<":
 		return nativeBoolToBooleanObject(leftVal < rightVal)
 	case ">":
 		return nativeBoolToBooleanObject(leftVal > rightVal)
+	case "<=":
+		return nativeBoolToBooleanObject(leftVal <= rightVal)
+	case ">=":
+		return nativeBoolToBooleanObject(leftVal >= rightVal)
 	case "==":
 		return nativeBoolToBooleanObject(leftVal == rightVal)
 	case "!=":
@@ -212,7 +332,7 @@ func evalIntegerInfixExpression(operator string, left, right object.Object) obje
 // IfExpression型のASTノードを引数にとって評価して適切なObjectを返すヘルパー関数
 func evalIfExpression(ie *ast.IfExpression, env *object.Environment) object.Object {
 	condition := Eval(ie.Condition, env)
-	if isError(condition) {
+	if isSignal(condition) {
 		return condition
 	}
 	if isTruthy(condition) {
@@ -239,8 +359,15 @@ func isTruthy(obj object.Object) bool {
 }
 
 // プログラムを評価してObjectを返すヘルパー関数
-func evalProgram(program *ast.Program, env *object.Environment) object.Object {
-	var result object.Object
+func evalProgram(program *ast.Program, env *object.Environment) (result object.Object) {
+
+	// 評価中にGoのpanicが起きてもホストごと落ちないようにErrorObjectに変換する
+	defer func() {
+		if r := recover(); r != nil {
+			result = newError("internal error: %v", r)
+		}
+	}()
+
 	for _, statement := range program.Statements {
 
 		// プログラムを構成する一文一文を一つずつ評価していく
@@ -267,7 +394,9 @@ func evalBlockStatement(block *ast.BlockStatement, env *object.Environment) obje
 
 		if result != nil {
 			rt := result.Type()
-			if rt == object.RETURN_VALUE_OBJ || rt == object.ERROR_OBJ {
+			// break・continueもループまで伝える
+			if rt == object.RETURN_VALUE_OBJ || rt == object.ERROR_OBJ ||
+				rt == object.BREAK_OBJ || rt == object.CONTINUE_OBJ {
 				return result
 			}
 		}
@@ -275,6 +404,67 @@ func evalBlockStatement(block *ast.BlockStatement, env *object.Environment) obje
 	return result
 }
 
+// WHILE文を評価するヘルパー関数
+// 文なので値はNULLになる
+func evalWhileStatement(ws *ast.WhileStatement, env *object.Environment) object.Object {
+	for {
+		condition := Eval(ws.Condition, env)
+		if isSignal(condition) {
+			return condition
+		}
+		if !isTruthy(condition) {
+			return NULL
+		}
+		result := Eval(ws.Body, env)
+		if result != nil {
+			switch result.Type() {
+			case object.BREAK_OBJ:
+				return NULL
+			case object.RETURN_VALUE_OBJ, object.ERROR_OBJ:
+				return result
+			}
+		}
+	}
+}
+
+// FOR文を評価するヘルパー関数
+// ループ変数は現在の環境に束縛する
+func evalForStatement(fs *ast.ForStatement, env *object.Environment) object.Object {
+	iterable := Eval(fs.Iterable, env)
+	if isSignal(iterable) {
+		return iterable
+	}
+	iterator, ok := object.NewIterator(iterable)
+	if !ok {
+		return newError("cannot iterate over %s", iterable.Type())
+	}
+	for {
+		if fs.Key != nil {
+			key, value, ok := iterator.Next()
+			if !ok {
+				return NULL
+			}
+			env.Set(fs.Key.Value, key)
+			env.Set(fs.Value.Value, value)
+		} else {
+			element, ok := iterator.NextElement()
+			if !ok {
+				return NULL
+			}
+			env.Set(fs.Value.Value, element)
+		}
+		result := Eval(fs.Body, env)
+		if result != nil {
+			switch result.Type() {
+			case object.BREAK_OBJ:
+				return NULL
+			case object.RETURN_VALUE_OBJ, object.ERROR_OBJ:
+				return result
+			}
+		}
+	}
+}
+
 // フォーマットと内容を引数にエラーメッセージを格納したErrorObjectを返すヘルパー関数
 func newError(format string, a ...interface{}) *object.Error {
 	return &object.Error{Message: fmt.Sprintf(format, a...)}
@@ -288,17 +478,123 @@ func isError(obj object.Object) bool {
 	return false
 }
 
+// 引数objが評価を中断して外側へ伝えるべきObject（エラー、return、break、continue）であるかを確認するヘルパー関数
+// 式や文の途中でこれに出くわしたら、残りを評価せずにそのまま返す
+func isSignal(obj object.Object) bool {
+	if obj == nil {
+		return false
+	}
+	switch obj.Type() {
+	case object.ERROR_OBJ, object.RETURN_VALUE_OBJ, object.BREAK_OBJ, object.CONTINUE_OBJ:
+		return true
+	}
+	return false
+}
+
 // Identifier型のASTノードを引数に環境内に登録されている対応するObjectを返すヘルパー関数
 func evalIdentifier(node *ast.Identifier, env *object.Environment) object.Object {
 	if val, ok := env.Get(node.Value); ok {
 		return val
 	}
-	if builtin, ok := builtin[node.Value]; ok {
+	if builtin, ok := env.Builtins().Lookup(node.Value); ok {
 		return builtin
 	}
 	return newError("identifier not found: " + node.Value)
 }
 
+// 代入式を評価して代入した値を返すヘルパー関数
+func evalAssignExpression(node *ast.AssignExpression, env *object.Environment) object.Object {
+	switch target := node.Target.(type) {
+	case *ast.Identifier:
+		current, ok := env.Get(target.Value)
+		if !ok {
+			if _, ok := env.Builtins().Lookup(target.Value); ok {
+				return newError("cannot assign to builtin %s", target.Value)
+			}
+			return newError("identifier not found: " + target.Value)
+		}
+		val := evalAssignedValue(node, current, env)
+		if isSignal(val) {
+			return val
+		}
+		env.Assign(target.Value, val)
+		return val
+	case *ast.IndexExpression:
+		left := Eval(target.Left, env)
+		if isSignal(left) {
+			return left
+		}
+		index := Eval(target.Index, env)
+		if isSignal(index) {
+			return index
+		}
+		var current object.Object
+		if node.BinaryOperator() != "" {
+			current = evalIndexExpression(left, index)
+			if isSignal(current) {
+				return current
+			}
+		}
+		val := evalAssignedValue(node, current, env)
+		if isSignal(val) {
+			return val
+		}
+		return evalIndexAssignment(left, index, val, env)
+	default:
+		return newError("cannot assign to %s", node.Target.String())
+	}
+}
+
+// 代入する値を求めるヘルパー関数
+// 複合代入（+=など）のときは現在の値currentと右辺を演算した結果になる
+func evalAssignedValue(node *ast.AssignExpression, current object.Object, env *object.Environment) object.Object {
+	val := Eval(node.Value, env)
+	if isSignal(val) {
+		return val
+	}
+	if op := node.BinaryOperator(); op != "" {
+		return allocate(evalInfixExpression(op, current, val), env)
+	}
+	return val
+}
+
+// 配列やハッシュの要素に値を代入するヘルパー関数
+// ハッシュに新しいキーを追加した場合は、そのペアの分をenvの制限に計上する
+func evalIndexAssignment(left, index, val object.Object, env *object.Environment) object.Object {
+	switch left := left.(type) {
+	case *object.Array:
+		// 凍結された配列は書き換えられない
+		if left.Frozen {
+			return newError("index assignment not supported: frozen %s", left.Type())
+		}
+		idx, ok := index.(*object.Integer)
+		if !ok {
+			return newError("index must be INTEGER, got %s", index.Type())
+		}
+		if idx.Value < 0 || int64(len(left.Elements)) <= idx.Value {
+			return newError("index out of range: %d", idx.Value)
+		}
+		left.Elements[idx.Value] = val
+		return val
+	case *object.Hash:
+		key, ok := object.AsHashable(index)
+		if !ok {
+			return newError("unusable as hash key: %s", index.Type())
+		}
+		if _, exists := left.Get(key); !exists {
+			if budget := env.Budget(); budget != nil {
+				if err := budget.AllocateBytes(object.HashEntrySize); err != nil {
+					return newError("%s", err)
+				}
+			}
+		}
+		left.Set(key, val)
+		return val
+	default:
+		return newError("index assignment not supported: %s", left.Type())
+	}
+}
+
 // 一連の式を評価し適切なオブジェクトのスライスを返すヘルパー関数
 func evalExpressions(exps []ast.Expression, env *object.Environment) []object.Object {
 
@@ -311,8 +607,8 @@ func evalExpressions(exps []ast.Expression, env *object.Environment) []object.Ob
 		// 評価しObjectを得る
 		evaluated := Eval(e, env)
 
-		// エラーが起きたらそこで一連の評価を中断しエラーのみを一つ含むスライスを返す
-		if isError(evaluated) {
+		// エラーやbreakなどが起きたらそこで一連の評価を中断し、それのみを一つ含むスライスを返す
+		if isSignal(evaluated) {
 			return []object.Object{evaluated}
 		}
 
@@ -323,27 +619,68 @@ func evalExpressions(exps []ast.Expression, env *object.Environment) []object.Ob
 }
 
 // 関数を引数に対して適応させ得られたObjectを返すヘルパー関数
-func applyFunction(fn object.Object, args []object.Object) object.Object {
-	switch fn := fn.(type) {
-	case *object.Function:
-		// 関数の持っている環境で環境を拡張する
-		extendedEnv := extendFunctionEnv(fn, args)
-
-		// 関数を引数に対して適応
-		evaluated := Eval(fn.Body, extendedEnv)
-
-		// ReturnValueObjectでったらならば皮を剥いでObject.Objectにする必要がある
-		return unwrapReturnValue(evaluated)
-	case *object.Builtin:
-		if result := fn.Fn(args...); result != nil {
+// 関数本体が末尾呼び出しを返した場合は、Goのスタックを積まずにループでその呼び出しを続ける（トランポリン）
+// envは呼び出し元の環境で、組み込み関数の戻り値を制限に計上するために使う
+func applyFunction(fn object.Object, args []object.Object, env *object.Environment) object.Object {
+	for {
+		switch f := fn.(type) {
+		case *object.Function:
+			// 関数の持っている環境で環境を拡張する
+			extendedEnv := extendFunctionEnv(f, args)
+
+			// 関数を引数に対して適応
+			evaluated := Eval(f.Body, extendedEnv)
+
+			// ReturnValueObjectでったらならば皮を剥いでObject.Objectにする必要がある
+			result := unwrapReturnValue(evaluated)
+			tailCall, ok := result.(*object.TailCall)
+			if !ok {
+				return result
+			}
+			fn, args = tailCall.Fn, tailCall.Args
+		case *object.Builtin:
+			// 大きさが引数で決まる戻り値は、生成する前に制限を確かめる
+			if err := f.Reserve(env.Budget(), args); err != nil {
+				return newError("%s", err)
+			}
+			result := f.Call(invoker(env), args...)
+			if result == nil {
+				return NULL
+			}
+			if f.Allocates && f.Size == nil {
+				return allocate(result, env)
+			}
+			return result
+		default:
+			return newError("not a function: %s", fn.Type())
+		}
+	}
+}
+
+// 組み込み関数に渡す、Monkeyの関数を呼び出すための関数を返すヘルパー関数
+// 呼び出しは組み込み関数の呼び出し元の環境envのもとで行い、制限もそこから引き継ぐ
+func invoker(env *object.Environment) object.Invoker {
+	return func(fn object.Object, args ...object.Object) object.Object {
+		if result := applyFunction(fn, args, env); result != nil {
 			return result
 		}
 		return NULL
-	default:
-		return newError("not a function: %s", fn.Type())
 	}
 }
 
+// 新しく生成した配列・文字列・ハッシュの大きさをenvの制限に計上するヘルパー関数
+// メモリの上限を超えた場合はobjの代わりにエラーを返す
+func allocate(obj object.Object, env *object.Environment) object.Object {
+	budget := env.Budget()
+	if budget == nil || isError(obj) {
+		return obj
+	}
+	if err := budget.Allocate(obj); err != nil {
+		return newError("%s", err)
+	}
+	return obj
+}
+
 // 関数ごとに拡張された環境を返すヘルパー関数
 func extendFunctionEnv(fn *object.Function, args []object.Object) *object.Environment {
 
@@ -370,7 +707,7 @@ func unwrapReturnValue(obj object.Object) object.Object {
 func evalStringInfixExpression(operator string, left, right object.Object) object.Object {
 
 	// 文字列に対して+しかサポートしていない
-	// TODO: 文字列に対する!=演算子をサポートするならここに書く
+	// ==と!=はevalInfixExpressionでobject.Equalを使って評価する
 	if operator != "+" {
 		return newError("unknown operator: %s %s %s",
 			left.Type(), operator, right.Type())
@@ -385,7 +722,9 @@ func evalStringInfixExpression(operator string, left, right object.Object) objec
 func evalIndexExpression(left object.Object, index object.Object) object.Object {
 	switch {
 	case left.Type() == object.ARRAY_OBJ && index.Type() == object.INTEGER_OBJ:
-		return evalArrayIndexExpressions(left, index)
+		return evalArrayIndexExpressions(left.(*object.Array).Elements, index)
+	case left.Type() == object.TUPLE_OBJ && index.Type() == object.INTEGER_OBJ:
+		return evalArrayIndexExpressions(left.(*object.Tuple).Elements, index)
 	case left.Type() == object.HASH_OBJ:
 		return evalHashIndexExpression(left, index)
 	default:
@@ -393,51 +732,50 @@ func evalIndexExpression(left object.Object, index object.Object) object.Object
 	}
 }
 
-// 配列に対する添字演算子式を適切なObjectに評価するヘルパーヘルパー関数
-func evalArrayIndexExpressions(array, index object.Object) object.Object {
-	arrayObject := array.(*object.Array)
+// 配列やタプルに対する添字演算子式を適切なObjectに評価するヘルパーヘルパー関数
+func evalArrayIndexExpressions(elements []object.Object, index object.Object) object.Object {
 	idx := index.(*object.Integer).Value
-	max := int64(len(arrayObject.Elements) - 1)
+	max := int64(len(elements) - 1)
 
 	// 配列に格納している要素数を超えたインデックスに対してはNULLObjectを返す
 	if idx < 0 || max < idx {
 		return NULL
 	}
-	return arrayObject.Elements[idx]
+	return elements[idx]
 }
 
 // ハッシュリテラルを評価してObjectを返す関数
 // リテラルのペアに対するHashKeyを生成して、リテラルのペアとそのHashKeyの組をObjectとして保存しておく
 // {"one": 1, "two": 2}というリテラルのハッシュに対してこれを評価した結果得られるのは
 // {「"one"-1」というペアとこれに対するHashKey、「"two"-2」というペアとこれに対するHashKey}というObject
+// ペアはリテラルに書かれた順に評価して追加する
 func evalHashLiteral(node *ast.HashLiteral, env *object.Environment) object.Object {
-	pairs := make(map[object.HashKey]object.HashPair)
-	for keyNode, valueNode := range node.Pairs {
+	hash := &object.Hash{}
+	for _, keyNode := range node.Keys {
 		key := Eval(keyNode, env)
-		if isError(key) {
+		if isSignal(key) {
 			return key
 		}
-		hashKey, ok := key.(object.Hashable)
+		hashKey, ok := object.AsHashable(key)
 		if !ok {
 			return newError("unusable as hash key: %s", key.Type())
 		}
-		value := Eval(valueNode, env)
-		if isError(value) {
+		value := Eval(node.Pairs[keyNode], env)
+		if isSignal(value) {
 			return value
 		}
-		hashed := hashKey.HashKey()
-		pairs[hashed] = object.HashPair{Key: key, Value: value}
+		hash.Set(hashKey, value)
 	}
-	return &object.Hash{Pairs: pairs}
+	return hash
 }
 
 func evalHashIndexExpression(hash, index object.Object) object.Object {
 	hashObject := hash.(*object.Hash)
-	key, ok := index.(object.Hashable)
+	key, ok := object.AsHashable(index)
 	if !ok {
 		return newError("unusable as hash key: %s", index.Type())
 	}
-	pair, ok := hashObject.Pairs[key.HashKey()]
+	pair, ok := hashObject.Get(key)
 	if !ok {
 		return NULL
 	}
@@ -1,6 +1,8 @@
 package evaluator
 
 import (
+	"context"
+	"errors"
 	"monkey/lexer"
 	"monkey/object"
 	"monkey/parser"
@@ -81,6 +83,126 @@ func testIntegerObject(t *testing.T, obj object.Object, expected int64) bool {
 	return true
 }
 
+// int64を超える整数がBigIntに昇格することをテスト
+func TestEvalBigIntExpression(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{"9223372036854775807 + 1", "9223372036854775808"},
+		{"-9223372036854775807 - 2", "-9223372036854775809"},
+		{"9223372036854775807 * 9223372036854775807", "85070591730234615847396907784232501249"},
+		{"-(-9223372036854775807 - 1)", "9223372036854775808"},
+	}
+
+	for _, tt := range tests {
+		evaluated := testEval(tt.input)
+		result, ok := evaluated.(*object.BigInt)
+		if !ok {
+			t.Errorf("object is not BigInt. got=%T(%+v)", evaluated, evaluated)
+			continue
+		}
+		if result.Inspect() != tt.expected {
+			t.Errorf("object has wrong value. got=%s, want=%s", result.Inspect(), tt.expected)
+		}
+	}
+
+	// MinInt64 / -1はBigIntに昇格する
+	if result := testEval("(-9223372036854775807 - 1) / -1"); result.Inspect() != "9223372036854775808" {
+		t.Errorf("MinInt64 / -1 wrong. got=%T(%+v)", result, result)
+	}
+
+	// int64に収まる結果はIntegerに戻る
+	testIntegerObject(t, testEval("(9223372036854775807 + 1) - 1"), 9223372036854775807)
+	testIntegerObject(t, testEval("(9223372036854775807 * 4) / 4"), 9223372036854775807)
+
+	// BigInt同士の比較
+	testBooleanObject(t, testEval("9223372036854775807 + 1 > 9223372036854775807"), true)
+	testBooleanObject(t, testEval("9223372036854775807 + 1 == 9223372036854775807 + 1"), true)
+}
+
+// 追加した演算子を正しく評価できているかをテスト
+func TestExtendedOperators(t *testing.T) {
+	integerTests := []struct {
+		input    string
+		expected int64
+	}{
+		{"7 % 3", 1},
+		{"-7 % 3", -1},
+		{"6 & 3", 2},
+		{"6 | 3", 7},
+		{"6 ^ 3", 5},
+		{"1 << 4", 16},
+		{"-16 >> 2", -4},
+		{"(1 << 64) >> 63", 2},
+	}
+	for _, tt := range integerTests {
+		testIntegerObject(t, testEval(tt.input), tt.expected)
+	}
+
+	booleanTests := []struct {
+		input    string
+		expected bool
+	}{
+		{"1 <= 2", true},
+		{"2 <= 2", true},
+		{"3 <= 2", false},
+		{"1 >= 2", false},
+		{"2 >= 2", true},
+		{"1.5 >= 1", true},
+		{"true && true", true},
+		{"true && false", false},
+		{"false || true", true},
+		{"false || false", false},
+		{"1 && 2", true},
+		{"1 < 2 && 2 < 3", true},
+		// 結果が決まれば右辺は評価しない
+		{"let boom = fn() { 1 / 0 }; false && boom()", false},
+		{"let boom = fn() { 1 / 0 }; true || boom()", true},
+	}
+	for _, tt := range booleanTests {
+		testBooleanObject(t, testEval(tt.input), tt.expected)
+	}
+
+	testFloatObject(t, testEval("7.5 % 2"), 1.5)
+}
+
+// Floatを正しく評価できているかをテスト
+func TestEvalFloatExpression(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected float64
+	}{
+		{"1.5", 1.5},
+		{"-2.5", -2.5},
+		{"1e-9", 1e-9},
+		{"1.5 + 2.25", 3.75},
+		{"1 + 0.5", 1.5},
+		{"0.5 * 4", 2},
+		{"3 / 2.0", 1.5},
+		{"10 - 2.5 * 2", 5},
+	}
+
+	for _, tt := range tests {
+		evaluated := testEval(tt.input)
+		testFloatObject(t, evaluated, tt.expected)
+	}
+}
+
+// 引数objがFloatObject型で、かつ格納されている値が期待したものになっていることを確認するヘルパー関数
+func testFloatObject(t *testing.T, obj object.Object, expected float64) bool {
+	result, ok := obj.(*object.Float)
+	if !ok {
+		t.Errorf("object is not Float. got=%T(%+v)", obj, obj)
+		return false
+	}
+	if result.Value != expected {
+		t.Errorf("object has wrong value. got=%g, want=%g", result.Value, expected)
+		return false
+	}
+	return true
+}
+
 // Booleanを正しく評価できているかをテスト
 func TestEvalBooleanExpression(t *testing.T) {
 
@@ -99,6 +221,10 @@ func TestEvalBooleanExpression(t *testing.T) {
 		{"1 != 1", false},
 		{"1 == 2", false},
 		{"1 != 2", true},
+		{"1.5 < 2", true},
+		{"2 > 1.5", true},
+		{"1.0 == 1", true},
+		{"1.5 != 1.5", false},
 		{"true == true", true},
 		{"false == false", true},
 		{"true == false", false},
@@ -121,6 +247,37 @@ func TestEvalBooleanExpression(t *testing.T) {
 	}
 }
 
+func TestStructuralEquality(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected bool
+	}{
+		{`"mon" + "key" == "monkey"`, true},
+		{`"a" != "b"`, true},
+		{`[1, 2] == [1, 2]`, true},
+		{`[1, 2] != [1, 2]`, false},
+		{`[1, 2] == [2, 1]`, false},
+		{`[1, [2, "a"]] == [1, [2, "a"]]`, true},
+		{`[1] == [1.0]`, true},
+		{`[] == {}`, false},
+		{`{"a": 1, "b": [2]} == {"b": [2], "a": 1}`, true},
+		{`{"a": 1} == {"a": 2}`, false},
+		{`{"a": 1} == {"a": 1, "b": 2}`, false},
+		{`let a = [1]; let b = a; a == b`, true},
+		{`fn() {} == fn() {}`, false},
+		{`let f = fn() {}; f == f`, true},
+		{`1 == "1"`, false},
+		{`puts() == puts()`, true},
+	}
+
+	for _, tt := range tests {
+		evaluated := testEval(tt.input)
+		if !testBooleanObject(t, evaluated, tt.expected) {
+			t.Errorf("input: %s", tt.input)
+		}
+	}
+}
+
 // 引数objが期待するBooleanObjectであることを確認するヘルパー関数
 func testBooleanObject(t *testing.T, obj object.Object, expected bool) bool {
 
@@ -298,6 +455,30 @@ if (10 > 1) {
 			`{"name": "Monkey"}[fn(x) { x }];`,
 			"unusable as hash key: FUNCTION",
 		},
+		{
+			"1 / 0",
+			"division by zero",
+		},
+		{
+			"let f = fn(x) { 10 / x }; f(0); 5",
+			"division by zero",
+		},
+		{
+			"(9223372036854775807 + 1) / 0",
+			"division by zero",
+		},
+		{
+			"1 % 0",
+			"division by zero",
+		},
+		{
+			"1 << -1",
+			"negative shift count",
+		},
+		{
+			"1.5 & 1",
+			"unknown operator: FLOAT & INTEGER",
+		},
 	}
 
 	// 各テストセットに対して
@@ -344,6 +525,149 @@ func TestLetStatements(t *testing.T) {
 }
 
 // 正しくFunction型のObjectを生成することができているかを確認するテスト
+// 代入式の評価をテスト
+func TestAssignExpressions(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected interface{}
+	}{
+		{"let x = 1; x = 2; x", 2},
+		{"let x = 1; x = 2", 2},
+		{"let x = 10; x += 5; x -= 3; x *= 2; x /= 4; x", 6},
+		{"let a = 1; let b = 2; a = b = 3; a + b", 6},
+		{"let x = 1; let f = fn() { x = 5 }; f(); x", 5},
+		{"let counter = fn() { let n = 0; fn() { n += 1 } }; let c = counter(); c(); c(); c()", 3},
+		{"let a = [1, 2, 3]; a[1] = 5; a[1]", 5},
+		{"let a = [1, 2, 3]; a[2] *= 10; a[2]", 30},
+		{`let h = {"a": 1}; h["a"] += 1; h["b"] = 10; h["a"] + h["b"]`, 12},
+		{`let s = "a"; s += "b"; s`, "ab"},
+		{`let a = [1]; a[0] = a; sprintf("%v", a)`, "[[...]]"},
+		{`let h = {}; h["h"] = h; sprintf("%v", h)`, "{h: {...}}"},
+		// a global function refers to its own name through the global binding, which can be reassigned.
+		{`let f = fn(n) { if (n == 0) { "orig" } else { f(n - 1) } }; let g = f; f = fn(n) { "new" }; g(1)`, "new"},
+		{"let f = fn() { f = 1; 2 }; f() + f", 3},
+		{"x = 1", "identifier not found: x"},
+		{"len = 1", "cannot assign to builtin len"},
+		{"let a = [1]; a[1] = 2", "index out of range: 1"},
+		{`let h = {}; h[fn() {}] = 1`, "unusable as hash key: FUNCTION"},
+		{"let x = 1; x[0] = 1", "index assignment not supported: INTEGER"},
+	}
+
+	for _, tt := range tests {
+		evaluated := testEval(tt.input)
+		switch expected := tt.expected.(type) {
+		case int:
+			testIntegerObject(t, evaluated, int64(expected))
+		case string:
+			switch result := evaluated.(type) {
+			case *object.String:
+				if result.Value != expected {
+					t.Errorf("String has wrong value. got=%q, want=%q", result.Value, expected)
+				}
+			case *object.Error:
+				if result.Message != expected {
+					t.Errorf("wrong error message. expected=%q, got=%q", expected, result.Message)
+				}
+			default:
+				t.Errorf("object is not String or Error. got=%T (%+v)", evaluated, evaluated)
+			}
+		}
+	}
+}
+
+// WHILE文の評価をテスト
+func TestWhileStatements(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected interface{}
+	}{
+		{"let i = 0; while (i < 10) { i += 1; } i", 10},
+		{"let i = 0; let sum = 0; while (i < 10) { i += 1; if (i % 2 == 0) { continue; } sum += i; } sum", 25},
+		{"let i = 0; while (true) { if (i == 5) { break; } i += 1; } i", 5},
+		{"let f = fn() { let i = 0; while (true) { i += 1; if (i == 3) { return i * 10; } } }; f()", 30},
+		{"let i = 0; let n = 0; while (i < 3) { let j = 0; while (true) { if (j == 2) { break; } j += 1; n += 1; } i += 1; } n", 6},
+		{"while (false) { 1 }", nil},
+		{"while (1 + true) { 1 }", "type mismatch: INTEGER + BOOLEAN"},
+		// 式の中のbreak・continueもループまで伝わる
+		{"let i = 0; while (true) { i += 1; let x = if (i > 3) { break; } else { 1 }; } i", 4},
+		{"let i = 0; let n = 0; while (i < 5) { i += 1; n += if (i % 2 == 0) { continue; } else { 1 }; } n", 3},
+	}
+
+	for _, tt := range tests {
+		evaluated := testEval(tt.input)
+		switch expected := tt.expected.(type) {
+		case int:
+			testIntegerObject(t, evaluated, int64(expected))
+		case string:
+			errObj, ok := evaluated.(*object.Error)
+			if !ok {
+				t.Errorf("no error object returned. got=%T(%+v)", evaluated, evaluated)
+				continue
+			}
+			if errObj.Message != expected {
+				t.Errorf("wrong error message. expected=%q, got=%q", expected, errObj.Message)
+			}
+		default:
+			testNullObject(t, evaluated)
+		}
+	}
+}
+
+// FOR文の評価をテスト
+func TestForStatements(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected interface{}
+	}{
+		{"let sum = 0; for (x in [1, 2, 3]) { sum += x; } sum", 6},
+		{"let sum = 0; for (i, x in [10, 20, 30]) { sum += i * x; } sum", 80},
+		{`let s = ""; for (c in "añb") { s = c + s; } s`, "bña"},
+		{`let n = 0; for (i, c in "añb") { n = i; } n`, 2},
+		{`let sum = 0; for (k in {1: 10, 2: 20}) { sum += k; } sum`, 3},
+		{`let sum = 0; for (k, v in {1: 10, 2: 20}) { sum += k * v; } sum`, 50},
+		{"let sum = 0; for (i in range(5)) { sum += i; } sum", 10},
+		{"let sum = 0; for (i in range(1, 10, 3)) { sum += i; } sum", 12},
+		{"let sum = 0; for (i in range(5, 0, -2)) { sum += i; } sum", 9},
+		{"let n = 0; for (i in range(5, 0)) { n += 1; } n", 0},
+		{"let sum = 0; for (i in range(10)) { if (i % 2 == 0) { continue; } if (i > 7) { break; } sum += i; } sum", 16},
+		{"let n = 0; for (i in range(3)) { for (j in range(3)) { if (j > i) { break; } n += 1; } } n", 6},
+		{"let f = fn(xs) { for (x in xs) { if (x > 1) { return x * 10; } } }; f([1, 2, 3])", 20},
+		{"let i = 0; for (x in [1, 2, 3]) { i = i + (if (x == 2) { break; } else { x }); } i", 1},
+		{`let n = 0; for (x in [1, 2, 3]) { let k = len(if (x == 2) { continue; } else { "a" }); n += x; } n`, 4},
+		{"let a = []; for (x in [1, 2, 3]) { a = push(a, [x, if (x == 2) { break; } else { x }][0]); } len(a)", 1},
+		{"for (x in []) { 1 }", nil},
+		{"for (x in 1) { x }", "cannot iterate over INTEGER"},
+		{"for (x in range(1, true)) { x }", "argument to `range` must be INTEGER, got BOOLEAN"},
+		{"range(1, 2, 0)", "range step must not be zero"},
+		{"range()", "wrong number of arguments. got=0, want=1..3"},
+	}
+
+	for _, tt := range tests {
+		evaluated := testEval(tt.input)
+		switch expected := tt.expected.(type) {
+		case int:
+			testIntegerObject(t, evaluated, int64(expected))
+		case string:
+			if str, ok := evaluated.(*object.String); ok {
+				if str.Value != expected {
+					t.Errorf("String has wrong value. expected=%q, got=%q", expected, str.Value)
+				}
+				continue
+			}
+			errObj, ok := evaluated.(*object.Error)
+			if !ok {
+				t.Errorf("no error object returned. got=%T(%+v)", evaluated, evaluated)
+				continue
+			}
+			if errObj.Message != expected {
+				t.Errorf("wrong error message. expected=%q, got=%q", expected, errObj.Message)
+			}
+		default:
+			testNullObject(t, evaluated)
+		}
+	}
+}
+
 func TestFunctionObject(t *testing.T) {
 
 	// 関数の入力
@@ -391,6 +715,121 @@ addTwo(2);`
 	testIntegerObject(t, testEval(input), 4)
 }
 
+// 関数の中で定義した関数が自分自身を呼び出せることのテスト
+func TestRecursiveLocalFunctions(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected int64
+	}{
+		{"let wrapper = fn() { let countDown = fn(x) { if (x == 0) { return 0; } else { countDown(x - 1); } }; countDown(1); }; wrapper();", 0},
+		{"let wrapper = fn() { let fib = fn(n) { if (n < 2) { n } else { fib(n - 1) + fib(n - 2) } }; fib(15) }; wrapper();", 610},
+		{"let make = fn(base) { let pow = fn(n) { if (n == 0) { 1 } else { base * pow(n - 1) } }; pow }; let powerOfTwo = make(2); powerOfTwo(10);", 1024},
+	}
+
+	for _, tt := range tests {
+		testIntegerObject(t, testEval(tt.input), tt.expected)
+	}
+}
+
+// 末尾呼び出しのテスト
+func TestTailCalls(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected interface{}
+	}{
+		{"let countDown = fn(n) { if (n == 0) { 0 } else { countDown(n - 1) } }; countDown(100000)", 0},
+		{"let sum = fn(n, acc) { if (n == 0) { return acc; } return sum(n - 1, acc + n); }; sum(100000, 0)", 5000050000},
+		{"let isEven = fn(n) { if (n == 0) { true } else { isOdd(n - 1) } }; let isOdd = fn(n) { if (n == 0) { false } else { isEven(n - 1) } }; isEven(100001)", false},
+		{"let f = fn(a) { len(a) }; f([1, 2, 3])", 3},
+		{"let f = fn(n) { if (n == 0) { 0 } else { 1 + f(n - 1) } }; f(100)", 100},
+		{"let f = fn(n) { let g = fn() { n * 2 }; g() }; f(21)", 42},
+		{"let f = fn(xs) { for (x in xs) { if (x > 1) { return x; } } 0 }; f([1, 2, 3])", 2},
+	}
+
+	for _, tt := range tests {
+		evaluated := testEval(tt.input)
+		switch expected := tt.expected.(type) {
+		case int:
+			testIntegerObject(t, evaluated, int64(expected))
+		case bool:
+			testBooleanObject(t, evaluated, expected)
+		}
+	}
+}
+
+// 評価に課した制限のテスト
+func TestEvalBudget(t *testing.T) {
+	cancelled, cancel := context.WithCancel(context.Background())
+	cancel()
+	tests := []struct {
+		input    string
+		ctx      context.Context
+		maxSteps int64
+		maxBytes int64
+		expected error
+	}{
+		{"while (true) { }", context.Background(), 10000, 0, object.ErrBudgetExhausted},
+		// 関数呼び出しで拡張された環境にも制限が引き継がれる
+		{"let f = fn() { f() }; f()", context.Background(), 10000, 0, object.ErrBudgetExhausted},
+		{"let i = 0; while (true) { i += 1; }", cancelled, 0, 0, context.Canceled},
+		{"let a = []; while (true) { a = push(a, 1); }", context.Background(), 0, 1 << 20, object.ErrAllocationLimit},
+		{`let s = "ab"; while (true) { s += s; }`, context.Background(), 0, 1 << 20, object.ErrAllocationLimit},
+		{"let h = {}; let i = 0; while (true) { h[i] = i; i += 1; }", context.Background(), 0, 1 << 20, object.ErrAllocationLimit},
+		{"while (true) { [1, 2, 3]; {1: 2}; }", context.Background(), 0, 1 << 20, object.ErrAllocationLimit},
+		// 末尾呼び出しされた組み込み関数の戻り値も計上される
+		{"let grow = fn(a) { push(a, 1) }; let a = []; while (true) { a = grow(a); }", context.Background(), 0, 1 << 20, object.ErrAllocationLimit},
+		// 大きさが引数で決まる戻り値は、生成する前に制限を確かめる
+		{`repeat("x", 1000000000)`, context.Background(), 0, 1 << 20, object.ErrAllocationLimit},
+		{`replace(repeat("x", 1000), "", repeat("y", 100000))`, context.Background(), 0, 1 << 20, object.ErrAllocationLimit},
+		{`let a = range(10); for (i in range(20)) { a = [a, a]; } freeze(a)`, context.Background(), 0, 1 << 20, object.ErrAllocationLimit},
+		{`let a = 1 << 1000000; let i = 0; while (i < 7) { a = a * a; i += 1 } 1`, context.Background(), 1000, 1 << 20, object.ErrAllocationLimit},
+	}
+
+	for _, tt := range tests {
+		program := parser.New(lexer.New(tt.input)).ParseProgram()
+		env := object.NewEnvironment()
+		budget := object.NewBudget(tt.ctx, tt.maxSteps).LimitAllocation(tt.maxBytes)
+		env.SetBudget(budget)
+
+		evaluated := Eval(program, env)
+		errObj, ok := evaluated.(*object.Error)
+		if !ok {
+			t.Errorf("%q: no error object returned. got=%T(%+v)", tt.input, evaluated, evaluated)
+			continue
+		}
+		if !errors.Is(budget.Err(), tt.expected) {
+			t.Errorf("%q: budget error is not %v. got=%v", tt.input, tt.expected, budget.Err())
+		}
+		if errObj.Message != budget.Err().Error() {
+			t.Errorf("%q: wrong error message. expected=%q, got=%q", tt.input, budget.Err(), errObj.Message)
+		}
+	}
+
+	// 制限内で終わる評価には影響しない
+	env := object.NewEnvironment()
+	env.SetBudget(object.NewBudget(context.Background(), 100000).LimitAllocation(1 << 20))
+	program := parser.New(lexer.New("let a = []; for (i in range(100)) { a = push(a, i); } let sum = 0; for (x in a) { sum += x; } sum")).ParseProgram()
+	testIntegerObject(t, Eval(program, env), 4950)
+}
+
+// 捕捉した変数への代入がクロージャと定義元の間で共有されることのテスト
+func TestMutableCaptures(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected int64
+	}{
+		{"let newCounter = fn() { let c = 0; fn() { c = c + 1 } }; let counter = newCounter(); counter(); counter(); counter();", 3},
+		{"let newCounter = fn() { let c = 0; fn() { c += 1 } }; let a = newCounter(); let b = newCounter(); a(); a(); b(); a() * 10 + b()", 32},
+		{"let pair = fn() { let n = 0; [fn() { n += 10 }, fn() { n }] }; let p = pair(); p[0](); p[0](); p[1]()", 20},
+		{"let f = fn() { let x = 1; let get = fn() { x }; let set = fn(v) { x = v }; set(5); let seen = x; x = 7; seen * 10 + get() }; f()", 57},
+		{"let outer = fn() { let x = 0; let middle = fn() { fn() { x += 1 } }; let inner = middle(); inner(); inner(); x }; outer()", 2},
+	}
+
+	for _, tt := range tests {
+		testIntegerObject(t, testEval(tt.input), tt.expected)
+	}
+}
+
 // 関数呼び出しのテスト
 func TestFunctionApplication(t *testing.T) {
 
@@ -503,6 +942,188 @@ func TestBuiltinFunctions(t *testing.T) {
 	}
 }
 
+// Monkeyの関数を呼び出す組み込み関数のテスト
+func TestHigherOrderBuiltins(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string // 評価結果のInspect()
+	}{
+		{`map([1, 2, 3], fn(x) { x * 2 })`, "[2, 4, 6]"},
+		{`map([], fn(x) { x })`, "[]"},
+		{`let k = 3; map([1, 2], fn(x) { x * k })`, "[3, 6]"},
+		{`map([[1], [1, 2]], len)`, "[1, 2]"},
+		{`map([1], fn(x) { })`, "[Null]"},
+		{`filter([1, 2, 3, 4], fn(x) { x % 2 == 0 })`, "[2, 4]"},
+		{`reduce([1, 2, 3, 4], 0, fn(acc, x) { acc + x })`, "10"},
+		{`reduce([], 5, fn(acc, x) { acc + x })`, "5"},
+		{`sort_by([3, 1, 2], fn(x) { -x })`, "[3, 2, 1]"},
+		{`sort_by([13, 2, 11, 4], fn(x) { x % 2 })`, "[2, 4, 13, 11]"},
+		{`sort_by(["ccc", "a", "bb"], fn(s) { s })`, "[a, bb, ccc]"},
+		{`map([[1, 2], [3]], fn(a) { reduce(a, 0, fn(s, x) { s + x }) })`, "[3, 3]"},
+		{`let inc = fn(a) { map(a, fn(x) { x + 1 }) }; inc([1, 2])`, "[2, 3]"},
+		{`let count = 0; map([1, 2, 3], fn(x) { count += x }); count`, "6"},
+		{`map(1, fn(x) { x })`, "ERROR: argument to `map` must be ARRAY, got INTEGER"},
+		{`reduce([1], fn(x) { x })`, "ERROR: wrong number of arguments. got=2, want=3"},
+		{`sort_by([1, "a"], fn(x) { x })`, "ERROR: sort_by keys must be all numbers or all strings, got INTEGER and STRING"},
+		// 呼び出した関数のエラーはそのまま返る
+		{`map([1, 2], fn(x) { x + "one" })`, "ERROR: type mismatch: INTEGER + STRING"},
+	}
+
+	for _, tt := range tests {
+		evaluated := testEval(tt.input)
+		if evaluated == nil {
+			t.Errorf("%q: no object returned", tt.input)
+			continue
+		}
+		if evaluated.Inspect() != tt.expected {
+			t.Errorf("%q: wrong result. want=%q, got=%q", tt.input, tt.expected, evaluated.Inspect())
+		}
+	}
+}
+
+// 文字列を扱う組み込み関数のテスト
+func TestStringBuiltins(t *testing.T) {
+
+	// テストケース
+	// 期待する値がstringなら文字列、*object.Errorならエラー
+	tests := []struct {
+		input    string
+		expected interface{}
+	}{
+		{`len("héllo")`, 5},
+		{`split("a,b,c", ",")`, []string{"a", "b", "c"}},
+		{`split("a,b", 1)`, &object.Error{Message: "argument to `split` must be STRING, got INTEGER"}},
+		{`join(["a", "b", "c"], "-")`, "a-b-c"},
+		{`join(["a", 1], "-")`, &object.Error{Message: "argument to `join` must be ARRAY of STRING, got INTEGER in ARRAY"}},
+		{`trim("  monkey \n")`, "monkey"},
+		{`replace("banana", "a", "o")`, "bonono"},
+		{`contains("monkey", "key")`, true},
+		{`index_of("héllo", "l")`, 2},
+		{`index_of("hello", "z")`, -1},
+		{`starts_with("monkey", "mon")`, true},
+		{`ends_with("monkey", "mon")`, false},
+		{`upper("héllo")`, "HÉLLO"},
+		{`lower("MONKEY")`, "monkey"},
+		{`repeat("ab", 3)`, "ababab"},
+		{`repeat("ab", -1)`, &object.Error{Message: "repeat count must not be negative, got -1"}},
+		{`substring("héllo", 1, 3)`, "él"},
+		{`substring("héllo", 3, 9)`, &object.Error{Message: "substring out of range: [3:9] with length 5"}},
+		{`chars("héllo")`, []string{"h", "é", "l", "l", "o"}},
+		{`format("%s is %d", "x", 1)`, "x is 1"},
+		{`sprintf("%v", {"a": 1})`, "{a: 1}"},
+	}
+
+	// 各テストケースに対して
+	for _, tt := range tests {
+
+		// ここで評価
+		evaluated := testEval(tt.input)
+		testExpectedObject(t, tt.input, tt.expected, evaluated)
+	}
+}
+
+// 評価結果がテストケースの期待する値と一致するかを調べるヘルパー関数
+// 期待する値の型で比べ方を選び、nilならNULLであることを調べる
+func testExpectedObject(t *testing.T, input string, expected interface{}, actual object.Object) {
+	t.Helper()
+	switch expected := expected.(type) {
+	case int:
+		testIntegerObject(t, actual, int64(expected))
+	case bool:
+		testBooleanObject(t, actual, expected)
+	case string:
+		str, ok := actual.(*object.String)
+		if !ok {
+			t.Errorf("%q: object is not String. got=%T(%+v)", input, actual, actual)
+			return
+		}
+		if str.Value != expected {
+			t.Errorf("%q: wrong value. want=%q, got=%q", input, expected, str.Value)
+		}
+	case []string:
+		arr, ok := actual.(*object.Array)
+		if !ok || len(arr.Elements) != len(expected) {
+			t.Errorf("%q: wrong array. got=%T(%+v)", input, actual, actual)
+			return
+		}
+		for i, element := range arr.Elements {
+			if str, ok := element.(*object.String); !ok || str.Value != expected[i] {
+				t.Errorf("%q: wrong element %d. want=%q, got=%+v", input, i, expected[i], element)
+			}
+		}
+	case *object.Error:
+		errObj, ok := actual.(*object.Error)
+		if !ok {
+			t.Errorf("%q: object is not Error. got=%T(%+v)", input, actual, actual)
+			return
+		}
+		if errObj.Message != expected.Message {
+			t.Errorf("%q: wrong error message. want=%q, got=%q", input, expected.Message, errObj.Message)
+		}
+	case nil:
+		testNullObject(t, actual)
+	default:
+		t.Errorf("%q: unsupported expected type %T", input, expected)
+	}
+}
+
+func TestTuples(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected interface{}
+	}{
+		{`sprintf("%v", (1, "a", [2]))`, "(1, a, [2])"},
+		{`sprintf("%v", (1,))`, "(1,)"},
+		{`sprintf("%v", tuple())`, "()"},
+		{`(1, 2)[1]`, 2},
+		{`(1, 2)[2]`, nil},
+		{`len((1, 2, 3))`, 3},
+		{`(1, [2]) == tuple(1, [2])`, true},
+		{`(1, 2) == [1, 2]`, false},
+		{`let memo = {}; memo[(1, 2)] = "a"; memo[(2, 1)] = "b"; memo[(1, 2)]`, "a"},
+		{`let memo = {(0, 0): 1}; memo[tuple(0, 0)] = 2; len(keys(memo))`, 1},
+		{`has({}, (1, [2]))`, &object.Error{Message: "unusable as hash key: TUPLE"}},
+		{`let t = (1, 2); t[0] = 3`, &object.Error{Message: "index assignment not supported: TUPLE"}},
+		{`let h = {}; h[freeze([1, [2, 3]])] = "x"; h[freeze([1, [2, 3]])]`, "x"},
+		{`let a = [1]; {a: 1}`, &object.Error{Message: "unusable as hash key: ARRAY"}},
+		{`let a = freeze([1]); a[0] = 2`, &object.Error{Message: "index assignment not supported: frozen ARRAY"}},
+		{`let a = [1, [2]]; let b = freeze(a); a[1][0] = 3; b[1][0]`, 2},
+		{`freeze([1]) == [1]`, true},
+		{`let a = [1]; a[0] = a; freeze(a)`, &object.Error{Message: "cannot freeze self-referencing ARRAY"}},
+		{`let sum = 0; for (i, x in (10, 20)) { sum = sum + i + x; } sum`, 31},
+	}
+
+	for _, tt := range tests {
+		evaluated := testEval(tt.input)
+		testExpectedObject(t, tt.input, tt.expected, evaluated)
+	}
+}
+
+func TestHashBuiltins(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected interface{}
+	}{
+		{`keys({"b": 1, "a": 2, "c": 3})`, []string{"b", "a", "c"}},
+		{`sprintf("%v", values({"b": 1, "a": 2}))`, "[1, 2]"},
+		{`sprintf("%v", entries({"a": 1, 2: "b"}))`, "[[a, 1], [2, b]]"},
+		{`has({"a": 1}, "a")`, true},
+		{`has({"a": 1}, fn(x) { x })`, &object.Error{Message: "unusable as hash key: FUNCTION"}},
+		{`let h = {"a": 1, "b": 2}; delete(h, "a")`, true},
+		{`let h = {"a": 1, "b": 2, "c": 3}; delete(h, "b"); h["b"] = 4; keys(h)`, []string{"a", "c", "b"}},
+		{`sprintf("%v", merge({"a": 1, "b": 2}, {"c": 3, "a": 4}))`, "{a: 4, b: 2, c: 3}"},
+		{`merge({}, 1)`, &object.Error{Message: "argument to `merge` must be HASH, got INTEGER"}},
+	}
+
+	// 各テストケースに対して
+	for _, tt := range tests {
+
+		// ここで評価
+		evaluated := testEval(tt.input)
+		testExpectedObject(t, tt.input, tt.expected, evaluated)
+	}
+}
+
 // ArrayLiteral型のASTノードを評価して正しいArray型のObjectを得られるかをテスト
 func TestArrayLiterals(t *testing.T) {
 	input := "[1, 2 * 2, 3 + 3]"
@@ -603,13 +1224,14 @@ func TestHashLiterals(t *testing.T) {
 		TRUE.HashKey():                             5,
 		FALSE.HashKey():                            6,
 	}
-	if len(result.Pairs) != len(expected) {
-		t.Fatalf("Hash has wrong num of pairs. got=%d", len(result.Pairs))
+	if result.Len() != len(expected) {
+		t.Fatalf("Hash has wrong num of pairs. got=%d", result.Len())
 	}
-	for expectedKey, expectedValue := range expected {
-		pair, ok := result.Pairs[expectedKey]
+	for _, pair := range result.Pairs() {
+		expectedValue, ok := expected[pair.Key.(object.Hashable).HashKey()]
 		if !ok {
-			t.Errorf("no pair for given key in Pairs")
+			t.Errorf("unexpected key in Pairs: %s", pair.Key.Inspect())
+			continue
 		}
 		testIntegerObject(t, pair.Value, expectedValue)
 	}
@@ -662,3 +1284,32 @@ func TestHashIndexExpressions(t *testing.T) {
 		}
 	}
 }
+
+// 環境ごとに組み込み関数を差し替えられることのテスト
+func TestCustomBuiltins(t *testing.T) {
+	r := object.NewRegistry()
+	r.Namespace("math").Register("double", &object.Builtin{
+		Fn: func(call object.Invoker, args ...object.Object) object.Object {
+			return &object.Integer{Value: args[0].(*object.Integer).Value * 2}
+		},
+		Signature: &object.Signature{MinArgs: 1, MaxArgs: 1, Types: []object.ObjectType{object.INTEGER_OBJ}},
+	})
+
+	tests := []struct {
+		input    string
+		expected string // 評価結果のInspect()
+	}{
+		{"math.double(21)", "42"},
+		{"let f = fn(x) { math.double(x) }; f(2)", "4"},
+		{`math.double("a")`, "ERROR: argument to `math.double` must be INTEGER, got STRING"},
+		{"len([])", "ERROR: identifier not found: len"},
+		{"math.double = 1", "ERROR: cannot assign to builtin math.double"},
+	}
+	for _, tt := range tests {
+		env := object.NewEnvironmentWithBuiltins(r)
+		evaluated := Eval(parser.New(lexer.New(tt.input)).ParseProgram(), env)
+		if evaluated.Inspect() != tt.expected {
+			t.Errorf("%q: wrong result. want=%q, got=%q", tt.input, tt.expected, evaluated.Inspect())
+		}
+	}
+}
@@ -1,37 +1,100 @@
 package lexer
 
-import "monkey/token"
+import (
+	"fmt"
+	"monkey/token"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+// 字句解析中に検出したエラー
+type Error struct {
+	Pos     token.Position
+	Message string
+}
+
+func (e *Error) Error() string {
+	return fmt.Sprintf("%s: %s", e.Pos, e.Message)
+}
 
 type Lexer struct {
+	filename     string // 入力元のファイル名（位置情報に記録される）
 	input        string
 	position     int  // 入力における現在の位置
 	readPosition int  // これから読み込む文字の位置（すなわち現在の文字の次の文字）
 	ch           byte // 現在検査中の文字
+	line         int  // 現在の文字の行番号
+	column       int  // 現在の文字の列番号
+	errors       []*Error
 }
 
 // 入力によって初期化済みの字句解析器を与える
 func New(input string) *Lexer {
-	l := &Lexer{input: input}
+	return NewWithFilename("", input)
+}
+
+// ファイル名付きで初期化済みの字句解析器を与える
+func NewWithFilename(filename, input string) *Lexer {
+	l := &Lexer{filename: filename, input: input, line: 1}
 	l.readChar()
 	return l
 }
 
 // 文字を一つ読み込む
 func (l *Lexer) readChar() {
+	// 直前の文字が改行なら次の行の先頭に移る
+	if l.ch == '\n' {
+		l.line++
+		l.column = 0
+	}
 	if l.readPosition >= len(l.input) {
 		l.ch = 0
 	} else {
 		l.ch = l.input[l.readPosition]
 	}
+	// UTF-8の継続バイトは列を進めない（列は文字単位で数える）
+	if l.ch&0xC0 != 0x80 {
+		l.column++
+	}
 	l.position = l.readPosition
 	l.readPosition++
 }
 
+// 字句解析中に検出したエラーを返す
+// エラーを検出したときにはILLEGALトークンを返しているので、エラーとILLEGALトークンは一対一に対応する
+func (l *Lexer) Errors() []*Error {
+	return l.errors
+}
+
+// エラーを記録してILLEGALトークンを返すヘルパー関数
+func (l *Lexer) illegal(pos token.Position, literal string, format string, a ...interface{}) token.Token {
+	l.errors = append(l.errors, &Error{Pos: pos, Message: fmt.Sprintf(format, a...)})
+	return token.Token{Type: token.ILLEGAL, Literal: literal, Pos: pos}
+}
+
+// 現在の文字の位置を返す
+func (l *Lexer) currentPosition() token.Position {
+	return token.Position{
+		Filename: l.filename,
+		Offset:   l.position,
+		Line:     l.line,
+		Column:   l.column,
+	}
+}
+
 // 読み込んだ文字を判別して対応するトークンを返す
 func (l *Lexer) NextToken() token.Token {
 	var tok token.Token
 
-	l.skipWhiteSpace()
+	// 空白とコメントを読み飛ばす。コメントは次のトークンに持たせておく
+	comments, err := l.skipWhiteSpaceAndComments()
+	if err != nil {
+		return *err
+	}
+	tok.Comments = comments
+
+	pos := l.currentPosition()
 
 	switch l.ch {
 	case '!':
@@ -53,17 +116,67 @@ func (l *Lexer) NextToken() token.Token {
 			tok = newToken(token.ASSIGN, l.ch)
 		}
 	case '+':
-		tok = newToken(token.PLUS, l.ch)
+		if l.peekChar() == '=' {
+			tok = l.newTwoCharToken(token.PLUS_ASSIGN)
+		} else {
+			tok = newToken(token.PLUS, l.ch)
+		}
 	case '-':
-		tok = newToken(token.MINUS, l.ch)
+		if l.peekChar() == '=' {
+			tok = l.newTwoCharToken(token.MINUS_ASSIGN)
+		} else {
+			tok = newToken(token.MINUS, l.ch)
+		}
 	case '/':
-		tok = newToken(token.SLASH, l.ch)
+		if l.peekChar() == '=' {
+			tok = l.newTwoCharToken(token.SLASH_ASSIGN)
+		} else {
+			tok = newToken(token.SLASH, l.ch)
+		}
 	case '*':
-		tok = newToken(token.ASTERISK, l.ch)
+		// コメントの外に現れた「*/」は入れ子の対応が取れていないコメント（「*/*」は「*」と「/*」）
+		if l.peekChar() == '/' && l.peekCharAt(2) != '*' {
+			tok = l.illegal(pos, "*/", "unexpected */ outside of block comment")
+			l.readChar()
+		} else if l.peekChar() == '=' {
+			tok = l.newTwoCharToken(token.ASTERISK_ASSIGN)
+		} else {
+			tok = newToken(token.ASTERISK, l.ch)
+		}
+	case '%':
+		tok = newToken(token.PERCENT, l.ch)
 	case '<':
-		tok = newToken(token.LT, l.ch)
+		switch l.peekChar() {
+		case '=':
+			tok = l.newTwoCharToken(token.LT_EQ)
+		case '<':
+			tok = l.newTwoCharToken(token.SHIFT_LEFT)
+		default:
+			tok = newToken(token.LT, l.ch)
+		}
 	case '>':
-		tok = newToken(token.GT, l.ch)
+		switch l.peekChar() {
+		case '=':
+			tok = l.newTwoCharToken(token.GT_EQ)
+		case '>':
+			tok = l.newTwoCharToken(token.SHIFT_RIGHT)
+		default:
+			tok = newToken(token.GT, l.ch)
+		}
+	case '&':
+		if l.peekChar() == '&' {
+			tok = l.newTwoCharToken(token.AND)
+		} else {
+			tok = newToken(token.AMPERSAND, l.ch)
+		}
+	case '|':
+		if l.peekChar() == '|' {
+			tok = l.newTwoCharToken(token.OR)
+		} else {
+			tok = newToken(token.PIPE, l.ch)
+		}
+	case '^':
+		tok = newToken(token.CARET, l.ch)
 	case ';':
 		tok = newToken(token.SEMICOLON, l.ch)
 	case ':':
@@ -83,8 +196,9 @@ func (l *Lexer) NextToken() token.Token {
 	case '}':
 		tok = newToken(token.RBRACE, l.ch)
 	case '"':
-		tok.Type = token.STRING
-		tok.Literal = l.readString()
+		tok = l.readString(pos)
+	case '`':
+		tok = l.readRawString(pos)
 	case 0: // 終端
 		tok.Literal = ""
 		tok.Type = token.EOF
@@ -93,16 +207,21 @@ func (l *Lexer) NextToken() token.Token {
 			tok.Literal = l.readIdentifier()
 			// ここで識別子であろうとされているtok.Literalがキーワードでないことを確認する
 			tok.Type = token.LookupIdent(tok.Literal)
+			tok.Comments = comments
+			tok.Pos = pos
 			return tok
 		} else if isDigit(l.ch) {
-			tok.Type = token.INT
-			tok.Literal = l.readNumber()
+			tok.Literal, tok.Type = l.readNumber()
+			tok.Comments = comments
+			tok.Pos = pos
 			return tok
 		} else {
-			tok = newToken(token.ILLEGAL, l.ch)
+			tok = l.illegal(pos, string(l.ch), "unexpected character %q", l.ch)
 		}
 	}
 
+	tok.Comments = comments
+	tok.Pos = pos
 	l.readChar()
 	return tok
 }
@@ -112,10 +231,18 @@ func newToken(tokenType token.TokenType, ch byte) token.Token {
 	return token.Token{Type: tokenType, Literal: string(ch)}
 }
 
+// 現在の文字と次の文字からなる2文字の演算子トークンを生成する
+func (l *Lexer) newTwoCharToken(tokenType token.TokenType) token.Token {
+	ch := l.ch
+	l.readChar()
+	return token.Token{Type: tokenType, Literal: string(ch) + string(l.ch)}
+}
+
 func (l *Lexer) readIdentifier() string {
 	// 非英字まで読み進めていく
+	// 「.」の後に英字が続く場合は名前空間付きの名前（strings.upperなど）として一つの識別子にする
 	position := l.position
-	for isLetter(l.ch) {
+	for isLetter(l.ch) || l.ch == '.' && isLetter(l.peekChar()) {
 		l.readChar()
 	}
 	return l.input[position:l.position]
@@ -138,34 +265,199 @@ func isDigit(ch byte) bool {
 	return '0' <= ch && ch <= '9'
 }
 
-func (l *Lexer) readNumber() string {
+// 数値リテラルを読み進めてリテラルとトークンの種類を返す
+// 小数点や指数部を含んでいれば浮動小数点数（1.5, 1e-9, 2.5E+3）
+func (l *Lexer) readNumber() (string, token.TokenType) {
 	position := l.position
+	var tokenType token.TokenType = token.INT
+	l.readDigits()
+
+	// 「.」の後に数字が続く場合のみ小数部とみなす
+	if l.ch == '.' && isDigit(l.peekChar()) {
+		tokenType = token.FLOAT
+		l.readChar()
+		l.readDigits()
+	}
+
+	// 「e」の後に（符号と）数字が続く場合のみ指数部とみなす
+	if l.ch == 'e' || l.ch == 'E' {
+		n := 1
+		if l.peekChar() == '+' || l.peekChar() == '-' {
+			n = 2
+		}
+		if isDigit(l.peekCharAt(n)) {
+			tokenType = token.FLOAT
+			for i := 0; i < n; i++ {
+				l.readChar()
+			}
+			l.readDigits()
+		}
+	}
+	return l.input[position:l.position], tokenType
+}
+
+func (l *Lexer) readDigits() {
 	for isDigit(l.ch) {
 		l.readChar()
 	}
-	return l.input[position:l.position]
 }
 
 // readPositionの文字を処理する前に覗き見peekする関数
 func (l *Lexer) peekChar() byte {
-	if l.readPosition >= len(l.input) {
+	return l.peekCharAt(1)
+}
+
+// 現在の文字からn文字先を覗き見peekする関数
+func (l *Lexer) peekCharAt(n int) byte {
+	if l.position+n >= len(l.input) {
 		return 0
-	} else {
-		return l.input[l.readPosition]
 	}
+	return l.input[l.position+n]
+}
+
+// 空白とコメントを読み飛ばして、読み飛ばしたコメントを返す
+// 「// ...」は行末まで、「/* ... */」は入れ子にできるブロックコメント
+// ブロックコメントが閉じられないまま入力が終わった場合はILLEGALトークンを返す
+func (l *Lexer) skipWhiteSpaceAndComments() ([]string, *token.Token) {
+	var comments []string
+	for {
+		l.skipWhiteSpace()
+		switch {
+		case l.ch == '/' && l.peekChar() == '/':
+			comments = append(comments, l.readLineComment())
+		case l.ch == '/' && l.peekChar() == '*':
+			pos := l.currentPosition()
+			comment, ok := l.readBlockComment()
+			if !ok {
+				tok := l.illegal(pos, comment, "unterminated block comment")
+				return comments, &tok
+			}
+			comments = append(comments, comment)
+		default:
+			return comments, nil
+		}
+	}
+}
+
+// 「//」から行末（改行は含まない）までを読み進めてコメントを返す
+func (l *Lexer) readLineComment() string {
+	position := l.position
+	for l.ch != '\n' && l.ch != 0 {
+		l.readChar()
+	}
+	return l.input[position:l.position]
+}
+
+// 「/*」から対応する「*/」までを読み進めてコメントを返す
+// 閉じられていなければ入力の終わりまでを返して、okはfalseになる
+func (l *Lexer) readBlockComment() (comment string, ok bool) {
+	position := l.position
+	depth := 0
+	for l.ch != 0 {
+		switch {
+		case l.ch == '/' && l.peekChar() == '*':
+			depth++
+			l.readChar()
+		case l.ch == '*' && l.peekChar() == '/':
+			depth--
+			l.readChar()
+		}
+		l.readChar()
+		if depth == 0 {
+			return l.input[position:l.position], true
+		}
+	}
+	return l.input[position:l.position], false
 }
 
-// 文字列として扱われるべき部分まで読み進めていき、得られた文字列を返す関数
-func (l *Lexer) readString() string {
+// 文字列として扱われるべき部分まで読み進めていき、エスケープシーケンスを解釈した文字列のトークンを返す関数
+// 閉じる「"」が無いまま入力が終わった場合や、不正なエスケープシーケンスがあった場合はILLEGALトークンを返す
+func (l *Lexer) readString(pos token.Position) token.Token {
+	var out strings.Builder
+	var escErr *Error // 最初に見つかった不正なエスケープシーケンス
+	for {
+		l.readChar()
+		switch l.ch {
+		case '"':
+			if escErr != nil {
+				return l.illegal(escErr.Pos, l.input[pos.Offset:l.position+1], "%s", escErr.Message)
+			}
+			return token.Token{Type: token.STRING, Literal: out.String()}
+		case 0:
+			return l.illegal(pos, l.input[pos.Offset:l.position], "unterminated string")
+		case '\\':
+			escPos := l.currentPosition()
+			l.readChar()
+			if l.ch == 0 {
+				return l.illegal(pos, l.input[pos.Offset:l.position], "unterminated string")
+			}
+			if msg := l.readEscape(&out); msg != "" && escErr == nil {
+				// 文字列の終わりまでは読み進めてからエラーにする
+				escErr = &Error{Pos: escPos, Message: msg}
+			}
+		default:
+			out.WriteByte(l.ch)
+		}
+	}
+}
 
-	// TODO: 以下の実装では「"hello \"world\""」や「hello\n world」「hello\t\t\tworld」などには対応できていない
+// 「\」に続くエスケープシーケンスを解釈してoutに書き込む
+// 不正なエスケープシーケンスであればエラーメッセージを返す
+func (l *Lexer) readEscape(out *strings.Builder) string {
+	switch l.ch {
+	case 'n':
+		out.WriteByte('\n')
+	case 't':
+		out.WriteByte('\t')
+	case 'r':
+		out.WriteByte('\r')
+	case '0':
+		out.WriteByte(0)
+	case '\\', '"':
+		out.WriteByte(l.ch)
+	case 'u':
+		// \u{1F600} の形式で1〜6桁の16進数によるUnicodeのコードポイントを指定する
+		if l.peekChar() != '{' {
+			return "invalid unicode escape: expected {"
+		}
+		l.readChar()
+		start := l.position + 1
+		for isHexDigit(l.peekChar()) {
+			l.readChar()
+		}
+		digits := l.input[start : l.position+1]
+		if l.peekChar() != '}' || len(digits) == 0 || len(digits) > 6 {
+			return "invalid unicode escape: expected 1 to 6 hex digits followed by }"
+		}
+		l.readChar()
+		code, _ := strconv.ParseUint(digits, 16, 32)
+		r := rune(code)
+		if !utf8.ValidRune(r) {
+			return fmt.Sprintf("invalid unicode code point U+%X", code)
+		}
+		out.WriteRune(r)
+	default:
+		r, _ := utf8.DecodeRuneInString(l.input[l.position:])
+		return fmt.Sprintf("unknown escape sequence \\%c", r)
+	}
+	return ""
+}
 
+// 「`」で囲まれた生文字列を読み進めて文字列のトークンを返す関数
+// エスケープシーケンスは解釈せず、改行もそのまま含めることができる
+func (l *Lexer) readRawString(pos token.Position) token.Token {
 	position := l.position + 1
 	for {
 		l.readChar()
-		if l.ch == '"' || l.ch == 0 {
-			break
+		switch l.ch {
+		case '`':
+			return token.Token{Type: token.STRING, Literal: l.input[position:l.position]}
+		case 0:
+			return l.illegal(pos, l.input[pos.Offset:l.position], "unterminated raw string")
 		}
 	}
-	return l.input[position:l.position]
+}
+
+func isHexDigit(ch byte) bool {
+	return isDigit(ch) || 'a' <= ch && ch <= 'f' || 'A' <= ch && ch <= 'F'
 }
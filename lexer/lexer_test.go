@@ -16,7 +16,7 @@ let add = fn(x, y) {
 };
 
 let result = add(five, ten);
-!-/*5;
+!-/ *5;
 5 < 10 > 5;
 
 if (5 < 10) {
@@ -152,3 +152,355 @@ if (5 < 10) {
 		}
 	}
 }
+
+// 2文字の演算子や論理・ビット演算子の字句解析のテスト
+func TestOperators(t *testing.T) {
+	input := `a <= b >= c % d & e | f ^ g << h >> i && j || k < l > m = n += o -= p *= q /= r`
+	expected := []struct {
+		expectedType    token.TokenType
+		expectedLiteral string
+	}{
+		{token.IDENT, "a"},
+		{token.LT_EQ, "<="},
+		{token.IDENT, "b"},
+		{token.GT_EQ, ">="},
+		{token.IDENT, "c"},
+		{token.PERCENT, "%"},
+		{token.IDENT, "d"},
+		{token.AMPERSAND, "&"},
+		{token.IDENT, "e"},
+		{token.PIPE, "|"},
+		{token.IDENT, "f"},
+		{token.CARET, "^"},
+		{token.IDENT, "g"},
+		{token.SHIFT_LEFT, "<<"},
+		{token.IDENT, "h"},
+		{token.SHIFT_RIGHT, ">>"},
+		{token.IDENT, "i"},
+		{token.AND, "&&"},
+		{token.IDENT, "j"},
+		{token.OR, "||"},
+		{token.IDENT, "k"},
+		{token.LT, "<"},
+		{token.IDENT, "l"},
+		{token.GT, ">"},
+		{token.IDENT, "m"},
+		{token.ASSIGN, "="},
+		{token.IDENT, "n"},
+		{token.PLUS_ASSIGN, "+="},
+		{token.IDENT, "o"},
+		{token.MINUS_ASSIGN, "-="},
+		{token.IDENT, "p"},
+		{token.ASTERISK_ASSIGN, "*="},
+		{token.IDENT, "q"},
+		{token.SLASH_ASSIGN, "/="},
+		{token.IDENT, "r"},
+		{token.EOF, ""},
+	}
+
+	l := New(input)
+	for i, tt := range expected {
+		tok := l.NextToken()
+		if tok.Type != tt.expectedType {
+			t.Fatalf("test[%d] - tokentype wrong. expected=%q, got=%q",
+				i, tt.expectedType, tok.Type)
+		}
+		if tok.Literal != tt.expectedLiteral {
+			t.Fatalf("test[%d] - literal wrong. expected=%q, got=%q",
+				i, tt.expectedLiteral, tok.Literal)
+		}
+	}
+}
+
+// ループに関するキーワードの字句解析のテスト
+func TestLoopKeywords(t *testing.T) {
+	input := `while (x) { break; continue; } whilex for (k, v in xs) {} index`
+	expected := []token.TokenType{
+		token.WHILE, token.LPAREN, token.IDENT, token.RPAREN, token.LBRACE,
+		token.BREAK, token.SEMICOLON, token.CONTINUE, token.SEMICOLON,
+		token.RBRACE, token.IDENT,
+		token.FOR, token.LPAREN, token.IDENT, token.COMMA, token.IDENT, token.IN,
+		token.IDENT, token.RPAREN, token.LBRACE, token.RBRACE, token.IDENT, token.EOF,
+	}
+	l := New(input)
+	for i, tt := range expected {
+		tok := l.NextToken()
+		if tok.Type != tt {
+			t.Fatalf("tokens[%d] - tokentype wrong. expected=%q, got=%q", i, tt, tok.Type)
+		}
+	}
+}
+
+// トークンに記録される位置情報のテスト
+func TestNextTokenPosition(t *testing.T) {
+	input := `let x = 5;
+  x + "あい" + y;
+`
+	tests := []struct {
+		expectedLiteral string
+		expectedLine    int
+		expectedColumn  int
+		expectedOffset  int
+	}{
+		{"let", 1, 1, 0},
+		{"x", 1, 5, 4},
+		{"=", 1, 7, 6},
+		{"5", 1, 9, 8},
+		{";", 1, 10, 9},
+		{"x", 2, 3, 13},
+		{"+", 2, 5, 15},
+		{"あい", 2, 7, 17},
+		{"+", 2, 12, 26},
+		{"y", 2, 14, 28},
+		{";", 2, 15, 29},
+		{"", 3, 1, 31},
+	}
+
+	l := NewWithFilename("test.mk", input)
+	for i, tt := range tests {
+		tok := l.NextToken()
+		if tok.Literal != tt.expectedLiteral {
+			t.Fatalf("test[%d] - literal wrong. expected=%q, got=%q",
+				i, tt.expectedLiteral, tok.Literal)
+		}
+		if tok.Pos.Filename != "test.mk" {
+			t.Fatalf("test[%d] - filename wrong. got=%q", i, tok.Pos.Filename)
+		}
+		if tok.Pos.Line != tt.expectedLine || tok.Pos.Column != tt.expectedColumn {
+			t.Fatalf("test[%d] - position wrong. expected=%d:%d, got=%d:%d",
+				i, tt.expectedLine, tt.expectedColumn, tok.Pos.Line, tok.Pos.Column)
+		}
+		if tok.Pos.Offset != tt.expectedOffset {
+			t.Fatalf("test[%d] - offset wrong. expected=%d, got=%d",
+				i, tt.expectedOffset, tok.Pos.Offset)
+		}
+	}
+}
+
+// コメントの読み飛ばしのテスト
+func TestComments(t *testing.T) {
+	input := `// 先頭のコメント
+let x = 5; // 行末のコメント
+/* ブロック
+   コメント */ x /* 入れ子の /* ブロック */ コメント */ / 2;
+a*/*c*/b
+`
+	tests := []struct {
+		expectedType     token.TokenType
+		expectedLiteral  string
+		expectedComments []string
+	}{
+		{token.LET, "let", []string{"// 先頭のコメント"}},
+		{token.IDENT, "x", nil},
+		{token.ASSIGN, "=", nil},
+		{token.INT, "5", nil},
+		{token.SEMICOLON, ";", nil},
+		{token.IDENT, "x", []string{"// 行末のコメント", "/* ブロック\n   コメント */"}},
+		{token.SLASH, "/", []string{"/* 入れ子の /* ブロック */ コメント */"}},
+		{token.INT, "2", nil},
+		{token.SEMICOLON, ";", nil},
+		{token.IDENT, "a", nil},
+		{token.ASTERISK, "*", nil},
+		{token.IDENT, "b", []string{"/*c*/"}},
+		{token.EOF, "", nil},
+	}
+
+	l := New(input)
+	for i, tt := range tests {
+		tok := l.NextToken()
+		if tok.Type != tt.expectedType {
+			t.Fatalf("test[%d] - tokentype wrong. expected=%q, got=%q",
+				i, tt.expectedType, tok.Type)
+		}
+		if tok.Literal != tt.expectedLiteral {
+			t.Fatalf("test[%d] - literal wrong. expected=%q, got=%q",
+				i, tt.expectedLiteral, tok.Literal)
+		}
+		if len(tok.Comments) != len(tt.expectedComments) {
+			t.Fatalf("test[%d] - comments wrong. expected=%q, got=%q",
+				i, tt.expectedComments, tok.Comments)
+		}
+		for j, c := range tt.expectedComments {
+			if tok.Comments[j] != c {
+				t.Fatalf("test[%d] - comment wrong. expected=%q, got=%q", i, c, tok.Comments[j])
+			}
+		}
+	}
+	if len(l.Errors()) != 0 {
+		t.Fatalf("unexpected lexer errors: %v", l.Errors())
+	}
+}
+
+// 字句解析エラーのテスト
+func TestLexerErrors(t *testing.T) {
+	tests := []struct {
+		input           string
+		expectedLiteral string
+		expectedMessage string
+	}{
+		{"1 /* 閉じない /* 入れ子 */", "/* 閉じない /* 入れ子 */", "1:3: unterminated block comment"},
+		{"1 /* コメント */ */ 2", "*/", "1:14: unexpected */ outside of block comment"},
+		{"let x = @;", "@", "1:9: unexpected character '@'"},
+	}
+
+	for i, tt := range tests {
+		l := New(tt.input)
+		var illegal token.Token
+		for tok := l.NextToken(); tok.Type != token.EOF; tok = l.NextToken() {
+			if tok.Type == token.ILLEGAL {
+				illegal = tok
+			}
+		}
+		if illegal.Literal != tt.expectedLiteral {
+			t.Errorf("test[%d] - illegal literal wrong. expected=%q, got=%q",
+				i, tt.expectedLiteral, illegal.Literal)
+		}
+		errors := l.Errors()
+		if len(errors) != 1 {
+			t.Fatalf("test[%d] - wrong number of errors. got=%d", i, len(errors))
+		}
+		if errors[0].Error() != tt.expectedMessage {
+			t.Errorf("test[%d] - error wrong. expected=%q, got=%q",
+				i, tt.expectedMessage, errors[0].Error())
+		}
+	}
+}
+
+// エスケープシーケンスと生文字列のテスト
+func TestStrings(t *testing.T) {
+	tests := []struct {
+		input           string
+		expectedLiteral string
+	}{
+		{`"hello \"world\""`, `hello "world"`},
+		{`"hello\n world"`, "hello\n world"},
+		{`"hello\t\t\tworld"`, "hello\t\t\tworld"},
+		{`"back\\slash\r\0"`, "back\\slash\r\x00"},
+		{`"\u{41}\u{3042}\u{1F600}"`, "Aあ😀"},
+		{`"日本語"`, "日本語"},
+		{"`raw \\n \"string\"\nwith newline`", "raw \\n \"string\"\nwith newline"},
+		{"``", ""},
+	}
+
+	for i, tt := range tests {
+		l := New(tt.input)
+		tok := l.NextToken()
+		if tok.Type != token.STRING {
+			t.Fatalf("test[%d] - tokentype wrong. expected=%q, got=%q (%v)",
+				i, token.STRING, tok.Type, l.Errors())
+		}
+		if tok.Literal != tt.expectedLiteral {
+			t.Fatalf("test[%d] - literal wrong. expected=%q, got=%q",
+				i, tt.expectedLiteral, tok.Literal)
+		}
+		if next := l.NextToken(); next.Type != token.EOF {
+			t.Fatalf("test[%d] - expected EOF after string. got=%q", i, next.Type)
+		}
+	}
+}
+
+// 数値リテラルの字句解析のテスト
+func TestNumbers(t *testing.T) {
+	tests := []struct {
+		input           string
+		expectedType    token.TokenType
+		expectedLiteral string
+	}{
+		{"5", token.INT, "5"},
+		{"1.5", token.FLOAT, "1.5"},
+		{"0.25", token.FLOAT, "0.25"},
+		{"1e-9", token.FLOAT, "1e-9"},
+		{"2.5E+3", token.FLOAT, "2.5E+3"},
+		{"3e8", token.FLOAT, "3e8"},
+	}
+
+	for i, tt := range tests {
+		l := New(tt.input)
+		tok := l.NextToken()
+		if tok.Type != tt.expectedType {
+			t.Fatalf("test[%d] - tokentype wrong. expected=%q, got=%q",
+				i, tt.expectedType, tok.Type)
+		}
+		if tok.Literal != tt.expectedLiteral {
+			t.Fatalf("test[%d] - literal wrong. expected=%q, got=%q",
+				i, tt.expectedLiteral, tok.Literal)
+		}
+		if next := l.NextToken(); next.Type != token.EOF {
+			t.Fatalf("test[%d] - expected EOF after number. got=%q", i, next.Type)
+		}
+	}
+
+	// 「.」や「e」の後に数字が続かなければ数値の一部にはしない
+	l := New("1.foo 2e")
+	expected := []token.TokenType{
+		token.INT, token.ILLEGAL, token.IDENT, token.INT, token.IDENT, token.EOF,
+	}
+	for i, tt := range expected {
+		tok := l.NextToken()
+		if tok.Type != tt {
+			t.Fatalf("tokens[%d] - tokentype wrong. expected=%q, got=%q", i, tt, tok.Type)
+		}
+	}
+}
+
+// 名前空間付きの識別子のテスト
+func TestQualifiedIdentifiers(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected []token.Token
+	}{
+		{"strings.upper(s)", []token.Token{
+			{Type: token.IDENT, Literal: "strings.upper"}, {Type: token.LPAREN, Literal: "("},
+			{Type: token.IDENT, Literal: "s"}, {Type: token.RPAREN, Literal: ")"},
+		}},
+		{"a.b.c", []token.Token{{Type: token.IDENT, Literal: "a.b.c"}}},
+		// 「.」の後に英字が続かなければ識別子の一部にはしない
+		{"a.1", []token.Token{
+			{Type: token.IDENT, Literal: "a"}, {Type: token.ILLEGAL, Literal: "."}, {Type: token.INT, Literal: "1"},
+		}},
+	}
+
+	for i, tt := range tests {
+		l := New(tt.input)
+		for j, expected := range tt.expected {
+			tok := l.NextToken()
+			if tok.Type != expected.Type || tok.Literal != expected.Literal {
+				t.Fatalf("test[%d] tokens[%d] wrong. expected=%q(%q), got=%q(%q)",
+					i, j, expected.Type, expected.Literal, tok.Type, tok.Literal)
+			}
+		}
+		if next := l.NextToken(); next.Type != token.EOF {
+			t.Fatalf("test[%d] - expected EOF. got=%q", i, next.Type)
+		}
+	}
+}
+
+// 文字列の字句解析エラーのテスト
+func TestStringErrors(t *testing.T) {
+	tests := []struct {
+		input           string
+		expectedMessage string
+	}{
+		{`"unterminated`, "1:1: unterminated string"},
+		{`let s = "abc\`, "1:9: unterminated string"},
+		{"x = `raw", "1:5: unterminated raw string"},
+		{`"bad \q escape"`, `1:6: unknown escape sequence \q`},
+		{`"\u{110000}"`, "1:2: invalid unicode code point U+110000"},
+		{`"\u{}"`, "1:2: invalid unicode escape: expected 1 to 6 hex digits followed by }"},
+		{`"\u41"`, "1:2: invalid unicode escape: expected {"},
+	}
+
+	for i, tt := range tests {
+		l := New(tt.input)
+		for tok := l.NextToken(); tok.Type != token.EOF; tok = l.NextToken() {
+		}
+		errors := l.Errors()
+		if len(errors) != 1 {
+			t.Fatalf("test[%d] - wrong number of errors. got=%d (%v)", i, len(errors), errors)
+		}
+		if errors[0].Error() != tt.expectedMessage {
+			t.Errorf("test[%d] - error wrong. expected=%q, got=%q",
+				i, tt.expectedMessage, errors[0].Error())
+		}
+	}
+}
@@ -0,0 +1,149 @@
+package object
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+)
+
+// -----------------------------------------------------
+// Budgetの定義
+// スクリプトの実行に課す制限
+// 実行できるステップ数の上限、確保できるメモリの上限と、contextによるキャンセルを扱う
+// VMは命令を一つ実行するたびに、評価器はノードを一つ評価するたびにStepを呼ぶ
+// 配列・文字列・ハッシュを生成したときはAllocateでその大きさを計上する
+type Budget struct {
+	ctx       context.Context
+	maxSteps  int64 // 0なら無制限
+	steps     int64 // これまでに消費したステップ数
+	maxBytes  int64 // 0なら無制限
+	allocated int64 // これまでに確保したバイト数（解放された分は差し引かない）
+	err       error // 制限に達した理由
+}
+
+// ステップ数の上限に達したときのエラー
+var ErrBudgetExhausted = errors.New("execution budget exhausted")
+
+// メモリの上限に達したときのエラー
+var ErrAllocationLimit = errors.New("allocation limit exceeded")
+
+// contextを調べる間隔（ステップ数）
+// 毎回調べると遅いので間引く
+const budgetCheckInterval = 1024
+
+// ctxがキャンセルされるか、maxStepsステップを超えると打ち切るBudgetを生成する
+// maxStepsが0以下なら上限なし
+func NewBudget(ctx context.Context, maxSteps int64) *Budget {
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	if maxSteps < 0 {
+		maxSteps = 0
+	}
+	return &Budget{ctx: ctx, maxSteps: maxSteps}
+}
+
+// 1ステップ消費する
+// 上限を超えた場合はErrBudgetExhaustedを、contextがキャンセルされた場合はctx.Err()をラップしたエラーを返す
+func (b *Budget) Step() error {
+	b.steps++
+	if b.err == nil && b.steps%budgetCheckInterval != 0 && (b.maxSteps == 0 || b.steps <= b.maxSteps) {
+		return nil
+	}
+	return b.check()
+}
+
+func (b *Budget) check() error {
+	if b.err != nil {
+		return b.err
+	}
+	if b.maxSteps > 0 && b.steps > b.maxSteps {
+		b.err = ErrBudgetExhausted
+	} else if err := b.ctx.Err(); err != nil {
+		b.err = fmt.Errorf("execution cancelled: %w", err)
+	}
+	return b.err
+}
+
+// 確保できるメモリの上限をmaxBytesバイトにする
+// maxBytesが0以下なら上限なし
+func (b *Budget) LimitAllocation(maxBytes int64) *Budget {
+	if maxBytes < 0 {
+		maxBytes = 0
+	}
+	b.maxBytes = maxBytes
+	return b
+}
+
+// objを生成したことを記録する
+// 上限を超えた場合はErrAllocationLimitを返す
+func (b *Budget) Allocate(obj Object) error {
+	return b.AllocateBytes(SizeOf(obj))
+}
+
+// sizeバイトを確保したことを記録する
+func (b *Budget) AllocateBytes(size int64) error {
+	if size > math.MaxInt64-b.allocated {
+		b.allocated = math.MaxInt64
+	} else {
+		b.allocated += size
+	}
+	if b.err == nil && b.maxBytes > 0 && b.allocated > b.maxBytes {
+		b.err = ErrAllocationLimit
+	}
+	return b.err
+}
+
+// これまでに確保したバイト数を返す
+func (b *Budget) Allocated() int64 {
+	return b.allocated
+}
+
+// 制限に達していればその理由を返す
+func (b *Budget) Err() error {
+	return b.err
+}
+
+// これまでに消費したステップ数を返す
+func (b *Budget) Steps() int64 {
+	return b.steps
+}
+
+// -----------------------------------------------------
+
+// -----------------------------------------------------
+// メモリの使用量の見積もり
+// 正確な値ではなく、スクリプトが確保するメモリの量の目安として使う
+
+// 見積もりに使う大きさ（バイト）
+const (
+	arrayHeaderSize  = 32 // Arrayとスライスのヘッダ
+	ArrayElementSize = 16 // 配列の要素一つ（インターフェース値）
+	stringHeaderSize = 24 // Stringと文字列のヘッダ
+	hashHeaderSize   = 48 // Hashとmapのヘッダ
+	HashEntrySize    = 64 // ハッシュのペア一つ（HashKeyとHashPairとmapの管理領域）
+	bigIntHeaderSize = 32 // BigIntとbig.Intのヘッダ
+)
+
+// 配列・タプル・文字列・ハッシュ・BigIntの大きさを見積もる
+// 要素そのものの大きさは含まない（要素は別に生成されたときに計上される）
+// BigIntは掛け算やシフトでいくらでも大きくなるので、桁のバイト数も含める
+// それ以外のオブジェクトは0とする
+func SizeOf(obj Object) int64 {
+	switch obj := obj.(type) {
+	case *Array:
+		return arrayHeaderSize + ArrayElementSize*int64(len(obj.Elements))
+	case *Tuple:
+		return arrayHeaderSize + ArrayElementSize*int64(len(obj.Elements))
+	case *String:
+		return stringHeaderSize + int64(len(obj.Value))
+	case *Hash:
+		return hashHeaderSize + HashEntrySize*int64(obj.Len())
+	case *BigInt:
+		return bigIntHeaderSize + int64(obj.Value.BitLen()+7)/8
+	}
+	return 0
+}
+
+// -----------------------------------------------------
@@ -1,68 +1,68 @@
 package object
 
-import "fmt"
+import (
+	"fmt"
+	"math"
+	"sort"
+	"strings"
+	"unicode/utf8"
+)
 
-var Builtins = []struct {
+// 標準の組み込み関数
+// NewStandardRegistryがこの順に登録する
+// 引数の数と型はSignatureで確かめるので、Fnでは確かめなくてよい
+var standardBuiltins = []struct {
 	Name    string
 	Builtin *Builtin
 }{
 	{
 		"len",
 		&Builtin{
-			Fn: func(args ...Object) Object {
-				if len(args) != 1 {
-					return newError("wrong number of arguments. got=%d, want=1", len(args))
-				}
+			Fn: func(call Invoker, args ...Object) Object {
 				switch arg := args[0].(type) {
 				case *Array:
 					return &Integer{Value: int64(len(arg.Elements))}
+				case *Tuple:
+					return &Integer{Value: int64(len(arg.Elements))}
 				case *String:
-					return &Integer{Value: int64(len(arg.Value))}
+					// バイト数ではなく文字（rune）数を返す
+					return &Integer{Value: int64(utf8.RuneCountInString(arg.Value))}
 				default:
 					return newError("argument to `len` not supported, got %s", args[0].Type())
 				}
 			},
+			Signature: &Signature{MinArgs: 1, MaxArgs: 1},
 		},
 	},
 	{
 		"puts",
 		&Builtin{
-			Fn: func(args ...Object) Object {
+			Fn: func(call Invoker, args ...Object) Object {
 				for _, arg := range args {
 					fmt.Println(arg.Inspect())
 				}
 				return nil
 			},
+			Signature: &Signature{MinArgs: 0, MaxArgs: -1},
 		},
 	},
 	{
 		"first",
 		&Builtin{
-			Fn: func(args ...Object) Object {
-				if len(args) != 1 {
-					return newError("wrong number of arguments. got=%d, want=1", len(args))
-				}
-				if args[0].Type() != ARRAY_OBJ {
-					return newError("argument to `first` must be ARRAY, got %s", args[0].Type())
-				}
+			Fn: func(call Invoker, args ...Object) Object {
 				arr := args[0].(*Array)
 				if len(arr.Elements) > 0 {
 					return arr.Elements[0]
 				}
 				return nil
 			},
+			Signature: &Signature{MinArgs: 1, MaxArgs: 1, Types: []ObjectType{ARRAY_OBJ}},
 		},
 	},
 	{
 		"last",
 		&Builtin{
-			Fn: func(args ...Object) Object {
-				if len(args) != 1 {
-					return newError("wrong number og arguments. got=%d, want=1", len(args))
-				}
-				if args[0].Type() != ARRAY_OBJ {
-					return newError("argument to `last` must be ARRAY, got %s", args[0].Type())
-				}
+			Fn: func(call Invoker, args ...Object) Object {
 				arr := args[0].(*Array)
 				length := len(arr.Elements)
 				if length > 0 {
@@ -70,18 +70,13 @@ var Builtins = []struct {
 				}
 				return nil
 			},
+			Signature: &Signature{MinArgs: 1, MaxArgs: 1, Types: []ObjectType{ARRAY_OBJ}},
 		},
 	},
 	{
 		"rest",
 		&Builtin{
-			Fn: func(args ...Object) Object {
-				if len(args) != 1 {
-					return newError("wrong number of arguments. got=%d, want=1", len(args))
-				}
-				if args[0].Type() != ARRAY_OBJ {
-					return newError("argument to `rest` must be ARRAY, got %s", args[0].Type())
-				}
+			Fn: func(call Invoker, args ...Object) Object {
 				arr := args[0].(*Array)
 				length := len(arr.Elements)
 				if length > 0 {
@@ -91,18 +86,14 @@ var Builtins = []struct {
 				}
 				return nil
 			},
+			Signature: &Signature{MinArgs: 1, MaxArgs: 1, Types: []ObjectType{ARRAY_OBJ}},
+			Allocates: true,
 		},
 	},
 	{
 		"push",
 		&Builtin{
-			Fn: func(args ...Object) Object {
-				if len(args) != 2 {
-					return newError("wrong number if arguments. got=%d, want=2", len(args))
-				}
-				if args[0].Type() != ARRAY_OBJ {
-					return newError("argument to `push` must be ARRAY, got %s", args[0].Type())
-				}
+			Fn: func(call Invoker, args ...Object) Object {
 				arr := args[0].(*Array)
 				length := len(arr.Elements)
 				newElements := make([]Object, length+1, length+1)
@@ -110,19 +101,636 @@ var Builtins = []struct {
 				newElements[length] = args[1]
 				return &Array{Elements: newElements}
 			},
+			Signature: &Signature{MinArgs: 2, MaxArgs: 2, Types: []ObjectType{ARRAY_OBJ, ANY_OBJ}},
+			Allocates: true,
+		},
+	},
+	{
+		"range",
+		&Builtin{
+			Fn: func(call Invoker, args ...Object) Object {
+				// range(end), range(start, end), range(start, end, step)
+				bounds := make([]int64, len(args))
+				for i, arg := range args {
+					bounds[i] = arg.(*Integer).Value
+				}
+				r := &Range{Start: 0, Step: 1}
+				switch len(bounds) {
+				case 1:
+					r.End = bounds[0]
+				case 2:
+					r.Start, r.End = bounds[0], bounds[1]
+				case 3:
+					r.Start, r.End, r.Step = bounds[0], bounds[1], bounds[2]
+				}
+				if r.Step == 0 {
+					return newError("range step must not be zero")
+				}
+				return r
+			},
+			Signature: &Signature{MinArgs: 1, MaxArgs: 3, Types: []ObjectType{INTEGER_OBJ}},
+		},
+	},
+	{
+		"map",
+		&Builtin{
+			Fn: func(call Invoker, args ...Object) Object {
+				// map(array, fn) -> [fn(array[0]), fn(array[1]), ...]
+				arr := args[0].(*Array)
+				newElements := make([]Object, len(arr.Elements))
+				for i, element := range arr.Elements {
+					result := call(args[1], element)
+					if isError(result) {
+						return result
+					}
+					newElements[i] = result
+				}
+				return &Array{Elements: newElements}
+			},
+			Signature: &Signature{MinArgs: 2, MaxArgs: 2, Types: []ObjectType{ARRAY_OBJ, ANY_OBJ}},
+			Allocates: true,
+		},
+	},
+	{
+		"filter",
+		&Builtin{
+			Fn: func(call Invoker, args ...Object) Object {
+				// filter(array, fn) -> fn(element)が真になる要素だけの配列
+				arr := args[0].(*Array)
+				newElements := []Object{}
+				for _, element := range arr.Elements {
+					result := call(args[1], element)
+					if isError(result) {
+						return result
+					}
+					if isTruthy(result) {
+						newElements = append(newElements, element)
+					}
+				}
+				return &Array{Elements: newElements}
+			},
+			Signature: &Signature{MinArgs: 2, MaxArgs: 2, Types: []ObjectType{ARRAY_OBJ, ANY_OBJ}},
+			Allocates: true,
+		},
+	},
+	{
+		"reduce",
+		&Builtin{
+			Fn: func(call Invoker, args ...Object) Object {
+				// reduce(array, initial, fn) -> fn(...fn(fn(initial, array[0]), array[1])..., array[n-1])
+				arr := args[0].(*Array)
+				acc := args[1]
+				for _, element := range arr.Elements {
+					acc = call(args[2], acc, element)
+					if isError(acc) {
+						return acc
+					}
+				}
+				return acc
+			},
+			Signature: &Signature{MinArgs: 3, MaxArgs: 3, Types: []ObjectType{ARRAY_OBJ, ANY_OBJ}},
+		},
+	},
+	{
+		"sort_by",
+		&Builtin{
+			Fn: func(call Invoker, args ...Object) Object {
+				// sort_by(array, fn) -> fn(element)の昇順に並べた新しい配列（安定ソート）
+				arr := args[0].(*Array)
+				keys := make([]Object, len(arr.Elements))
+				for i, element := range arr.Elements {
+					key := call(args[1], element)
+					if isError(key) {
+						return key
+					}
+					keys[i] = key
+				}
+				for _, key := range keys {
+					if _, err := compareSortKeys(keys[0], key); err != nil {
+						return err
+					}
+				}
+				indices := make([]int, len(arr.Elements))
+				for i := range indices {
+					indices[i] = i
+				}
+				sort.SliceStable(indices, func(i, j int) bool {
+					c, _ := compareSortKeys(keys[indices[i]], keys[indices[j]])
+					return c < 0
+				})
+				newElements := make([]Object, len(indices))
+				for i, index := range indices {
+					newElements[i] = arr.Elements[index]
+				}
+				return &Array{Elements: newElements}
+			},
+			Signature: &Signature{MinArgs: 2, MaxArgs: 2, Types: []ObjectType{ARRAY_OBJ, ANY_OBJ}},
+			Allocates: true,
+		},
+	},
+
+	// 文字列を扱う組み込み関数
+	// 添字や長さはバイトではなく文字（rune）単位で数える
+	{
+		"split",
+		&Builtin{
+			Fn: func(call Invoker, args ...Object) Object {
+				// split("a,b", ",") -> ["a", "b"]、区切りが""なら1文字ずつに分ける
+				parts := strings.Split(stringArg(args, 0), stringArg(args, 1))
+				return stringArray(parts)
+			},
+			Signature: &Signature{MinArgs: 2, MaxArgs: 2, Types: []ObjectType{STRING_OBJ}},
+			Allocates: true,
+		},
+	},
+	{
+		"join",
+		&Builtin{
+			Fn: func(call Invoker, args ...Object) Object {
+				// join(["a", "b"], ",") -> "a,b"
+				arr := args[0].(*Array)
+				parts := make([]string, len(arr.Elements))
+				for i, element := range arr.Elements {
+					str, ok := element.(*String)
+					if !ok {
+						return newError("argument to `join` must be ARRAY of STRING, got %s in ARRAY", element.Type())
+					}
+					parts[i] = str.Value
+				}
+				if length := joinLength(args); length > maxStringLength {
+					return newError("join result too long: %d bytes", length)
+				}
+				return &String{Value: strings.Join(parts, stringArg(args, 1))}
+			},
+			Signature: &Signature{MinArgs: 2, MaxArgs: 2, Types: []ObjectType{ARRAY_OBJ, STRING_OBJ}},
+			Allocates: true,
+			Size:      func(args []Object) int64 { return stringSize(joinLength(args)) },
+		},
+	},
+	{
+		"trim",
+		&Builtin{
+			Fn: func(call Invoker, args ...Object) Object {
+				// 前後の空白を取り除く
+				return &String{Value: strings.TrimSpace(stringArg(args, 0))}
+			},
+			Signature: &Signature{MinArgs: 1, MaxArgs: 1, Types: []ObjectType{STRING_OBJ}},
+			Allocates: true,
+		},
+	},
+	{
+		"replace",
+		&Builtin{
+			Fn: func(call Invoker, args ...Object) Object {
+				// replace(s, old, new) -> sに含まれるoldをすべてnewに置き換えた文字列
+				if length := replaceLength(args); length > maxStringLength {
+					return newError("replace result too long: %d bytes", length)
+				}
+				return &String{Value: strings.ReplaceAll(stringArg(args, 0), stringArg(args, 1), stringArg(args, 2))}
+			},
+			Signature: &Signature{MinArgs: 3, MaxArgs: 3, Types: []ObjectType{STRING_OBJ}},
+			Allocates: true,
+			Size:      func(args []Object) int64 { return stringSize(replaceLength(args)) },
+		},
+	},
+	{
+		"contains",
+		&Builtin{
+			Fn: func(call Invoker, args ...Object) Object {
+				return nativeBoolToBoolean(strings.Contains(stringArg(args, 0), stringArg(args, 1)))
+			},
+			Signature: &Signature{MinArgs: 2, MaxArgs: 2, Types: []ObjectType{STRING_OBJ}},
+		},
+	},
+	{
+		"index_of",
+		&Builtin{
+			Fn: func(call Invoker, args ...Object) Object {
+				// index_of(s, sub) -> subが最初に現れる位置（文字単位）、なければ-1
+				s := stringArg(args, 0)
+				i := strings.Index(s, stringArg(args, 1))
+				if i < 0 {
+					return &Integer{Value: -1}
+				}
+				return &Integer{Value: int64(utf8.RuneCountInString(s[:i]))}
+			},
+			Signature: &Signature{MinArgs: 2, MaxArgs: 2, Types: []ObjectType{STRING_OBJ}},
+		},
+	},
+	{
+		"starts_with",
+		&Builtin{
+			Fn: func(call Invoker, args ...Object) Object {
+				return nativeBoolToBoolean(strings.HasPrefix(stringArg(args, 0), stringArg(args, 1)))
+			},
+			Signature: &Signature{MinArgs: 2, MaxArgs: 2, Types: []ObjectType{STRING_OBJ}},
+		},
+	},
+	{
+		"ends_with",
+		&Builtin{
+			Fn: func(call Invoker, args ...Object) Object {
+				return nativeBoolToBoolean(strings.HasSuffix(stringArg(args, 0), stringArg(args, 1)))
+			},
+			Signature: &Signature{MinArgs: 2, MaxArgs: 2, Types: []ObjectType{STRING_OBJ}},
+		},
+	},
+	{
+		"upper",
+		&Builtin{
+			Fn: func(call Invoker, args ...Object) Object {
+				return &String{Value: strings.ToUpper(stringArg(args, 0))}
+			},
+			Signature: &Signature{MinArgs: 1, MaxArgs: 1, Types: []ObjectType{STRING_OBJ}},
+			Allocates: true,
+		},
+	},
+	{
+		"lower",
+		&Builtin{
+			Fn: func(call Invoker, args ...Object) Object {
+				return &String{Value: strings.ToLower(stringArg(args, 0))}
+			},
+			Signature: &Signature{MinArgs: 1, MaxArgs: 1, Types: []ObjectType{STRING_OBJ}},
+			Allocates: true,
+		},
+	},
+	{
+		"repeat",
+		&Builtin{
+			Fn: func(call Invoker, args ...Object) Object {
+				// repeat("ab", 3) -> "ababab"
+				s := stringArg(args, 0)
+				n := args[1].(*Integer).Value
+				if n < 0 {
+					return newError("repeat count must not be negative, got %d", n)
+				}
+				if repeatLength(args) > maxStringLength {
+					return newError("repeat result too long: %d * %d bytes", n, len(s))
+				}
+				return &String{Value: strings.Repeat(s, int(n))}
+			},
+			Signature: &Signature{MinArgs: 2, MaxArgs: 2, Types: []ObjectType{STRING_OBJ, INTEGER_OBJ}},
+			Allocates: true,
+			Size:      func(args []Object) int64 { return stringSize(repeatLength(args)) },
+		},
+	},
+	{
+		"substring",
+		&Builtin{
+			Fn: func(call Invoker, args ...Object) Object {
+				// substring(s, start) -> start文字目から最後まで
+				// substring(s, start, end) -> start文字目からend文字目の手前まで
+				runes := []rune(stringArg(args, 0))
+				start, end := args[1].(*Integer).Value, int64(len(runes))
+				if len(args) == 3 {
+					end = args[2].(*Integer).Value
+				}
+				if start < 0 || end < start || int64(len(runes)) < end {
+					return newError("substring out of range: [%d:%d] with length %d", start, end, len(runes))
+				}
+				return &String{Value: string(runes[start:end])}
+			},
+			Signature: &Signature{MinArgs: 2, MaxArgs: 3, Types: []ObjectType{STRING_OBJ, INTEGER_OBJ}},
+			Allocates: true,
+		},
+	},
+	{
+		"chars",
+		&Builtin{
+			Fn: func(call Invoker, args ...Object) Object {
+				// chars("héllo") -> ["h", "é", "l", "l", "o"]
+				s := stringArg(args, 0)
+				chars := make([]string, 0, utf8.RuneCountInString(s))
+				for _, r := range s {
+					chars = append(chars, string(r))
+				}
+				return stringArray(chars)
+			},
+			Signature: &Signature{MinArgs: 1, MaxArgs: 1, Types: []ObjectType{STRING_OBJ}},
+			Allocates: true,
+		},
+	},
+	{
+		"format",
+		formatBuiltin,
+	},
+	{
+		"sprintf",
+		formatBuiltin,
+	},
+
+	// ハッシュを扱う組み込み関数
+	// ペアはハッシュに追加された順に扱う
+	{
+		"keys",
+		&Builtin{
+			Fn: func(call Invoker, args ...Object) Object {
+				pairs := args[0].(*Hash).Pairs()
+				elements := make([]Object, len(pairs))
+				for i, pair := range pairs {
+					elements[i] = pair.Key
+				}
+				return &Array{Elements: elements}
+			},
+			Signature: &Signature{MinArgs: 1, MaxArgs: 1, Types: []ObjectType{HASH_OBJ}},
+			Allocates: true,
+		},
+	},
+	{
+		"values",
+		&Builtin{
+			Fn: func(call Invoker, args ...Object) Object {
+				pairs := args[0].(*Hash).Pairs()
+				elements := make([]Object, len(pairs))
+				for i, pair := range pairs {
+					elements[i] = pair.Value
+				}
+				return &Array{Elements: elements}
+			},
+			Signature: &Signature{MinArgs: 1, MaxArgs: 1, Types: []ObjectType{HASH_OBJ}},
+			Allocates: true,
+		},
+	},
+	{
+		"entries",
+		&Builtin{
+			Fn: func(call Invoker, args ...Object) Object {
+				// entries({"a": 1}) -> [["a", 1]]
+				pairs := args[0].(*Hash).Pairs()
+				elements := make([]Object, len(pairs))
+				for i, pair := range pairs {
+					elements[i] = &Array{Elements: []Object{pair.Key, pair.Value}}
+				}
+				return &Array{Elements: elements}
+			},
+			Signature: &Signature{MinArgs: 1, MaxArgs: 1, Types: []ObjectType{HASH_OBJ}},
+			Allocates: true,
+		},
+	},
+	{
+		"has",
+		&Builtin{
+			Fn: func(call Invoker, args ...Object) Object {
+				key, err := hashableArg(args[1])
+				if err != nil {
+					return err
+				}
+				_, ok := args[0].(*Hash).Get(key)
+				return nativeBoolToBoolean(ok)
+			},
+			Signature: &Signature{MinArgs: 2, MaxArgs: 2, Types: []ObjectType{HASH_OBJ, ANY_OBJ}},
+		},
+	},
+	{
+		"delete",
+		&Builtin{
+			Fn: func(call Invoker, args ...Object) Object {
+				// delete(hash, key) -> ハッシュからkeyのペアを取り除き、取り除いたかを返す
+				// 新しいハッシュは作らず、渡したハッシュそのものを書き換える
+				key, err := hashableArg(args[1])
+				if err != nil {
+					return err
+				}
+				return nativeBoolToBoolean(args[0].(*Hash).Delete(key))
+			},
+			Signature: &Signature{MinArgs: 2, MaxArgs: 2, Types: []ObjectType{HASH_OBJ, ANY_OBJ}},
+		},
+	},
+	{
+		"merge",
+		&Builtin{
+			Fn: func(call Invoker, args ...Object) Object {
+				// merge(a, b, ...) -> aのペアにb以降のペアを順に加えた新しいハッシュ
+				// 同じキーがあれば後のハッシュの値になり、位置は最初に現れたところのまま
+				merged := &Hash{}
+				for _, arg := range args {
+					for _, pair := range arg.(*Hash).Pairs() {
+						merged.Set(pair.Key.(Hashable), pair.Value)
+					}
+				}
+				return merged
+			},
+			Signature: &Signature{MinArgs: 1, MaxArgs: -1, Types: []ObjectType{HASH_OBJ}},
+			Allocates: true,
+		},
+	},
+
+	// 書き換えられない値を作る組み込み関数
+	// 要素がすべてキーとして使えるなら、結果はハッシュのキーとして使える
+	{
+		"tuple",
+		&Builtin{
+			Fn: func(call Invoker, args ...Object) Object {
+				// tuple(1, "a") -> (1, a)
+				elements := make([]Object, len(args))
+				copy(elements, args)
+				return &Tuple{Elements: elements}
+			},
+			Signature: &Signature{MinArgs: 0, MaxArgs: -1},
+			Allocates: true,
+		},
+	},
+	{
+		"freeze",
+		&Builtin{
+			Fn: func(call Invoker, args ...Object) Object {
+				// freeze(arr) -> 入れ子の配列まで凍結したarrの複製
+				arr := args[0].(*Array)
+				if _, ok := freezeSize(arr, make(map[*Array]int64)); !ok {
+					return newError("cannot freeze self-referencing ARRAY")
+				}
+				return arr.Freeze()
+			},
+			Signature: &Signature{MinArgs: 1, MaxArgs: 1, Types: []ObjectType{ARRAY_OBJ}},
+			Allocates: true,
+			Size: func(args []Object) int64 {
+				size, _ := freezeSize(args[0].(*Array), make(map[*Array]int64))
+				return size
+			},
 		},
 	},
 }
 
-func newError(format string, a ...interface{}) *Error {
-	return &Error{Message: fmt.Sprintf(format, a...)}
+// ハッシュのキーとして使う引数をHashableとして返すヘルパー関数
+func hashableArg(arg Object) (Hashable, *Error) {
+	key, ok := AsHashable(arg)
+	if !ok {
+		return nil, newError("unusable as hash key: %s", arg.Type())
+	}
+	return key, nil
 }
 
-func GetBuiltinByName(name string) *Builtin {
-	for _, def := range Builtins {
-		if def.Name == name {
-			return def.Builtin
+// format("%s is %d", "x", 1) -> "x is 1"
+// 書式はGoのfmt.Sprintfと同じ
+// 整数・浮動小数点数・文字列・真偽値はGoの値として、それ以外はInspect()の結果の文字列として渡す
+var formatBuiltin = &Builtin{
+	Fn: func(call Invoker, args ...Object) Object {
+		values := make([]interface{}, len(args)-1)
+		for i, arg := range args[1:] {
+			switch arg := arg.(type) {
+			case *Integer:
+				values[i] = arg.Value
+			case *BigInt:
+				values[i] = arg.Value
+			case *Float:
+				values[i] = arg.Value
+			case *String:
+				values[i] = arg.Value
+			case *Boolean:
+				values[i] = arg.Value
+			default:
+				values[i] = arg.Inspect()
+			}
+		}
+		return &String{Value: fmt.Sprintf(stringArg(args, 0), values...)}
+	},
+	Signature: &Signature{MinArgs: 1, MaxArgs: -1, Types: []ObjectType{STRING_OBJ, ANY_OBJ}},
+	Allocates: true,
+}
+
+// repeatなどで作る文字列の長さの上限（バイト）
+// 制限（Budget）がなくても、これを超える文字列は作らずにエラーにする
+const maxStringLength = 1 << 30
+
+// 長さlengthの文字列の大きさを返すヘルパー関数
+// 長すぎる文字列はFnがエラーにして作らないので0とする
+func stringSize(length int64) int64 {
+	if length > maxStringLength {
+		return 0
+	}
+	return stringHeaderSize + length
+}
+
+// repeatが作る文字列の長さを求めるヘルパー関数
+func repeatLength(args []Object) int64 {
+	s, n := stringArg(args, 0), args[1].(*Integer).Value
+	if s == "" || n <= 0 {
+		return 0
+	}
+	if n > math.MaxInt64/int64(len(s)) {
+		return math.MaxInt64
+	}
+	return int64(len(s)) * n
+}
+
+// replaceが作る文字列の長さを求めるヘルパー関数
+// oldが""なら文字の間と両端のすべてにnewが入る
+func replaceLength(args []Object) int64 {
+	s, old, new := stringArg(args, 0), stringArg(args, 1), stringArg(args, 2)
+	return int64(len(s)) + int64(strings.Count(s, old))*(int64(len(new))-int64(len(old)))
+}
+
+// joinが作る文字列の長さを求めるヘルパー関数
+// 文字列でない要素は数えない（Fnがエラーにする）
+func joinLength(args []Object) int64 {
+	elements, sep := args[0].(*Array).Elements, stringArg(args, 1)
+	var length int64
+	for i, element := range elements {
+		if str, ok := element.(*String); ok {
+			length += int64(len(str.Value))
+		}
+		if i > 0 {
+			length += int64(len(sep))
 		}
 	}
-	return nil
+	return length
+}
+
+// freezeが作る複製の大きさを求めるヘルパー関数
+// 凍結されていない入れ子の配列はすべて複製されるので、その一つひとつを計上する
+// 同じ配列が何度現れてもその度に複製されるため、求めた大きさはsizesに控えて使い回す
+// 自分自身を含む配列は凍結できないのでokをfalseにして返す
+func freezeSize(arr *Array, sizes map[*Array]int64) (size int64, ok bool) {
+	if arr.Frozen {
+		return 0, true
+	}
+	if size, ok := sizes[arr]; ok {
+		return size, size >= 0 // -1は複製の途中で、同じ配列に戻ってきたということ
+	}
+	sizes[arr] = -1
+	size = SizeOf(arr)
+	for _, element := range arr.Elements {
+		nested, isArray := element.(*Array)
+		if !isArray {
+			continue
+		}
+		n, ok := freezeSize(nested, sizes)
+		if !ok {
+			return 0, false
+		}
+		if n > math.MaxInt64-size {
+			size = math.MaxInt64
+		} else {
+			size += n
+		}
+	}
+	sizes[arr] = size
+	return size, true
+}
+
+// i番目の引数の文字列を返すヘルパー関数
+// 引数の型はSignatureで確かめてあるものとする
+func stringArg(args []Object, i int) string {
+	return args[i].(*String).Value
+}
+
+// 文字列のスライスを文字列の配列にするヘルパー関数
+func stringArray(strs []string) *Array {
+	elements := make([]Object, len(strs))
+	for i, s := range strs {
+		elements[i] = &String{Value: s}
+	}
+	return &Array{Elements: elements}
+}
+
+// Goの真偽値をTRUEかFALSEにするヘルパー関数
+func nativeBoolToBoolean(b bool) *Boolean {
+	if b {
+		return TRUE
+	}
+	return FALSE
+}
+
+// sort_byのキーを比較するヘルパー関数
+// 数値同士か文字列同士でなければ比較できない
+func compareSortKeys(left, right Object) (int, *Error) {
+	switch {
+	case IsInteger(left) && IsInteger(right):
+		return CompareIntegers(left, right), nil
+	case IsNumber(left) && IsNumber(right):
+		l, r := ToFloat(left), ToFloat(right)
+		switch {
+		case l < r:
+			return -1, nil
+		case l > r:
+			return 1, nil
+		}
+		return 0, nil
+	case left.Type() == STRING_OBJ && right.Type() == STRING_OBJ:
+		return strings.Compare(left.(*String).Value, right.(*String).Value), nil
+	}
+	return 0, newError("sort_by keys must be all numbers or all strings, got %s and %s", left.Type(), right.Type())
+}
+
+// エラーオブジェクトかを返すヘルパー関数
+func isError(obj Object) bool {
+	return obj != nil && obj.Type() == ERROR_OBJ
+}
+
+// 条件として真とみなされるかを返すヘルパー関数
+// falseとNull以外はすべて真
+func isTruthy(obj Object) bool {
+	switch obj := obj.(type) {
+	case *Boolean:
+		return obj.Value
+	case *Null:
+		return false
+	default:
+		return true
+	}
+}
+
+func newError(format string, a ...interface{}) *Error {
+	return &Error{Message: fmt.Sprintf(format, a...)}
 }
@@ -9,13 +9,26 @@ type Environment struct {
 
 	// 拡張環境
 	outer *Environment
+
+	// 評価に課す制限（nilなら制限なし）
+	// 拡張環境は外側の環境と同じものを共有する
+	budget *Budget
+
+	// 識別子が環境に見つからないときに探す組み込み関数
+	// 拡張環境は外側の環境と同じものを共有する
+	builtins *Registry
 }
 
-// 新しい環境を生成する
+// 標準の組み込み関数を使う新しい環境を生成する
 // 常に一つの環境を使いまわしたいのでポインタで渡す
 func NewEnvironment() *Environment {
+	return NewEnvironmentWithBuiltins(NewStandardRegistry())
+}
+
+// 組み込み関数としてrに登録されたものを使う新しい環境を生成する
+func NewEnvironmentWithBuiltins(r *Registry) *Environment {
 	s := make(map[string]Object)
-	return &Environment{store: s}
+	return &Environment{store: s, builtins: r}
 }
 
 // 環境内にnameという名前で登録されているObjectを持ってくる
@@ -37,11 +50,39 @@ func (e *Environment) Set(name string, val Object) Object {
 	return val
 }
 
+// 既に登録されているnameのObjectを書き換える
+// 内側の環境から順に探し、見つかった環境の値を更新する
+// どの環境にも登録されていなければfalseを返す
+func (e *Environment) Assign(name string, val Object) bool {
+	if _, ok := e.store[name]; ok {
+		e.store[name] = val
+		return true
+	}
+	if e.outer != nil {
+		return e.outer.Assign(name, val)
+	}
+	return false
+}
+
 // 拡張環境をセットする
 func NewEnclosedEnvironment(outer *Environment) *Environment {
-	env := NewEnvironment()
-	env.outer = outer
-	return env
+	s := make(map[string]Object)
+	return &Environment{store: s, outer: outer, budget: outer.budget, builtins: outer.builtins}
+}
+
+// この環境とここから拡張される環境での評価に制限を課す
+func (e *Environment) SetBudget(b *Budget) {
+	e.budget = b
+}
+
+// 評価に課されている制限を返す
+func (e *Environment) Budget() *Budget {
+	return e.budget
+}
+
+// この環境で使える組み込み関数を返す
+func (e *Environment) Builtins() *Registry {
+	return e.builtins
 }
 
 // -----------------------------------------------------
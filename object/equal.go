@@ -0,0 +1,99 @@
+package object
+
+// -----------------------------------------------------
+// 等価性
+// ==と!=の意味を評価器とVMで揃えるために、ここで一度だけ定義する
+
+// leftとrightが等しいかを返す
+// 数値は値で比べ、整数と浮動小数点数も数として等しければ等しい
+// 文字列・配列・タプル・ハッシュは中身を再帰的に比べる
+// 凍結された配列と凍結されていない配列も、要素が等しければ等しい
+// ハッシュはペアの順序によらず、同じキーに等しい値があれば等しい
+// 関数などそれ以外のObjectは同じオブジェクトであるときだけ等しい
+func Equal(left, right Object) bool {
+	return equal(left, right, nil)
+}
+
+// 比較中の配列・ハッシュの組
+type equalPair struct {
+	left, right Object
+}
+
+// Equalの本体
+// 自分自身を含む配列やハッシュで無限に再帰しないよう、比較中の組をvisitingに控え、
+// 同じ組に再び出会ったらその組は等しいものとして扱う
+func equal(left, right Object, visiting map[equalPair]bool) bool {
+	if left == right {
+		return true
+	}
+	if IsInteger(left) && IsInteger(right) {
+		return CompareIntegers(left, right) == 0
+	}
+	if IsNumber(left) && IsNumber(right) {
+		return ToFloat(left) == ToFloat(right)
+	}
+
+	switch l := left.(type) {
+	case *String:
+		r, ok := right.(*String)
+		return ok && l.Value == r.Value
+	case *Boolean:
+		r, ok := right.(*Boolean)
+		return ok && l.Value == r.Value
+	case *Null:
+		_, ok := right.(*Null)
+		return ok
+	case *Array:
+		r, ok := right.(*Array)
+		return ok && equalElements(left, right, l.Elements, r.Elements, visiting)
+	case *Tuple:
+		r, ok := right.(*Tuple)
+		return ok && equalElements(left, right, l.Elements, r.Elements, visiting)
+	case *Hash:
+		r, ok := right.(*Hash)
+		if !ok || l.Len() != r.Len() {
+			return false
+		}
+		pair := equalPair{left, right}
+		if visiting[pair] {
+			return true
+		}
+		if visiting == nil {
+			visiting = make(map[equalPair]bool)
+		}
+		visiting[pair] = true
+		defer delete(visiting, pair)
+		for _, lp := range l.Pairs() {
+			rp, ok := r.Get(lp.Key.(Hashable))
+			if !ok || !equal(lp.Value, rp.Value, visiting) {
+				return false
+			}
+		}
+		return true
+	}
+	return false
+}
+
+// 配列・タプルの要素を順に比べるヘルパー関数
+func equalElements(left, right Object, l, r []Object, visiting map[equalPair]bool) bool {
+	if len(l) != len(r) {
+		return false
+	}
+	pair := equalPair{left, right}
+	if visiting[pair] {
+		return true
+	}
+	if visiting == nil {
+		visiting = make(map[equalPair]bool)
+	}
+	visiting[pair] = true
+	defer delete(visiting, pair)
+	for i, element := range l {
+		if !equal(element, r[i], visiting) {
+			return false
+		}
+	}
+	return true
+}
+
+// -----------------------------------------------------
@@ -0,0 +1,338 @@
+package object
+
+import (
+	"fmt"
+	"math"
+	"reflect"
+	"sort"
+)
+
+// -----------------------------------------------------
+// Goの値との変換
+// Monkeyを組み込むGoのプログラムが、Goの値と関数をそのまま受け渡せるようにする
+
+var errorType = reflect.TypeOf((*error)(nil)).Elem()
+
+// Goの値をObjectに変換する
+// nil、真偽値、整数、浮動小数点数、文字列、スライス・配列、マップ、関数を変換できる
+// Objectはそのまま返す
+func FromGo(v interface{}) (Object, error) {
+	if v == nil {
+		return NULL, nil
+	}
+	if obj, ok := v.(Object); ok {
+		return obj, nil
+	}
+	return fromValue(reflect.ValueOf(v))
+}
+
+func fromValue(v reflect.Value) (Object, error) {
+	switch v.Kind() {
+	case reflect.Interface, reflect.Ptr:
+		if v.IsNil() {
+			return NULL, nil
+		}
+		if obj, ok := v.Interface().(Object); ok {
+			return obj, nil
+		}
+		if v.Kind() == reflect.Ptr {
+			return nil, fmt.Errorf("cannot convert %s to a Monkey value", v.Type())
+		}
+		return fromValue(v.Elem())
+	case reflect.Bool:
+		if v.Bool() {
+			return TRUE, nil
+		}
+		return FALSE, nil
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return &Integer{Value: v.Int()}, nil
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		if v.Uint() > math.MaxInt64 {
+			return nil, fmt.Errorf("integer overflow: %d", v.Uint())
+		}
+		return &Integer{Value: int64(v.Uint())}, nil
+	case reflect.Float32, reflect.Float64:
+		return &Float{Value: v.Float()}, nil
+	case reflect.String:
+		return &String{Value: v.String()}, nil
+	case reflect.Slice, reflect.Array:
+		if v.Kind() == reflect.Slice && v.IsNil() {
+			return NULL, nil
+		}
+		elements := make([]Object, v.Len())
+		for i := range elements {
+			element, err := fromValue(v.Index(i))
+			if err != nil {
+				return nil, err
+			}
+			elements[i] = element
+		}
+		return &Array{Elements: elements}, nil
+	case reflect.Map:
+		if v.IsNil() {
+			return NULL, nil
+		}
+		// Goのmapには順序がないので、結果が毎回同じになるようにキーの表示順に並べる
+		pairs := make([]HashPair, 0, v.Len())
+		iter := v.MapRange()
+		for iter.Next() {
+			key, err := fromValue(iter.Key())
+			if err != nil {
+				return nil, err
+			}
+			if _, ok := AsHashable(key); !ok {
+				return nil, fmt.Errorf("unusable as hash key: %s", key.Type())
+			}
+			value, err := fromValue(iter.Value())
+			if err != nil {
+				return nil, err
+			}
+			pairs = append(pairs, HashPair{Key: key, Value: value})
+		}
+		sort.Slice(pairs, func(i, j int) bool { return pairs[i].Key.Inspect() < pairs[j].Key.Inspect() })
+		hash := &Hash{}
+		for _, pair := range pairs {
+			hash.Set(pair.Key.(Hashable), pair.Value)
+		}
+		return hash, nil
+	case reflect.Func:
+		if v.IsNil() {
+			return NULL, nil
+		}
+		return NewHostFunction(v.Interface())
+	}
+	return nil, fmt.Errorf("cannot convert %s to a Monkey value", v.Type())
+}
+
+// ObjectをGoの型tの値に変換する
+// tがinterface{}なら、Objectの種類に応じたGoの値（int64、float64、string、bool、nil、
+// []interface{}、map[interface{}]interface{}）にする
+// 関数などGoの値にならないObjectは、tがObjectを受け取れる型ならそのまま渡す
+func ToGo(obj Object, t reflect.Type) (reflect.Value, error) {
+	// ObjectやHashable、*ClosureなどObjectを受け取れる型にはそのまま渡す
+	if isEmptyInterface := t.Kind() == reflect.Interface && t.NumMethod() == 0; !isEmptyInterface && reflect.TypeOf(obj).AssignableTo(t) {
+		return reflect.ValueOf(obj), nil
+	}
+
+	switch t.Kind() {
+	case reflect.Interface:
+		if t.NumMethod() != 0 {
+			break
+		}
+		v, err := toInterface(obj)
+		if err != nil {
+			return reflect.Value{}, err
+		}
+		if v == nil {
+			return reflect.Zero(t), nil
+		}
+		return reflect.ValueOf(v), nil
+	case reflect.Bool:
+		if b, ok := obj.(*Boolean); ok {
+			return reflect.ValueOf(b.Value).Convert(t), nil
+		}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		if i, ok := obj.(*Integer); ok {
+			v := reflect.New(t).Elem()
+			if v.OverflowInt(i.Value) {
+				return reflect.Value{}, fmt.Errorf("integer overflow: %d does not fit in %s", i.Value, t)
+			}
+			v.SetInt(i.Value)
+			return v, nil
+		}
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		if i, ok := obj.(*Integer); ok {
+			v := reflect.New(t).Elem()
+			if i.Value < 0 || v.OverflowUint(uint64(i.Value)) {
+				return reflect.Value{}, fmt.Errorf("integer overflow: %d does not fit in %s", i.Value, t)
+			}
+			v.SetUint(uint64(i.Value))
+			return v, nil
+		}
+	case reflect.Float32, reflect.Float64:
+		switch n := obj.(type) {
+		case *Float:
+			return reflect.ValueOf(n.Value).Convert(t), nil
+		case *Integer:
+			return reflect.ValueOf(float64(n.Value)).Convert(t), nil
+		}
+	case reflect.String:
+		if s, ok := obj.(*String); ok {
+			return reflect.ValueOf(s.Value).Convert(t), nil
+		}
+	case reflect.Slice:
+		switch obj := obj.(type) {
+		case *Null:
+			return reflect.Zero(t), nil
+		case *Array:
+			return elementsToSlice(obj.Elements, t)
+		case *Tuple:
+			return elementsToSlice(obj.Elements, t)
+		}
+	case reflect.Map:
+		if _, ok := obj.(*Null); ok {
+			return reflect.Zero(t), nil
+		}
+		if hash, ok := obj.(*Hash); ok {
+			v := reflect.MakeMapWithSize(t, hash.Len())
+			for _, pair := range hash.Pairs() {
+				key, err := ToGo(pair.Key, t.Key())
+				if err != nil {
+					return reflect.Value{}, err
+				}
+				if !key.Type().Comparable() {
+					return reflect.Value{}, fmt.Errorf("cannot use %s as a key of %s", pair.Key.Type(), t)
+				}
+				value, err := ToGo(pair.Value, t.Elem())
+				if err != nil {
+					return reflect.Value{}, err
+				}
+				v.SetMapIndex(key, value)
+			}
+			return v, nil
+		}
+	}
+	return reflect.Value{}, fmt.Errorf("cannot convert %s to %s", obj.Type(), t)
+}
+
+// Objectを型の指定がないときのGoの値に変換するヘルパー関数
+func toInterface(obj Object) (interface{}, error) {
+	switch obj := obj.(type) {
+	case *Null:
+		return nil, nil
+	case *Boolean:
+		return obj.Value, nil
+	case *Integer:
+		return obj.Value, nil
+	case *Float:
+		return obj.Value, nil
+	case *String:
+		return obj.Value, nil
+	case *Array:
+		return elementsToInterface(obj.Elements)
+	case *Tuple:
+		return elementsToInterface(obj.Elements)
+	case *Hash:
+		m := make(map[interface{}]interface{}, obj.Len())
+		for _, pair := range obj.Pairs() {
+			key, err := toInterface(pair.Key)
+			if err != nil {
+				return nil, err
+			}
+			// タプルや凍結された配列のキーはスライスになり、Goのmapのキーにできない
+			if key != nil && !reflect.TypeOf(key).Comparable() {
+				return nil, fmt.Errorf("cannot use %s as a Go map key", pair.Key.Type())
+			}
+			value, err := toInterface(pair.Value)
+			if err != nil {
+				return nil, err
+			}
+			m[key] = value
+		}
+		return m, nil
+	}
+	return obj, nil
+}
+
+// 配列・タプルの要素をスライスの型tの値に変換するヘルパー関数
+func elementsToSlice(elements []Object, t reflect.Type) (reflect.Value, error) {
+	v := reflect.MakeSlice(t, len(elements), len(elements))
+	for i, element := range elements {
+		e, err := ToGo(element, t.Elem())
+		if err != nil {
+			return reflect.Value{}, err
+		}
+		v.Index(i).Set(e)
+	}
+	return v, nil
+}
+
+// 配列・タプルの要素を[]interface{}に変換するヘルパー関数
+func elementsToInterface(elements []Object) ([]interface{}, error) {
+	values := make([]interface{}, len(elements))
+	for i, element := range elements {
+		v, err := toInterface(element)
+		if err != nil {
+			return nil, err
+		}
+		values[i] = v
+	}
+	return values, nil
+}
+
+// -----------------------------------------------------
+
+// -----------------------------------------------------
+// ホスト関数
+// Goの関数を組み込み関数としてMonkeyから呼べるようにする
+
+// Goの関数fnを組み込み関数にする
+// 引数はToGoでfnの引数の型に、戻り値はFromGoでObjectに変換する
+// fnの戻り値は、なし・値一つ・errorのみ・値とerrorのいずれか
+// fnがnilでないerrorを返したときや、引数を変換できなかったときはErrorを返す
+func NewHostFunction(fn interface{}) (*Builtin, error) {
+	v := reflect.ValueOf(fn)
+	if v.Kind() != reflect.Func || v.IsNil() {
+		return nil, fmt.Errorf("host function must be a func, got %T", fn)
+	}
+	t := v.Type()
+	returnsError := t.NumOut() > 0 && t.Out(t.NumOut()-1) == errorType
+	if t.NumOut() > 2 || (t.NumOut() == 2 && !returnsError) {
+		return nil, fmt.Errorf("host function must return (), (T), (error) or (T, error), got %s", t)
+	}
+
+	return &Builtin{
+		Fn: func(call Invoker, args ...Object) Object {
+			in, err := hostArguments(t, args)
+			if err != nil {
+				return newError("%s", err)
+			}
+			out := v.Call(in)
+			if returnsError {
+				if err, _ := out[len(out)-1].Interface().(error); err != nil {
+					return newError("%s", err)
+				}
+				out = out[:len(out)-1]
+			}
+			if len(out) == 0 {
+				return nil
+			}
+			result, err := fromValue(out[0])
+			if err != nil {
+				return newError("%s", err)
+			}
+			return result
+		},
+		Allocates: true,
+	}, nil
+}
+
+// ホスト関数に渡す引数をGoの値に変換するヘルパー関数
+func hostArguments(t reflect.Type, args []Object) ([]reflect.Value, error) {
+	numParams := t.NumIn()
+	if t.IsVariadic() {
+		if len(args) < numParams-1 {
+			return nil, fmt.Errorf("wrong number of arguments. got=%d, want>=%d", len(args), numParams-1)
+		}
+	} else if len(args) != numParams {
+		return nil, fmt.Errorf("wrong number of arguments. got=%d, want=%d", len(args), numParams)
+	}
+
+	in := make([]reflect.Value, len(args))
+	for i, arg := range args {
+		var paramType reflect.Type
+		if t.IsVariadic() && i >= numParams-1 {
+			paramType = t.In(numParams - 1).Elem()
+		} else {
+			paramType = t.In(i)
+		}
+		v, err := ToGo(arg, paramType)
+		if err != nil {
+			return nil, fmt.Errorf("argument %d: %s", i+1, err)
+		}
+		in[i] = v
+	}
+	return in, nil
+}
+
+// -----------------------------------------------------
@@ -0,0 +1,126 @@
+package object
+
+import (
+	"fmt"
+	"unicode/utf8"
+)
+
+// -----------------------------------------------------
+// Rangeの定義
+// range(start, end, step)が返す遅延評価の整数列
+// 要素を配列として確保せずにfor-inで順に取り出せる
+type Range struct {
+	Start int64
+	End   int64 // 終端（含まない）
+	Step  int64 // 0以外
+}
+
+func (r *Range) Type() ObjectType { return RANGE_OBJ }
+func (r *Range) Inspect() string {
+	if r.Step == 1 {
+		return fmt.Sprintf("range(%d, %d)", r.Start, r.End)
+	}
+	return fmt.Sprintf("range(%d, %d, %d)", r.Start, r.End, r.Step)
+}
+
+// -----------------------------------------------------
+
+// -----------------------------------------------------
+// Iteratorの定義
+// for-inでコレクションを順に走査するためのもの
+// 各要素はキー（配列・文字列・Rangeでは添字）と値の組として取り出す
+type Iterator struct {
+	next  func() (key, value Object, ok bool)
+	keyed bool // ループ変数が一つのときにキーを束縛するか（ハッシュ）
+}
+
+func (it *Iterator) Type() ObjectType { return ITERATOR_OBJ }
+func (it *Iterator) Inspect() string  { return "iterator" }
+
+// 次の要素のキーと値を返す
+// 走査し終わっていればokはfalse
+func (it *Iterator) Next() (key, value Object, ok bool) {
+	return it.next()
+}
+
+// ループ変数が一つのときに束縛する次の要素を返す
+// ハッシュではキー、それ以外では値になる
+func (it *Iterator) NextElement() (Object, bool) {
+	key, value, ok := it.next()
+	if it.keyed {
+		return key, ok
+	}
+	return value, ok
+}
+
+// objを走査するIteratorを生成する
+// 走査できない型の場合はfalseを返す
+func NewIterator(obj Object) (*Iterator, bool) {
+	switch obj := obj.(type) {
+	case *Array:
+		return elementsIterator(obj.Elements), true
+	case *Tuple:
+		return elementsIterator(obj.Elements), true
+	case *String:
+		// 文字列はルーン単位で走査する
+		offset, index := 0, 0
+		return &Iterator{next: func() (Object, Object, bool) {
+			if offset >= len(obj.Value) {
+				return nil, nil, false
+			}
+			r, size := utf8.DecodeRuneInString(obj.Value[offset:])
+			key := &Integer{Value: int64(index)}
+			value := &String{Value: string(r)}
+			offset += size
+			index++
+			return key, value, true
+		}}, true
+	case *Hash:
+		// 走査中にハッシュが書き換えられても影響しないように作成時点のペアを控えておく
+		pairs := make([]HashPair, obj.Len())
+		copy(pairs, obj.Pairs())
+		i := 0
+		return &Iterator{keyed: true, next: func() (Object, Object, bool) {
+			if i >= len(pairs) {
+				return nil, nil, false
+			}
+			pair := pairs[i]
+			i++
+			return pair.Key, pair.Value, true
+		}}, true
+	case *Range:
+		current, index, done := obj.Start, 0, false
+		return &Iterator{next: func() (Object, Object, bool) {
+			if done || (obj.Step > 0 && current >= obj.End) || (obj.Step < 0 && current <= obj.End) {
+				return nil, nil, false
+			}
+			key := &Integer{Value: int64(index)}
+			value := &Integer{Value: current}
+			// int64の範囲を超えるならそこで終わり
+			next := current + obj.Step
+			done = (obj.Step > 0) != (next > current)
+			current = next
+			index++
+			return key, value, true
+		}}, true
+	case *Iterator:
+		return obj, true
+	}
+	return nil, false
+}
+
+// 配列・タプルの要素を添字と組にして順に返すIteratorを生成するヘルパー関数
+func elementsIterator(elements []Object) *Iterator {
+	i := 0
+	return &Iterator{next: func() (Object, Object, bool) {
+		if i >= len(elements) {
+			return nil, nil, false
+		}
+		key := &Integer{Value: int64(i)}
+		value := elements[i]
+		i++
+		return key, value, true
+	}}
+}
+
+// -----------------------------------------------------
@@ -0,0 +1,277 @@
+package object
+
+import (
+	"errors"
+	"hash/fnv"
+	"math"
+	"math/big"
+)
+
+// -----------------------------------------------------
+// BigIntの定義
+// int64に収まらない整数を表す
+// 常にNewBigIntを通して生成し、int64に収まる値はIntegerとして扱う
+type BigInt struct {
+	Value *big.Int
+}
+
+func (bi *BigInt) Type() ObjectType { return BIGINT_OBJ }
+func (bi *BigInt) Inspect() string  { return bi.Value.String() }
+func (bi *BigInt) HashKey() HashKey {
+	h := fnv.New64a()
+	h.Write([]byte(bi.Value.String()))
+	return HashKey{Type: bi.Type(), Value: h.Sum64()}
+}
+
+// big.Intから整数オブジェクトを生成する
+// int64に収まる場合はIntegerに戻す
+func NewBigInt(v *big.Int) Object {
+	if v.IsInt64() {
+		return &Integer{Value: v.Int64()}
+	}
+	return &BigInt{Value: v}
+}
+
+// -----------------------------------------------------
+
+// -----------------------------------------------------
+// 数値演算のヘルパー関数
+// 評価器とVMの両方から使う
+
+// 0除算のエラー
+// 評価器とVMで同じメッセージを返すために共有する
+var ErrDivisionByZero = errors.New("division by zero")
+
+// シフト演算のエラー
+var (
+	ErrNegativeShift = errors.New("negative shift count")
+	ErrShiftTooLarge = errors.New("shift count too large")
+)
+
+// シフト量の上限
+// これを超える左シフトは現実的なメモリに収まらない
+const maxShift = 1 << 20
+
+// IntegerかBigIntかを判定する
+func IsInteger(obj Object) bool {
+	t := obj.Type()
+	return t == INTEGER_OBJ || t == BIGINT_OBJ
+}
+
+// 数値（Integer、BigInt、Float）かを判定する
+func IsNumber(obj Object) bool {
+	return IsInteger(obj) || obj.Type() == FLOAT_OBJ
+}
+
+// 整数オブジェクトをbig.Intに変換する
+func ToBigInt(obj Object) *big.Int {
+	switch obj := obj.(type) {
+	case *Integer:
+		return big.NewInt(obj.Value)
+	case *BigInt:
+		return obj.Value
+	}
+	return nil
+}
+
+// 数値オブジェクトをfloat64に変換する
+func ToFloat(obj Object) float64 {
+	switch obj := obj.(type) {
+	case *Integer:
+		return float64(obj.Value)
+	case *BigInt:
+		f, _ := new(big.Float).SetInt(obj.Value).Float64()
+		return f
+	case *Float:
+		return obj.Value
+	}
+	return 0
+}
+
+// 整数同士の加算
+// int64の範囲を超える場合はBigIntに昇格する
+func AddIntegers(left, right Object) Object {
+	if l, r, ok := int64Operands(left, right); ok {
+		sum := l + r
+		// 同符号同士の加算で符号が変わったらオーバーフロー
+		if (l >= 0) == (r >= 0) && (sum >= 0) != (l >= 0) {
+			return NewBigInt(new(big.Int).Add(big.NewInt(l), big.NewInt(r)))
+		}
+		return &Integer{Value: sum}
+	}
+	return NewBigInt(new(big.Int).Add(ToBigInt(left), ToBigInt(right)))
+}
+
+// 整数同士の減算
+func SubIntegers(left, right Object) Object {
+	if l, r, ok := int64Operands(left, right); ok {
+		diff := l - r
+		// 異符号同士の減算で左辺と符号が変わったらオーバーフロー
+		if (l >= 0) != (r >= 0) && (diff >= 0) != (l >= 0) {
+			return NewBigInt(new(big.Int).Sub(big.NewInt(l), big.NewInt(r)))
+		}
+		return &Integer{Value: diff}
+	}
+	return NewBigInt(new(big.Int).Sub(ToBigInt(left), ToBigInt(right)))
+}
+
+// 整数同士の乗算
+func MulIntegers(left, right Object) Object {
+	if l, r, ok := int64Operands(left, right); ok {
+		if l == 0 || r == 0 {
+			return &Integer{Value: 0}
+		}
+		product := l * r
+		// 積を片方で割って元に戻らなければオーバーフロー
+		// MinInt64 * -1は割り戻しでは検出できないので個別に判定する
+		if product/r == l && !(l == math.MinInt64 && r == -1) {
+			return &Integer{Value: product}
+		}
+	}
+	return NewBigInt(new(big.Int).Mul(ToBigInt(left), ToBigInt(right)))
+}
+
+// 整数同士の除算
+// Goと同じく0方向に切り捨てる
+// 右辺が0の場合はErrDivisionByZeroを返す
+func DivIntegers(left, right Object) (Object, error) {
+	if l, r, ok := int64Operands(left, right); ok {
+		if r == 0 {
+			return nil, ErrDivisionByZero
+		}
+		// MinInt64 / -1だけはint64に収まらないのでBigIntに昇格する
+		if !(l == math.MinInt64 && r == -1) {
+			return &Integer{Value: l / r}, nil
+		}
+	}
+	divisor := ToBigInt(right)
+	if divisor.Sign() == 0 {
+		return nil, ErrDivisionByZero
+	}
+	return NewBigInt(new(big.Int).Quo(ToBigInt(left), divisor)), nil
+}
+
+// 整数同士の剰余
+// 符号は左辺に従う（Goの%と同じ）
+func ModIntegers(left, right Object) (Object, error) {
+	if l, r, ok := int64Operands(left, right); ok {
+		if r == 0 {
+			return nil, ErrDivisionByZero
+		}
+		// 何を-1で割っても余りは0（MinInt64 % -1も含む）
+		if r == -1 {
+			return &Integer{Value: 0}, nil
+		}
+		return &Integer{Value: l % r}, nil
+	}
+	divisor := ToBigInt(right)
+	if divisor.Sign() == 0 {
+		return nil, ErrDivisionByZero
+	}
+	return NewBigInt(new(big.Int).Rem(ToBigInt(left), divisor)), nil
+}
+
+// 整数同士のビット積
+func AndIntegers(left, right Object) Object {
+	if l, r, ok := int64Operands(left, right); ok {
+		return &Integer{Value: l & r}
+	}
+	return NewBigInt(new(big.Int).And(ToBigInt(left), ToBigInt(right)))
+}
+
+// 整数同士のビット和
+func OrIntegers(left, right Object) Object {
+	if l, r, ok := int64Operands(left, right); ok {
+		return &Integer{Value: l | r}
+	}
+	return NewBigInt(new(big.Int).Or(ToBigInt(left), ToBigInt(right)))
+}
+
+// 整数同士の排他的論理和
+func XorIntegers(left, right Object) Object {
+	if l, r, ok := int64Operands(left, right); ok {
+		return &Integer{Value: l ^ r}
+	}
+	return NewBigInt(new(big.Int).Xor(ToBigInt(left), ToBigInt(right)))
+}
+
+// 左シフト
+// int64からあふれる場合はBigIntに昇格する
+func ShiftLeft(left, right Object) (Object, error) {
+	n, err := shiftCount(right)
+	if err != nil {
+		return nil, err
+	}
+	if l, ok := left.(*Integer); ok && n < 63 {
+		shifted := l.Value << n
+		// 戻して元の値にならなければあふれている
+		if shifted>>n == l.Value {
+			return &Integer{Value: shifted}, nil
+		}
+	}
+	if n > maxShift {
+		return nil, ErrShiftTooLarge
+	}
+	return NewBigInt(new(big.Int).Lsh(ToBigInt(left), n)), nil
+}
+
+// 右シフト（算術シフト）
+func ShiftRight(left, right Object) (Object, error) {
+	n, err := shiftCount(right)
+	if err != nil {
+		return nil, err
+	}
+	if l, ok := left.(*Integer); ok {
+		return &Integer{Value: l.Value >> n}, nil
+	}
+	return NewBigInt(new(big.Int).Rsh(ToBigInt(left), n)), nil
+}
+
+// シフト量を取り出す
+func shiftCount(obj Object) (uint, error) {
+	n := ToBigInt(obj)
+	if n.Sign() < 0 {
+		return 0, ErrNegativeShift
+	}
+	if !n.IsUint64() || n.Uint64() > math.MaxInt32 {
+		return 0, ErrShiftTooLarge
+	}
+	return uint(n.Uint64()), nil
+}
+
+// 整数の符号反転
+func NegateInteger(obj Object) Object {
+	if i, ok := obj.(*Integer); ok && i.Value != math.MinInt64 {
+		return &Integer{Value: -i.Value}
+	}
+	return NewBigInt(new(big.Int).Neg(ToBigInt(obj)))
+}
+
+// 整数同士を比較して、left < rightなら-1、等しければ0、left > rightなら+1を返す
+func CompareIntegers(left, right Object) int {
+	if l, r, ok := int64Operands(left, right); ok {
+		switch {
+		case l < r:
+			return -1
+		case l > r:
+			return 1
+		}
+		return 0
+	}
+	return ToBigInt(left).Cmp(ToBigInt(right))
+}
+
+// 両方のオペランドがIntegerならその値を返す
+func int64Operands(left, right Object) (int64, int64, bool) {
+	l, ok := left.(*Integer)
+	if !ok {
+		return 0, 0, false
+	}
+	r, ok := right.(*Integer)
+	if !ok {
+		return 0, 0, false
+	}
+	return l.Value, r.Value, true
+}
+
+// -----------------------------------------------------
@@ -2,10 +2,12 @@ package object
 
 import (
 	"bytes"
+	"encoding/binary"
 	"fmt"
 	"hash/fnv"
 	"monkey/ast"
 	"monkey/code"
+	"strconv"
 	"strings"
 )
 
@@ -21,17 +23,29 @@ type Object interface {
 
 const (
 	INTEGER_OBJ              = "INTEGER"
+	BIGINT_OBJ               = "BIGINT"
+	FLOAT_OBJ                = "FLOAT"
 	BOOLEAN_OBJ              = "BOOLEAN"
 	NULL_OBJ                 = "NULL"
 	RETURN_VALUE_OBJ         = "RETURN_VAL"
 	ERROR_OBJ                = "ERROR"
+	BREAK_OBJ                = "BREAK"
+	CONTINUE_OBJ             = "CONTINUE"
 	FUNCTION_OBJ             = "FUNCTION"
 	STRING_OBJ               = "STRING"
 	BUILTIN_OBJ              = "BUILTIN"
 	ARRAY_OBJ                = "ARRAY"
+	TUPLE_OBJ                = "TUPLE"
 	HASH_OBJ                 = "HASH"
 	COMPILED_FUNCTION_OBJECT = "COMPILED_FUNCTION_OBJECT"
 	CLOSURE_OBJ              = "CLOSURE"
+	RANGE_OBJ                = "RANGE"
+	ITERATOR_OBJ             = "ITERATOR"
+	UPVALUE_OBJ              = "UPVALUE"
+	TAIL_CALL_OBJ            = "TAIL_CALL"
+
+	// 組み込み関数のSignatureで、任意の型の引数を表す
+	ANY_OBJ = "ANY"
 )
 
 // ハッシュテーブルにおける管理用オブジェクトとしてのHashKey
@@ -41,10 +55,54 @@ type HashKey struct {
 }
 
 // Monkeyのハッシュテーブルに格納できるものはHashableインタフェースを満たさなくてはならない
+// HashKeyが等しくてもキーが等しいとは限らないので、ハッシュはEqualでキーを比べる
 type Hashable interface {
+	Object
 	HashKey() HashKey
 }
 
+// objをハッシュのキーとして使えるならHashableとして返す
+// 配列は凍結されたものだけ、配列とタプルは要素がすべてキーとして使えるものだけを認める
+func AsHashable(obj Object) (Hashable, bool) {
+	switch obj := obj.(type) {
+	case *Array:
+		if !obj.Frozen || !allHashable(obj.Elements) {
+			return nil, false
+		}
+	case *Tuple:
+		if !allHashable(obj.Elements) {
+			return nil, false
+		}
+	}
+	key, ok := obj.(Hashable)
+	return key, ok
+}
+
+func allHashable(elements []Object) bool {
+	for _, element := range elements {
+		if _, ok := AsHashable(element); !ok {
+			return false
+		}
+	}
+	return true
+}
+
+// 要素のHashKeyを繋げてtypeのHashKeyを作るヘルパー関数
+func elementsHashKey(typ ObjectType, elements []Object) HashKey {
+	h := fnv.New64a()
+	var buf [8]byte
+	for _, element := range elements {
+		var key HashKey
+		if hashable, ok := element.(Hashable); ok {
+			key = hashable.HashKey()
+		}
+		h.Write([]byte(key.Type))
+		binary.LittleEndian.PutUint64(buf[:], key.Value)
+		h.Write(buf[:])
+	}
+	return HashKey{Type: typ, Value: h.Sum64()}
+}
+
 // -----------------------------------------------------
 
 // -----------------------------------------------------
@@ -61,6 +119,24 @@ func (i *Integer) HashKey() HashKey {
 
 // -----------------------------------------------------
 
+// -----------------------------------------------------
+// Floatの定義
+type Float struct {
+	Value float64
+}
+
+func (f *Float) Type() ObjectType { return FLOAT_OBJ }
+func (f *Float) Inspect() string {
+	s := strconv.FormatFloat(f.Value, 'g', -1, 64)
+	// 整数値の浮動小数点数もIntegerと区別できるように「.0」を付ける
+	if !strings.ContainsAny(s, ".eEnN") {
+		s += ".0"
+	}
+	return s
+}
+
+// -----------------------------------------------------
+
 // -----------------------------------------------------
 // Booleanの定義
 type Boolean struct {
@@ -88,6 +164,14 @@ type Null struct{}
 func (n *Null) Type() ObjectType { return NULL_OBJ }
 func (n *Null) Inspect() string  { return "Null" }
 
+// 真偽値とNullの唯一のインスタンス
+// 評価器とVMはポインタで比較するので、これ以外の値を作らないようにする
+var (
+	NULL  = &Null{}
+	TRUE  = &Boolean{Value: true}
+	FALSE = &Boolean{Value: false}
+)
+
 // -----------------------------------------------------
 
 // -----------------------------------------------------
@@ -112,6 +196,35 @@ func (e *Error) Inspect() string  { return "ERROR: " + e.Message }
 
 // -----------------------------------------------------
 
+// -----------------------------------------------------
+// Break、Continueの定義
+// ループを抜ける・次の繰り返しに進むことを評価器の中で伝えるためのもの
+type Break struct{}
+
+func (b *Break) Type() ObjectType { return BREAK_OBJ }
+func (b *Break) Inspect() string  { return "break" }
+
+type Continue struct{}
+
+func (c *Continue) Type() ObjectType { return CONTINUE_OBJ }
+func (c *Continue) Inspect() string  { return "continue" }
+
+// -----------------------------------------------------
+
+// -----------------------------------------------------
+// TailCallの定義
+// 末尾位置の関数呼び出しを呼び出し元の関数に返して、そこで実行してもらうためのもの
+// 評価器が関数呼び出しのたびにGoのスタックを積まないようにする
+type TailCall struct {
+	Fn   Object
+	Args []Object
+}
+
+func (tc *TailCall) Type() ObjectType { return TAIL_CALL_OBJ }
+func (tc *TailCall) Inspect() string  { return "tail call" }
+
+// -----------------------------------------------------
+
 // -----------------------------------------------------
 // Functionの定義
 type Function struct {
@@ -144,6 +257,8 @@ type CompiledFunction struct {
 	Instructions  code.Instructions // この関数をコンパイルして得られる命令列
 	NumLocals     int               // 関数内で使われるローカル変数の個数
 	NumParameters int               // 関数リテラルが実行しようとしているときに保持している引数の個数
+	Name          string            // 関数の名前（let文で束縛されていなければ空）
+	Lines         code.LineTable    // 命令のオフセットとソースの行の対応表
 }
 
 func (cf *CompiledFunction) Type() ObjectType { return COMPILED_FUNCTION_OBJECT }
@@ -171,28 +286,139 @@ func (s *String) HashKey() HashKey {
 
 // -----------------------------------------------------
 // Builtinの定義
-type BuiltinFunction func(args ...Object) Object
+// 組み込み関数の呼び出し規約
+// callは引数として渡されたMonkeyの関数を呼び出すためのもので、評価器とVMがそれぞれ用意する
+type BuiltinFunction func(call Invoker, args ...Object) Object
+
+// 組み込み関数からMonkeyの関数（Function、Closure、Builtin）を呼び出す関数
+// 戻り値がnilになることはなく、値を返さない関数ならNULLを返す
+// 呼び出しに失敗した場合はErrorを返すので、組み込み関数はそれをそのまま返す
+type Invoker func(fn Object, args ...Object) Object
 type Builtin struct {
-	Fn BuiltinFunction
+	Name string // Registryに登録された名前
+	Fn   BuiltinFunction
+	// 引数の数と型（nilなら確かめずにFnを呼ぶ）
+	Signature *Signature
+	// 戻り値として新しい配列・文字列・ハッシュを生成する組み込み関数ならtrue
+	// trueのとき、呼び出し側は戻り値の大きさをBudgetに計上する
+	Allocates bool
+	// 戻り値の大きさ（バイト）を引数から求める関数
+	// repeatのように引数次第でいくらでも大きな値を作る組み込み関数に設定する
+	// 設定されていれば、呼び出し側はFnを呼ぶ前にReserveでこの大きさを計上し、戻り値は計上しない
+	Size func(args []Object) int64
 }
 
 func (b *Builtin) Type() ObjectType { return BUILTIN_OBJ }
 func (b *Builtin) Inspect() string  { return "builtin function" }
 
+// 引数がSignatureに合っているかを確かめてからFnを呼ぶ
+// 評価器とVMは組み込み関数をこれで呼び出す
+func (b *Builtin) Call(call Invoker, args ...Object) Object {
+	if b.Signature != nil {
+		if err := b.Signature.check(b.Name, args); err != nil {
+			return err
+		}
+	}
+	return b.Fn(call, args...)
+}
+
+// Sizeで求めた戻り値の大きさを、Fnを呼ぶ前にbudgetに計上する
+// 上限を超える場合はそのエラーを返すので、呼び出し側はFnを呼ばずに打ち切る
+// Sizeがないとき、budgetがnilのとき、引数がSignatureに合わないとき（Callがエラーを返す）は何もしない
+func (b *Builtin) Reserve(budget *Budget, args []Object) error {
+	if b.Size == nil || budget == nil {
+		return nil
+	}
+	if b.Signature != nil && b.Signature.check(b.Name, args) != nil {
+		return nil
+	}
+	return budget.AllocateBytes(b.Size(args))
+}
+
+// 組み込み関数の引数の数と型
+type Signature struct {
+	MinArgs int
+	MaxArgs int          // 負なら上限なし
+	Types   []ObjectType // i番目の引数の型（ANY_OBJなら何でもよい）、引数がこれより多ければ最後の型を使う
+}
+
+// 引数の数と型が合わなければErrorを返す
+func (s *Signature) check(name string, args []Object) *Error {
+	switch {
+	case s.MinArgs == s.MaxArgs && len(args) != s.MinArgs:
+		return newError("wrong number of arguments. got=%d, want=%d", len(args), s.MinArgs)
+	case s.MaxArgs < 0 && len(args) < s.MinArgs:
+		return newError("wrong number of arguments. got=%d, want>=%d", len(args), s.MinArgs)
+	case s.MaxArgs >= 0 && (len(args) < s.MinArgs || len(args) > s.MaxArgs):
+		return newError("wrong number of arguments. got=%d, want=%d..%d", len(args), s.MinArgs, s.MaxArgs)
+	}
+	if len(s.Types) == 0 {
+		return nil
+	}
+	for i, arg := range args {
+		want := s.Types[len(s.Types)-1]
+		if i < len(s.Types) {
+			want = s.Types[i]
+		}
+		if want != ANY_OBJ && arg.Type() != want {
+			return newError("argument to `%s` must be %s, got %s", name, want, arg.Type())
+		}
+	}
+	return nil
+}
+
+// -----------------------------------------------------
+
+// -----------------------------------------------------
+// 配列・タプル・ハッシュの表示
+// 自分自身を含む値を表示しても無限に再帰しないよう、表示中の値をvisitingに控え、
+// 再び出会ったら中身の代わりに[...]などと表示する
+
+// 要素を表示するヘルパー関数
+func inspect(obj Object, visiting map[Object]bool) string {
+	switch obj := obj.(type) {
+	case *Array:
+		return obj.inspect(visiting)
+	case *Tuple:
+		return obj.inspect(visiting)
+	case *Hash:
+		return obj.inspect(visiting)
+	}
+	return obj.Inspect()
+}
+
+// objを表示中としてvisitingに加えるヘルパー関数
+func enter(visiting map[Object]bool, obj Object) map[Object]bool {
+	if visiting == nil {
+		visiting = make(map[Object]bool)
+	}
+	visiting[obj] = true
+	return visiting
+}
+
 // -----------------------------------------------------
 
 // -----------------------------------------------------
 // Arrayオブジェクトの定義
+// 凍結された配列（Frozen）は書き換えられないので、ハッシュのキーとして使える
 type Array struct {
 	Elements []Object
+	Frozen   bool
 }
 
 func (ao *Array) Type() ObjectType { return ARRAY_OBJ }
-func (ao *Array) Inspect() string {
+func (ao *Array) Inspect() string  { return ao.inspect(nil) }
+func (ao *Array) inspect(visiting map[Object]bool) string {
+	if visiting[ao] {
+		return "[...]"
+	}
+	visiting = enter(visiting, ao)
+	defer delete(visiting, ao)
+
 	var out bytes.Buffer
 	elements := []string{}
 	for _, e := range ao.Elements {
-		elements = append(elements, e.Inspect())
+		elements = append(elements, inspect(e, visiting))
 	}
 	out.WriteString("[")
 	out.WriteString(strings.Join(elements, ", "))
@@ -200,6 +426,61 @@ func (ao *Array) Inspect() string {
 	return out.String()
 }
 
+// 凍結されていない配列はAsHashableがキーとして認めない
+func (ao *Array) HashKey() HashKey { return elementsHashKey(ao.Type(), ao.Elements) }
+
+// 入れ子の配列まで凍結した複製を返す
+// 既に凍結されている配列はそのまま返す
+func (ao *Array) Freeze() *Array {
+	if ao.Frozen {
+		return ao
+	}
+	elements := make([]Object, len(ao.Elements))
+	for i, element := range ao.Elements {
+		if arr, ok := element.(*Array); ok {
+			element = arr.Freeze()
+		}
+		elements[i] = element
+	}
+	return &Array{Elements: elements, Frozen: true}
+}
+
+// -----------------------------------------------------
+
+// -----------------------------------------------------
+// Tupleオブジェクトの定義
+// 生成した後は書き換えられない要素の並び
+type Tuple struct {
+	Elements []Object
+}
+
+func (t *Tuple) Type() ObjectType { return TUPLE_OBJ }
+func (t *Tuple) Inspect() string  { return t.inspect(nil) }
+func (t *Tuple) inspect(visiting map[Object]bool) string {
+	if visiting[t] {
+		return "(...)"
+	}
+	visiting = enter(visiting, t)
+	defer delete(visiting, t)
+
+	var out bytes.Buffer
+	elements := []string{}
+	for _, e := range t.Elements {
+		elements = append(elements, inspect(e, visiting))
+	}
+	out.WriteString("(")
+	out.WriteString(strings.Join(elements, ", "))
+	// 要素が一つのときは(1,)と表示して括弧の式と区別する
+	if len(elements) == 1 {
+		out.WriteString(",")
+	}
+	out.WriteString(")")
+	return out.String()
+}
+
+// 要素がキーとして使えないタプルはAsHashableがキーとして認めない
+func (t *Tuple) HashKey() HashKey { return elementsHashKey(t.Type(), t.Elements) }
+
 // -----------------------------------------------------
 
 // -----------------------------------------------------
@@ -209,16 +490,29 @@ type HashPair struct {
 	Value Object
 }
 
+// ペアは追加した順に並べて保持する
+// 表示やfor-inでの走査、keysなどの組み込み関数はこの順になる
+// HashKeyが衝突しても別のキーとして扱えるよう、キーはEqualで比べる
+// ゼロ値は空のハッシュとして使える
 type Hash struct {
-	Pairs map[HashKey]HashPair
+	index map[HashKey][]int // HashKeyから、そのHashKeyを持つペアのpairsでの位置を引く
+	keys  []HashKey         // pairsと同じ順に並べたHashKey
+	pairs []HashPair
 }
 
 func (h *Hash) Type() ObjectType { return HASH_OBJ }
-func (h *Hash) Inspect() string {
+func (h *Hash) Inspect() string  { return h.inspect(nil) }
+func (h *Hash) inspect(visiting map[Object]bool) string {
+	if visiting[h] {
+		return "{...}"
+	}
+	visiting = enter(visiting, h)
+	defer delete(visiting, h)
+
 	var out bytes.Buffer
 	pairs := []string{}
-	for _, pair := range h.Pairs {
-		pairs = append(pairs, fmt.Sprintf("%s: %s", pair.Key.Inspect(), pair.Value.Inspect()))
+	for _, pair := range h.pairs {
+		pairs = append(pairs, fmt.Sprintf("%s: %s", inspect(pair.Key, visiting), inspect(pair.Value, visiting)))
 	}
 	out.WriteString("{")
 	out.WriteString(strings.Join(pairs, ", "))
@@ -226,13 +520,94 @@ func (h *Hash) Inspect() string {
 	return out.String()
 }
 
+// keyに対応するペアを返す
+func (h *Hash) Get(key Hashable) (HashPair, bool) {
+	i, ok := h.find(key, key.HashKey())
+	if !ok {
+		return HashPair{}, false
+	}
+	return h.pairs[i], true
+}
+
+// keyに対応する値をvalueにする
+// 既にあるキーならその位置のまま値を置き換え、新しいキーなら最後に追加する
+func (h *Hash) Set(key Hashable, value Object) {
+	hashKey := key.HashKey()
+	if i, ok := h.find(key, hashKey); ok {
+		h.pairs[i].Value = value
+		return
+	}
+	if h.index == nil {
+		h.index = make(map[HashKey][]int)
+	}
+	h.index[hashKey] = append(h.index[hashKey], len(h.pairs))
+	h.keys = append(h.keys, hashKey)
+	h.pairs = append(h.pairs, HashPair{Key: key, Value: value})
+}
+
+// keyに対応するペアを取り除き、取り除いたかを返す
+// 後ろのペアの順序は保たれる
+func (h *Hash) Delete(key Hashable) bool {
+	hashKey := key.HashKey()
+	i, ok := h.find(key, hashKey)
+	if !ok {
+		return false
+	}
+	h.index[hashKey] = removePosition(h.index[hashKey], i)
+	if len(h.index[hashKey]) == 0 {
+		delete(h.index, hashKey)
+	}
+	// 後ろのペアは位置が一つ前にずれる
+	for j := i + 1; j < len(h.pairs); j++ {
+		positions := h.index[h.keys[j]]
+		for k, position := range positions {
+			if position == j {
+				positions[k] = j - 1
+			}
+		}
+	}
+	h.keys = append(h.keys[:i], h.keys[i+1:]...)
+	h.pairs = append(h.pairs[:i], h.pairs[i+1:]...)
+	return true
+}
+
+// keyと等しいキーを持つペアのpairsでの位置を探すヘルパー関数
+func (h *Hash) find(key Hashable, hashKey HashKey) (int, bool) {
+	for _, i := range h.index[hashKey] {
+		if Equal(h.pairs[i].Key, key) {
+			return i, true
+		}
+	}
+	return 0, false
+}
+
+func removePosition(positions []int, position int) []int {
+	for k, p := range positions {
+		if p == position {
+			return append(positions[:k], positions[k+1:]...)
+		}
+	}
+	return positions
+}
+
+// ペアの数を返す
+func (h *Hash) Len() int {
+	return len(h.pairs)
+}
+
+// すべてのペアを追加した順に返す
+// 返したスライスはハッシュと共有しているので書き換えてはいけない
+func (h *Hash) Pairs() []HashPair {
+	return h.pairs
+}
+
 // -----------------------------------------------------
 
 // -----------------------------------------------------
 // Closureオブジェクトの定義
 type Closure struct {
 	Fn   *CompiledFunction
-	Free []Object // store for free variables.
+	Free []*Upvalue // store for free variables, shared with the defining function and other closures.
 }
 
 func (c *Closure) Type() ObjectType { return CLOSURE_OBJ }
@@ -241,3 +616,21 @@ func (c *Closure) Inspect() string {
 }
 
 // -----------------------------------------------------
+
+// -----------------------------------------------------
+// Upvalueの定義
+// クロージャが捕捉したローカル変数を入れておくセル
+// 捕捉元の関数の実行中（open）はVMのスタック上の変数を指し、関数から戻るとき（close）に値をセル自身に移す
+// 同じ変数を捕捉したクロージャは同じセルを共有するので、互いの代入が見える
+type Upvalue struct {
+	Index  int    // openの間に指しているスタック上の位置
+	Closed bool   // closeされたか
+	Value  Object // closeされた後の値
+}
+
+func (uv *Upvalue) Type() ObjectType { return UPVALUE_OBJ }
+func (uv *Upvalue) Inspect() string {
+	return fmt.Sprintf("Upvalue[%p]", uv)
+}
+
+// -----------------------------------------------------
@@ -1,6 +1,14 @@
 package object
 
-import "testing"
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"reflect"
+	"strings"
+	"testing"
+)
 
 func TestStringHashKey(t *testing.T) {
 	hello1 := &String{Value: "Hello World"}
@@ -17,3 +25,583 @@ func TestStringHashKey(t *testing.T) {
 		t.Errorf("StringObjects with different content have same hash keys")
 	}
 }
+
+func TestIntegerOverflowPromotion(t *testing.T) {
+	maxInt := &Integer{Value: math.MaxInt64}
+	minInt := &Integer{Value: math.MinInt64}
+	one := &Integer{Value: 1}
+	minusOne := &Integer{Value: -1}
+	// 0で割らない割り算の結果を返す
+	div := func(left, right Object) Object {
+		t.Helper()
+		quotient, err := DivIntegers(left, right)
+		if err != nil {
+			t.Fatalf("DivIntegers(%s, %s) failed: %s", left.Inspect(), right.Inspect(), err)
+		}
+		return quotient
+	}
+
+	tests := []struct {
+		result   Object
+		expected string
+		typ      ObjectType
+	}{
+		{AddIntegers(maxInt, one), "9223372036854775808", BIGINT_OBJ},
+		{SubIntegers(minInt, one), "-9223372036854775809", BIGINT_OBJ},
+		{MulIntegers(maxInt, &Integer{Value: 2}), "18446744073709551614", BIGINT_OBJ},
+		{MulIntegers(minInt, minusOne), "9223372036854775808", BIGINT_OBJ},
+		{div(minInt, minusOne), "9223372036854775808", BIGINT_OBJ},
+		{NegateInteger(minInt), "9223372036854775808", BIGINT_OBJ},
+		{AddIntegers(maxInt, minusOne), "9223372036854775806", INTEGER_OBJ},
+		// int64に収まる結果はIntegerに戻る
+		{SubIntegers(AddIntegers(maxInt, one), one), "9223372036854775807", INTEGER_OBJ},
+		{div(MulIntegers(maxInt, maxInt), maxInt), "9223372036854775807", INTEGER_OBJ},
+	}
+
+	for i, tt := range tests {
+		if tt.result.Type() != tt.typ {
+			t.Errorf("tests[%d] - wrong type. want=%s, got=%s", i, tt.typ, tt.result.Type())
+		}
+		if tt.result.Inspect() != tt.expected {
+			t.Errorf("tests[%d] - wrong value. want=%s, got=%s", i, tt.expected, tt.result.Inspect())
+		}
+	}
+
+	if _, err := DivIntegers(one, &Integer{Value: 0}); err != ErrDivisionByZero {
+		t.Errorf("expected ErrDivisionByZero. got=%v", err)
+	}
+	if _, err := DivIntegers(AddIntegers(maxInt, one), &Integer{Value: 0}); err != ErrDivisionByZero {
+		t.Errorf("expected ErrDivisionByZero for BigInt. got=%v", err)
+	}
+
+	big1 := AddIntegers(maxInt, one).(*BigInt)
+	big2 := AddIntegers(maxInt, one).(*BigInt)
+	if big1.HashKey() != big2.HashKey() {
+		t.Errorf("BigInts with same value have different hash keys")
+	}
+	if CompareIntegers(big1, maxInt) != 1 || CompareIntegers(minInt, big1) != -1 {
+		t.Errorf("BigInt comparison is wrong")
+	}
+}
+
+func TestRangeIterator(t *testing.T) {
+	tests := []struct {
+		rng      *Range
+		expected []int64
+	}{
+		{&Range{Start: 0, End: 3, Step: 1}, []int64{0, 1, 2}},
+		{&Range{Start: 3, End: 0, Step: -1}, []int64{3, 2, 1}},
+		{&Range{Start: 0, End: 0, Step: 1}, []int64{}},
+		{&Range{Start: 0, End: 5, Step: -1}, []int64{}},
+		// int64の範囲を超える前に止まる
+		{&Range{Start: math.MaxInt64 - 1, End: math.MaxInt64, Step: 2}, []int64{math.MaxInt64 - 1}},
+		{&Range{Start: math.MinInt64 + 1, End: math.MinInt64, Step: -3}, []int64{math.MinInt64 + 1}},
+	}
+
+	for i, tt := range tests {
+		it, ok := NewIterator(tt.rng)
+		if !ok {
+			t.Fatalf("tests[%d] - Range is not iterable", i)
+		}
+		got := []int64{}
+		for {
+			value, ok := it.NextElement()
+			if !ok {
+				break
+			}
+			got = append(got, value.(*Integer).Value)
+		}
+		if len(got) != len(tt.expected) {
+			t.Fatalf("tests[%d] - wrong elements. want=%v, got=%v", i, tt.expected, got)
+		}
+		for j := range got {
+			if got[j] != tt.expected[j] {
+				t.Errorf("tests[%d] - wrong elements. want=%v, got=%v", i, tt.expected, got)
+			}
+		}
+	}
+
+	if _, ok := NewIterator(&Integer{Value: 1}); ok {
+		t.Errorf("Integer must not be iterable")
+	}
+	if got := (&Range{Start: 1, End: 10, Step: 2}).Inspect(); got != "range(1, 10, 2)" {
+		t.Errorf("wrong Inspect. got=%q", got)
+	}
+}
+
+func TestBudget(t *testing.T) {
+	b := NewBudget(context.Background(), 10)
+	for i := 0; i < 10; i++ {
+		if err := b.Step(); err != nil {
+			t.Fatalf("step %d failed: %v", i, err)
+		}
+	}
+	if err := b.Step(); err != ErrBudgetExhausted {
+		t.Fatalf("expected ErrBudgetExhausted. got=%v", err)
+	}
+	if b.Err() != ErrBudgetExhausted || b.Steps() != 11 {
+		t.Errorf("wrong state. err=%v, steps=%d", b.Err(), b.Steps())
+	}
+
+	// キャンセルはcontextを調べる間隔のうちに検出される
+	ctx, cancel := context.WithCancel(context.Background())
+	b = NewBudget(ctx, 0)
+	cancel()
+	var err error
+	for i := 0; i < budgetCheckInterval && err == nil; i++ {
+		err = b.Step()
+	}
+	if !errors.Is(err, context.Canceled) {
+		t.Errorf("expected context.Canceled. got=%v", err)
+	}
+}
+
+func TestBudgetAllocation(t *testing.T) {
+	str := &String{Value: "hello"}
+	arr := &Array{Elements: []Object{str, str}}
+	if SizeOf(arr) <= SizeOf(&Array{}) || SizeOf(str) <= SizeOf(&String{}) || SizeOf(&Integer{Value: 1}) != 0 {
+		t.Fatalf("wrong sizes. array=%d, string=%d", SizeOf(arr), SizeOf(str))
+	}
+
+	b := NewBudget(context.Background(), 0).LimitAllocation(SizeOf(arr) + SizeOf(str))
+	if err := b.Allocate(arr); err != nil {
+		t.Fatalf("allocate failed: %v", err)
+	}
+	if err := b.Allocate(str); err != nil {
+		t.Fatalf("allocate failed: %v", err)
+	}
+	if err := b.AllocateBytes(1); err != ErrAllocationLimit {
+		t.Fatalf("expected ErrAllocationLimit. got=%v", err)
+	}
+	if b.Err() != ErrAllocationLimit || b.Allocated() != SizeOf(arr)+SizeOf(str)+1 {
+		t.Errorf("wrong state. err=%v, allocated=%d", b.Err(), b.Allocated())
+	}
+
+	// 上限がなければいくら確保してもよい
+	b = NewBudget(context.Background(), 0)
+	if err := b.AllocateBytes(1 << 40); err != nil {
+		t.Errorf("unlimited budget failed: %v", err)
+	}
+}
+
+func TestFromGo(t *testing.T) {
+	tests := []struct {
+		input    interface{}
+		expected string // Inspect()の結果
+	}{
+		{nil, "Null"},
+		{true, "true"},
+		{42, "42"},
+		{uint8(7), "7"},
+		{1.5, "1.5"},
+		{"hello", "hello"},
+		{[]int{1, 2, 3}, "[1, 2, 3]"},
+		{[]interface{}{1, "a", nil}, "[1, a, Null]"},
+		{map[string]int{"a": 1}, "{a: 1}"},
+		{&Integer{Value: 5}, "5"},
+	}
+
+	for _, tt := range tests {
+		obj, err := FromGo(tt.input)
+		if err != nil {
+			t.Errorf("FromGo(%#v) failed: %v", tt.input, err)
+			continue
+		}
+		if obj.Inspect() != tt.expected {
+			t.Errorf("FromGo(%#v) wrong. want=%q, got=%q", tt.input, tt.expected, obj.Inspect())
+		}
+	}
+
+	// 真偽値とNullは唯一のインスタンスになる
+	if obj, _ := FromGo(true); obj != TRUE {
+		t.Errorf("true is not TRUE. got=%p", obj)
+	}
+	if obj, _ := FromGo(nil); obj != NULL {
+		t.Errorf("nil is not NULL. got=%p", obj)
+	}
+
+	for _, input := range []interface{}{uint64(math.MaxUint64), struct{}{}, map[interface{}]int{nil: 1}} {
+		if _, err := FromGo(input); err == nil {
+			t.Errorf("FromGo(%#v) should fail", input)
+		}
+	}
+}
+
+func TestToGo(t *testing.T) {
+	closure := &Closure{Fn: &CompiledFunction{}}
+	tests := []struct {
+		input    Object
+		target   interface{} // 変換先の型の値
+		expected interface{}
+	}{
+		{&Integer{Value: 42}, int(0), 42},
+		{&Integer{Value: 42}, uint16(0), uint16(42)},
+		{&Integer{Value: 2}, float64(0), 2.0},
+		{&Float{Value: 1.5}, float32(0), float32(1.5)},
+		{&String{Value: "hi"}, "", "hi"},
+		{TRUE, false, true},
+		{&Array{Elements: []Object{&Integer{Value: 1}, &Integer{Value: 2}}}, []int64(nil), []int64{1, 2}},
+		{&Array{Elements: []Object{&Integer{Value: 1}, &String{Value: "a"}, NULL}}, []interface{}(nil), []interface{}{int64(1), "a", nil}},
+		{closure, (*Closure)(nil), closure},
+	}
+
+	for _, tt := range tests {
+		v, err := ToGo(tt.input, reflect.TypeOf(tt.target))
+		if err != nil {
+			t.Errorf("ToGo(%s, %T) failed: %v", tt.input.Inspect(), tt.target, err)
+			continue
+		}
+		if !reflect.DeepEqual(v.Interface(), tt.expected) {
+			t.Errorf("ToGo(%s, %T) wrong. want=%#v, got=%#v", tt.input.Inspect(), tt.target, tt.expected, v.Interface())
+		}
+	}
+
+	failures := []struct {
+		input  Object
+		target interface{}
+	}{
+		{&Integer{Value: 300}, int8(0)},
+		{&Integer{Value: -1}, uint(0)},
+		{&String{Value: "1"}, int(0)},
+		{&Float{Value: 1.5}, int(0)},
+	}
+	for _, tt := range failures {
+		if _, err := ToGo(tt.input, reflect.TypeOf(tt.target)); err == nil {
+			t.Errorf("ToGo(%s, %T) should fail", tt.input.Inspect(), tt.target)
+		}
+	}
+}
+
+func TestHostFunction(t *testing.T) {
+	tests := []struct {
+		fn       interface{}
+		args     []Object
+		expected string // 戻り値のInspect()の結果、Nullならnilに相当する"<nil>"
+	}{
+		{strings.ToUpper, []Object{&String{Value: "monkey"}}, "MONKEY"},
+		{func(a, b int) int { return a + b }, []Object{&Integer{Value: 1}, &Integer{Value: 2}}, "3"},
+		{func(xs ...float64) float64 { return xs[0] * xs[1] }, []Object{&Integer{Value: 3}, &Float{Value: 0.5}}, "1.5"},
+		{func(s []string) int { return len(s) }, []Object{&Array{Elements: []Object{&String{Value: "a"}}}}, "1"},
+		{func() {}, []Object{}, "<nil>"},
+		{func(n int) (int, error) { return n * 2, nil }, []Object{&Integer{Value: 4}}, "8"},
+		{func(n int) (int, error) { return 0, fmt.Errorf("bad %d", n) }, []Object{&Integer{Value: 4}}, "ERROR: bad 4"},
+		{strings.ToUpper, []Object{&Integer{Value: 1}}, "ERROR: argument 1: cannot convert INTEGER to string"},
+		{strings.ToUpper, []Object{}, "ERROR: wrong number of arguments. got=0, want=1"},
+	}
+
+	for _, tt := range tests {
+		builtin, err := NewHostFunction(tt.fn)
+		if err != nil {
+			t.Fatalf("NewHostFunction(%T) failed: %v", tt.fn, err)
+		}
+		result := builtin.Fn(nil, tt.args...)
+		got := "<nil>"
+		if result != nil {
+			got = result.Inspect()
+		}
+		if got != tt.expected {
+			t.Errorf("%T: wrong result. want=%q, got=%q", tt.fn, tt.expected, got)
+		}
+	}
+
+	for _, fn := range []interface{}{42, func() (int, int) { return 0, 0 }} {
+		if _, err := NewHostFunction(fn); err == nil {
+			t.Errorf("NewHostFunction(%T) should fail", fn)
+		}
+	}
+}
+
+func TestRegistry(t *testing.T) {
+	double := &Builtin{
+		Fn: func(call Invoker, args ...Object) Object {
+			return &Integer{Value: args[0].(*Integer).Value * 2}
+		},
+		Signature: &Signature{MinArgs: 1, MaxArgs: 1, Types: []ObjectType{INTEGER_OBJ}},
+	}
+
+	r := NewRegistry()
+	if i, err := r.Register("double", double); err != nil || i != 0 {
+		t.Fatalf("register failed. index=%d, err=%v", i, err)
+	}
+	if i, err := r.Namespace("math").Register("double", double); err != nil || i != 1 {
+		t.Fatalf("register in namespace failed. index=%d, err=%v", i, err)
+	}
+	for _, name := range []string{"double", "math.double", "1x", "a..b", ""} {
+		if _, err := r.Register(name, double); err == nil {
+			t.Errorf("registering %q should fail", name)
+		}
+	}
+
+	b, ok := r.Lookup("math.double")
+	if !ok || b.Name != "math.double" {
+		t.Fatalf("math.double not found. got=%+v", b)
+	}
+	if at, _ := r.At(1); at != b {
+		t.Errorf("At(1) is not math.double")
+	}
+	if _, ok := r.At(2); ok {
+		t.Errorf("At(2) should not be found")
+	}
+	if names := r.Names(); !reflect.DeepEqual(names, []string{"double", "math.double"}) {
+		t.Errorf("wrong names. got=%v", names)
+	}
+	// 登録したBuiltinは複製なので元のものは変わらない
+	if double.Name != "" {
+		t.Errorf("registered builtin was modified. name=%q", double.Name)
+	}
+
+	// Registryごとに独立している
+	standard := NewStandardRegistry()
+	if _, err := standard.Register("double", double); err != nil {
+		t.Fatalf("register failed: %v", err)
+	}
+	if _, ok := NewStandardRegistry().Lookup("double"); ok {
+		t.Errorf("registration leaked into another registry")
+	}
+	if standard.Len() != len(standardBuiltins)+1 {
+		t.Errorf("wrong number of builtins. want=%d, got=%d", len(standardBuiltins)+1, standard.Len())
+	}
+
+	tests := []struct {
+		args     []Object
+		expected string
+	}{
+		{[]Object{&Integer{Value: 21}}, "42"},
+		{[]Object{}, "ERROR: wrong number of arguments. got=0, want=1"},
+		{[]Object{&String{Value: "a"}}, "ERROR: argument to `math.double` must be INTEGER, got STRING"},
+	}
+	for _, tt := range tests {
+		if result := b.Call(nil, tt.args...); result.Inspect() != tt.expected {
+			t.Errorf("wrong result. want=%q, got=%q", tt.expected, result.Inspect())
+		}
+	}
+}
+
+func TestSignature(t *testing.T) {
+	one := &Integer{Value: 1}
+	str := &String{Value: "a"}
+	tests := []struct {
+		signature Signature
+		args      []Object
+		expected  string // エラーがなければ空
+	}{
+		{Signature{MinArgs: 1, MaxArgs: 3}, []Object{}, "wrong number of arguments. got=0, want=1..3"},
+		{Signature{MinArgs: 1, MaxArgs: 3}, []Object{one, one, one, one}, "wrong number of arguments. got=4, want=1..3"},
+		{Signature{MinArgs: 1, MaxArgs: -1}, []Object{}, "wrong number of arguments. got=0, want>=1"},
+		{Signature{MinArgs: 0, MaxArgs: -1, Types: []ObjectType{STRING_OBJ, INTEGER_OBJ}}, []Object{str, one, one}, ""},
+		{Signature{MinArgs: 0, MaxArgs: -1, Types: []ObjectType{STRING_OBJ, INTEGER_OBJ}}, []Object{str, one, str}, "argument to `f` must be INTEGER, got STRING"},
+		{Signature{MinArgs: 2, MaxArgs: 2, Types: []ObjectType{ANY_OBJ, STRING_OBJ}}, []Object{one, str}, ""},
+	}
+
+	for i, tt := range tests {
+		err := tt.signature.check("f", tt.args)
+		got := ""
+		if err != nil {
+			got = err.Message
+		}
+		if got != tt.expected {
+			t.Errorf("tests[%d] wrong error. want=%q, got=%q", i, tt.expected, got)
+		}
+	}
+}
+
+func TestHashOrder(t *testing.T) {
+	a := &String{Value: "a"}
+	b := &String{Value: "b"}
+	c := &String{Value: "c"}
+	hash := &Hash{}
+	for i, key := range []*String{c, a, b} {
+		hash.Set(key, &Integer{Value: int64(i)})
+	}
+	// 既にあるキーの値を変えても位置は変わらない
+	hash.Set(a, &Integer{Value: 10})
+	if got := hash.Inspect(); got != "{c: 0, a: 10, b: 2}" {
+		t.Errorf("wrong order. got=%q", got)
+	}
+
+	if !hash.Delete(c) {
+		t.Errorf("Delete returned false for existing key")
+	}
+	if hash.Delete(c) {
+		t.Errorf("Delete returned true for deleted key")
+	}
+	hash.Set(c, &Integer{Value: 3})
+	if got := hash.Inspect(); got != "{a: 10, b: 2, c: 3}" {
+		t.Errorf("wrong order after Delete. got=%q", got)
+	}
+	if hash.Len() != 3 {
+		t.Errorf("wrong length. got=%d", hash.Len())
+	}
+	if pair, ok := hash.Get(b); !ok || pair.Value.(*Integer).Value != 2 {
+		t.Errorf("wrong pair for b. got=%+v", pair)
+	}
+}
+
+func TestEqual(t *testing.T) {
+	one := &Integer{Value: 1}
+	key := &String{Value: "k"}
+	newHash := func(value Object) *Hash {
+		h := &Hash{}
+		h.Set(key, value)
+		return h
+	}
+
+	// 自分自身を含む配列とハッシュ
+	cyclicA := &Array{Elements: []Object{one, nil}}
+	cyclicA.Elements[1] = cyclicA
+	cyclicB := &Array{Elements: []Object{one, nil}}
+	cyclicB.Elements[1] = cyclicB
+	cyclicC := &Array{Elements: []Object{&Integer{Value: 2}, nil}}
+	cyclicC.Elements[1] = cyclicC
+	cyclicHashA := newHash(nil)
+	cyclicHashA.Set(key, cyclicHashA)
+	cyclicHashB := newHash(nil)
+	cyclicHashB.Set(key, cyclicHashB)
+
+	tests := []struct {
+		left, right Object
+		expected    bool
+	}{
+		{one, &Integer{Value: 1}, true},
+		{one, &Float{Value: 1}, true},
+		{one, &String{Value: "1"}, false},
+		{&String{Value: "a"}, &String{Value: "a"}, true},
+		{NULL, &Null{}, true},
+		{NULL, FALSE, false},
+		{&Boolean{Value: true}, TRUE, true},
+		{&Array{Elements: []Object{one, NULL}}, &Array{Elements: []Object{&Integer{Value: 1}, NULL}}, true},
+		{&Array{Elements: []Object{one}}, &Array{Elements: []Object{one, one}}, false},
+		{newHash(one), newHash(&Integer{Value: 1}), true},
+		{newHash(one), newHash(TRUE), false},
+		{newHash(one), &Hash{}, false},
+		{cyclicA, cyclicB, true},
+		{cyclicA, cyclicC, false},
+		{cyclicHashA, cyclicHashB, true},
+		{&Builtin{}, &Builtin{}, false},
+	}
+
+	for i, tt := range tests {
+		if got := Equal(tt.left, tt.right); got != tt.expected {
+			t.Errorf("tests[%d] Equal(%s, %s) wrong. want=%t, got=%t", i, tt.left.Type(), tt.right.Type(), tt.expected, got)
+		}
+		if got := Equal(tt.right, tt.left); got != tt.expected {
+			t.Errorf("tests[%d] Equal(%s, %s) wrong. want=%t, got=%t", i, tt.right.Type(), tt.left.Type(), tt.expected, got)
+		}
+	}
+}
+
+// HashKeyが必ず衝突するキー
+type collidingKey struct {
+	name string
+}
+
+func (k *collidingKey) Type() ObjectType { return "COLLIDING" }
+func (k *collidingKey) Inspect() string  { return k.name }
+func (k *collidingKey) HashKey() HashKey { return HashKey{Type: k.Type(), Value: 1} }
+
+func TestHashKeyCollision(t *testing.T) {
+	a := &collidingKey{"a"}
+	b := &collidingKey{"b"}
+	c := &collidingKey{"c"}
+	hash := &Hash{}
+	hash.Set(a, &Integer{Value: 1})
+	hash.Set(b, &Integer{Value: 2})
+	hash.Set(c, &Integer{Value: 3})
+
+	for i, key := range []*collidingKey{a, b, c} {
+		pair, ok := hash.Get(key)
+		if !ok || pair.Key != key || pair.Value.(*Integer).Value != int64(i+1) {
+			t.Errorf("wrong pair for %s. got=%+v", key.name, pair)
+		}
+	}
+
+	if !hash.Delete(a) {
+		t.Fatalf("Delete returned false for existing key")
+	}
+	if _, ok := hash.Get(a); ok {
+		t.Errorf("deleted key still found")
+	}
+	for i, key := range []*collidingKey{b, c} {
+		pair, ok := hash.Get(key)
+		if !ok || pair.Value.(*Integer).Value != int64(i+2) {
+			t.Errorf("wrong pair for %s after Delete. got=%+v", key.name, pair)
+		}
+	}
+	if got := hash.Inspect(); got != "{b: 2, c: 3}" {
+		t.Errorf("wrong Inspect after Delete. got=%q", got)
+	}
+}
+
+func TestAsHashable(t *testing.T) {
+	one := &Integer{Value: 1}
+	mutable := &Array{Elements: []Object{one}}
+	tests := []struct {
+		obj      Object
+		expected bool
+	}{
+		{one, true},
+		{&String{Value: "a"}, true},
+		{&Float{Value: 1.5}, false},
+		{mutable, false},
+		{mutable.Freeze(), true},
+		{&Array{Elements: []Object{&Hash{}}, Frozen: true}, false},
+		{&Tuple{Elements: []Object{one, &String{Value: "a"}}}, true},
+		{&Tuple{Elements: []Object{one, mutable}}, false},
+		{&Tuple{Elements: []Object{&Tuple{}, mutable.Freeze()}}, true},
+		{&Hash{}, false},
+	}
+
+	for i, tt := range tests {
+		if _, ok := AsHashable(tt.obj); ok != tt.expected {
+			t.Errorf("tests[%d] AsHashable(%s) wrong. want=%t, got=%t", i, tt.obj.Inspect(), tt.expected, ok)
+		}
+	}
+
+	// 同じ要素のタプルは同じHashKeyになり、型が違えば別のHashKeyになる
+	t1 := &Tuple{Elements: []Object{one, &String{Value: "a"}}}
+	t2 := &Tuple{Elements: []Object{&Integer{Value: 1}, &String{Value: "a"}}}
+	if t1.HashKey() != t2.HashKey() {
+		t.Errorf("tuples with same elements have different hash keys")
+	}
+	frozen := (&Array{Elements: []Object{one, &String{Value: "a"}}}).Freeze()
+	if t1.HashKey() == frozen.HashKey() {
+		t.Errorf("tuple and frozen array have the same hash key")
+	}
+}
+
+func TestInspectCycles(t *testing.T) {
+	key := &String{Value: "self"}
+
+	array := &Array{Elements: []Object{&Integer{Value: 1}, nil}}
+	array.Elements[1] = array
+
+	hash := &Hash{}
+	hash.Set(key, hash)
+
+	// 配列 -> タプル -> 配列
+	inner := &Array{Elements: []Object{nil}}
+	tuple := &Tuple{Elements: []Object{inner}}
+	inner.Elements[0] = tuple
+
+	// 同じ値を二度含んでいても循環していなければ省略しない
+	shared := &Array{Elements: []Object{&Integer{Value: 2}}}
+	twice := &Array{Elements: []Object{shared, shared}}
+
+	tests := []struct {
+		obj      Object
+		expected string
+	}{
+		{array, "[1, [...]]"},
+		{hash, "{self: {...}}"},
+		{&Array{Elements: []Object{hash}}, "[{self: {...}}]"},
+		{tuple, "([(...)],)"},
+		{inner, "[([...],)]"},
+		{twice, "[[2], [2]]"},
+	}
+
+	for i, tt := range tests {
+		if got := tt.obj.Inspect(); got != tt.expected {
+			t.Errorf("tests[%d] wrong Inspect. want=%q, got=%q", i, tt.expected, got)
+		}
+	}
+}
@@ -0,0 +1,129 @@
+package object
+
+import (
+	"fmt"
+	"strings"
+)
+
+// -----------------------------------------------------
+// Registryの定義
+// 組み込み関数を名前と番号で引けるようにまとめたもの
+// コンパイラ（シンボルテーブル）、VM、評価器は同じRegistryを参照する
+// 番号はOpGetBuiltinのオペランドになるので、登録した順に0から振る
+type Registry struct {
+	builtins []*Builtin
+	index    map[string]int // 名前から番号を引く
+}
+
+// 登録できる組み込み関数の数の上限（OpGetBuiltinのオペランドは2バイト）
+const MaxBuiltins = 1 << 16
+
+// 名前空間の区切り
+// "strings.upper"のように、名前空間の名前と組み込み関数の名前を繋げて登録する
+const NamespaceSeparator = "."
+
+// 空のRegistryを生成する
+func NewRegistry() *Registry {
+	return &Registry{index: make(map[string]int)}
+}
+
+// 標準の組み込み関数（standardBuiltins）を登録したRegistryを生成する
+// 呼び出すたびに新しいRegistryを返すので、登録を追加しても他には影響しない
+func NewStandardRegistry() *Registry {
+	r := NewRegistry()
+	for _, def := range standardBuiltins {
+		if _, err := r.Register(def.Name, def.Builtin); err != nil {
+			panic(err)
+		}
+	}
+	return r
+}
+
+// 組み込み関数bをnameという名前で登録し、その番号を返す
+// bは複製して登録するので、同じbを別の名前や別のRegistryに登録してもよい
+// 既に登録されている名前や、識別子として使えない名前は登録できない
+func (r *Registry) Register(name string, b *Builtin) (int, error) {
+	if !isBuiltinName(name) {
+		return 0, fmt.Errorf("invalid builtin name: %q", name)
+	}
+	if _, ok := r.index[name]; ok {
+		return 0, fmt.Errorf("builtin already registered: %s", name)
+	}
+	if len(r.builtins) >= MaxBuiltins {
+		return 0, fmt.Errorf("too many builtins: %s", name)
+	}
+	registered := *b
+	registered.Name = name
+	r.builtins = append(r.builtins, &registered)
+	r.index[name] = len(r.builtins) - 1
+	return len(r.builtins) - 1, nil
+}
+
+// 名前空間nsに組み込み関数を登録するためのNamespaceを返す
+func (r *Registry) Namespace(ns string) *Namespace {
+	return &Namespace{registry: r, name: ns}
+}
+
+// nameという名前の組み込み関数を返す
+func (r *Registry) Lookup(name string) (*Builtin, bool) {
+	i, ok := r.index[name]
+	if !ok {
+		return nil, false
+	}
+	return r.builtins[i], true
+}
+
+// index番の組み込み関数を返す
+func (r *Registry) At(index int) (*Builtin, bool) {
+	if index < 0 || index >= len(r.builtins) {
+		return nil, false
+	}
+	return r.builtins[index], true
+}
+
+// 登録されている組み込み関数の名前を番号順に返す
+func (r *Registry) Names() []string {
+	names := make([]string, len(r.builtins))
+	for i, b := range r.builtins {
+		names[i] = b.Name
+	}
+	return names
+}
+
+// 登録されている組み込み関数の数を返す
+func (r *Registry) Len() int {
+	return len(r.builtins)
+}
+
+// 組み込み関数の名前として使えるかを返すヘルパー関数
+// 識別子として字句解析できる名前を名前空間の区切りで繋げたものだけを認める
+func isBuiltinName(name string) bool {
+	for _, part := range strings.Split(name, NamespaceSeparator) {
+		if part == "" {
+			return false
+		}
+		for _, ch := range part {
+			if !('a' <= ch && ch <= 'z' || 'A' <= ch && ch <= 'Z' || ch == '_') {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// -----------------------------------------------------
+
+// -----------------------------------------------------
+// Namespaceの定義
+// Registryのうち、名前空間の名前が前に付く部分
+type Namespace struct {
+	registry *Registry
+	name     string
+}
+
+// 組み込み関数bを"名前空間.name"という名前で登録し、その番号を返す
+func (n *Namespace) Register(name string, b *Builtin) (int, error) {
+	return n.registry.Register(n.name+NamespaceSeparator+name, b)
+}
+
+// -----------------------------------------------------
@@ -0,0 +1,63 @@
+package parser
+
+import (
+	"fmt"
+	"monkey/token"
+)
+
+// 構文解析中に検出したエラー
+type ParseError struct {
+	Pos      token.Position    // エラーを検出した位置
+	Expected []token.TokenType // 期待していたトークンの種類（分かる場合のみ）
+	Found    token.Token       // 実際に遭遇したトークン
+	Message  string            // エラーメッセージ
+}
+
+// 「line:column: message」の形式でエラーを返す
+func (e *ParseError) Error() string {
+	if !e.Pos.IsValid() && e.Pos.Filename == "" {
+		return e.Message
+	}
+	return fmt.Sprintf("%s: %s", e.Pos, e.Message)
+}
+
+// エラーを記録して、文の境界まで読み飛ばすパニックモードに入る
+// パニックモード中のエラーは直前のエラーに起因するものなので記録しない
+func (p *Parser) addError(tok token.Token, expected []token.TokenType, format string, a ...interface{}) {
+	p.addErrorAt(tok.Pos, tok, expected, format, a...)
+}
+
+// トークンの先頭とは異なる位置のエラーを記録する（文字列中の不正なエスケープシーケンスなど）
+func (p *Parser) addErrorAt(pos token.Position, tok token.Token, expected []token.TokenType, format string, a ...interface{}) {
+	if p.panicking {
+		return
+	}
+	p.panicking = true
+	p.errors = append(p.errors, &ParseError{
+		Pos:      pos,
+		Expected: expected,
+		Found:    tok,
+		Message:  fmt.Sprintf(format, a...),
+	})
+}
+
+// パニックモードから復帰するために文の境界までトークンを読み飛ばす
+// depthはパース中の文の深さ（プログラムの直下なら0、ブロックの中ならそのブロックの「{」までの深さ）
+// 入れ子になったブロックやハッシュの中は読み飛ばし、同じ深さで「;」に到達するか、
+// 次のトークンが文の始まりかブロックの終わりであれば止まる
+// 今パースしているブロックを閉じる「}」に到達した場合もそこで止まる
+func (p *Parser) synchronize(depth int) {
+	defer func() { p.panicking = false }()
+	for !p.curTokenIs(token.EOF) && p.depth >= depth {
+		if p.depth == depth {
+			if p.curTokenIs(token.SEMICOLON) {
+				return
+			}
+			switch p.peekToken.Type {
+			case token.LET, token.RETURN, token.WHILE, token.FOR, token.BREAK, token.CONTINUE, token.RBRACE:
+				return
+			}
+		}
+		p.nextToken()
+	}
+}
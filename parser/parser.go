@@ -1,46 +1,68 @@
 package parser
 
 import (
-	"fmt"
 	"monkey/ast"
 	"monkey/lexer"
 	"monkey/token"
 	"strconv"
+	"strings"
 )
 
 const (
 	// 優先順位の定義
 	_ int = iota
 	LOWEST
-	EQUALS     // ==
-	LESSGRATER // > or <
-	SUM        // +
-	PRODUCT    // *
-	PREFIX     // -x or !x
-	CALL       // myFunction(x)
-	INDEX      // array[index]
+	ASSIGN      // = or +=
+	LOGICAL_OR  // ||
+	LOGICAL_AND // &&
+	EQUALS      // ==
+	LESSGRATER  // > or <
+	SUM         // + or | or ^
+	PRODUCT     // * or % or << or &
+	PREFIX      // -x or !x
+	CALL        // myFunction(x)
+	INDEX       // array[index]
 )
 
 // 優先順位テーブル
+// ビット演算子はGoと同じ優先順位にしている
 var precedences = map[token.TokenType]int{
-	token.EQ:       EQUALS,
-	token.NOT_EQ:   EQUALS,
-	token.LT:       LESSGRATER,
-	token.GT:       LESSGRATER,
-	token.PLUS:     SUM,
-	token.MINUS:    SUM,
-	token.SLASH:    PRODUCT,
-	token.ASTERISK: PRODUCT,
-	token.LPAREN:   CALL,
-	token.LBRACKET: INDEX,
+	token.ASSIGN:          ASSIGN,
+	token.PLUS_ASSIGN:     ASSIGN,
+	token.MINUS_ASSIGN:    ASSIGN,
+	token.ASTERISK_ASSIGN: ASSIGN,
+	token.SLASH_ASSIGN:    ASSIGN,
+	token.OR:              LOGICAL_OR,
+	token.AND:             LOGICAL_AND,
+	token.EQ:              EQUALS,
+	token.NOT_EQ:          EQUALS,
+	token.LT:              LESSGRATER,
+	token.GT:              LESSGRATER,
+	token.LT_EQ:           LESSGRATER,
+	token.GT_EQ:           LESSGRATER,
+	token.PLUS:            SUM,
+	token.MINUS:           SUM,
+	token.PIPE:            SUM,
+	token.CARET:           SUM,
+	token.SLASH:           PRODUCT,
+	token.ASTERISK:        PRODUCT,
+	token.PERCENT:         PRODUCT,
+	token.AMPERSAND:       PRODUCT,
+	token.SHIFT_LEFT:      PRODUCT,
+	token.SHIFT_RIGHT:     PRODUCT,
+	token.LPAREN:          CALL,
+	token.LBRACKET:        INDEX,
 }
 
 // パーサの定義
 type Parser struct {
-	l         *lexer.Lexer // 字句解析器を内部に含む
-	errors    []string     // エラー
-	curToken  token.Token  // 今見ているトークン
-	peekToken token.Token  // 次見るべきトークン
+	l         *lexer.Lexer  // 字句解析器を内部に含む
+	errors    []*ParseError // エラー
+	panicking bool          // エラーを検出してから文の境界に復帰するまでの間はtrue
+	loopDepth int           // 今パースしているループの入れ子の深さ（break/continueの検査に使う）
+	depth     int           // 今見ているトークンまでに開いて閉じていない「{」の数（エラーからの復帰に使う）
+	curToken  token.Token   // 今見ているトークン
+	peekToken token.Token   // 次見るべきトークン
 
 	// Pratt構文解析器のアイディアの核心
 	prefixParseFns map[token.TokenType]prefixParseFn // 特定の前置演算子トークンとそれを解析する関数のマップ
@@ -51,7 +73,7 @@ type Parser struct {
 func New(l *lexer.Lexer) *Parser {
 	p := &Parser{
 		l:      l,
-		errors: []string{},
+		errors: []*ParseError{},
 	}
 	p.nextToken()
 	p.nextToken()
@@ -59,6 +81,7 @@ func New(l *lexer.Lexer) *Parser {
 	p.prefixParseFns = make(map[token.TokenType]prefixParseFn)
 	p.registerPrefix(token.IDENT, p.parseIdentifier)
 	p.registerPrefix(token.INT, p.parseIntegerLiteral)
+	p.registerPrefix(token.FLOAT, p.parseFloatLiteral)
 	p.registerPrefix(token.BANG, p.parsePrefixExpression)
 	p.registerPrefix(token.MINUS, p.parsePrefixExpression)
 	p.registerPrefix(token.TRUE, p.parseBoolean)
@@ -69,6 +92,7 @@ func New(l *lexer.Lexer) *Parser {
 	p.registerPrefix(token.STRING, p.parseStringLiteral)
 	p.registerPrefix(token.LBRACKET, p.parseArrayLiteral)
 	p.registerPrefix(token.LBRACE, p.parseHashLiteral)
+	p.registerPrefix(token.ILLEGAL, p.parseIllegal)
 
 	p.infixParseFns = make(map[token.TokenType]infixParseFn)
 	p.registerInfix(token.PLUS, p.parseInfixExpression)
@@ -79,27 +103,57 @@ func New(l *lexer.Lexer) *Parser {
 	p.registerInfix(token.NOT_EQ, p.parseInfixExpression)
 	p.registerInfix(token.LT, p.parseInfixExpression)
 	p.registerInfix(token.GT, p.parseInfixExpression)
+	p.registerInfix(token.LT_EQ, p.parseInfixExpression)
+	p.registerInfix(token.GT_EQ, p.parseInfixExpression)
+	p.registerInfix(token.PERCENT, p.parseInfixExpression)
+	p.registerInfix(token.AMPERSAND, p.parseInfixExpression)
+	p.registerInfix(token.PIPE, p.parseInfixExpression)
+	p.registerInfix(token.CARET, p.parseInfixExpression)
+	p.registerInfix(token.SHIFT_LEFT, p.parseInfixExpression)
+	p.registerInfix(token.SHIFT_RIGHT, p.parseInfixExpression)
+	p.registerInfix(token.AND, p.parseInfixExpression)
+	p.registerInfix(token.OR, p.parseInfixExpression)
+	p.registerInfix(token.ASSIGN, p.parseAssignExpression)
+	p.registerInfix(token.PLUS_ASSIGN, p.parseAssignExpression)
+	p.registerInfix(token.MINUS_ASSIGN, p.parseAssignExpression)
+	p.registerInfix(token.ASTERISK_ASSIGN, p.parseAssignExpression)
+	p.registerInfix(token.SLASH_ASSIGN, p.parseAssignExpression)
 	p.registerInfix(token.LPAREN, p.parseCallExpression)
 	p.registerInfix(token.LBRACKET, p.parseIndexExpression)
 	return p
 }
 
 // エラーを返す
-func (p *Parser) Errors() []string {
+func (p *Parser) Errors() []*ParseError {
 	return p.errors
 }
 
-// 次に来るべきトークンが来ていないならばエラーメッセージを追加
+// 次に来るべきトークンが来ていないならばエラーを追加
 func (p *Parser) peekError(t token.TokenType) {
-	msg := fmt.Sprintf("expected next token to be %s, got %s instead",
-		t, p.peekToken.Type)
-	p.errors = append(p.errors, msg)
+	p.addError(p.peekToken, []token.TokenType{t},
+		"expected next token to be %s, got %s instead", t, p.peekToken.Type)
 }
 
 // 見るトークンを一つ進める
 func (p *Parser) nextToken() {
 	p.curToken = p.peekToken
 	p.peekToken = p.l.NextToken()
+
+	switch p.curToken.Type {
+	case token.LBRACE:
+		p.depth++
+	case token.RBRACE:
+		if p.depth > 0 { // 対応する「{」のない「}」は数えない
+			p.depth--
+		}
+	}
+
+	// 字句解析器がエラーを検出していたら、そのトークンを読んだ時点で報告する
+	if p.peekTokenIs(token.ILLEGAL) {
+		errors := p.l.Errors()
+		lexErr := errors[len(errors)-1]
+		p.addErrorAt(lexErr.Pos, p.peekToken, nil, "%s", lexErr.Message)
+	}
 }
 
 // プログラムをパースしてProgram型のASTノードを返す
@@ -113,7 +167,10 @@ func (p *Parser) ParseProgram() *ast.Program {
 		// ノードprogramのStatementsフィールドに追加する
 		stmt := p.parseStatement()
 
-		if stmt != nil {
+		// エラーを検出したら文の境界まで読み飛ばして次の文から解析を再開する
+		if p.panicking {
+			p.synchronize(0)
+		} else if stmt != nil {
 			program.Statements = append(program.Statements, stmt)
 		}
 		p.nextToken() // 調べるトークンを一つ進める
@@ -128,6 +185,14 @@ func (p *Parser) parseStatement() ast.Statement {
 		return p.parseLetStatement()
 	case token.RETURN: // RETURN文: return <expression>;
 		return p.parseReturnStatement()
+	case token.WHILE: // WHILE文: while (<condition>) <body>
+		return p.parseWhileStatement()
+	case token.FOR: // FOR文: for (<identifier> in <expression>) <body>
+		return p.parseForStatement()
+	case token.BREAK: // BREAK文: break;
+		return p.parseBreakStatement()
+	case token.CONTINUE: // CONTINUE文: continue;
+		return p.parseContinueStatement()
 	default: // その他は式文
 		return p.parseExpressionStatement()
 	}
@@ -145,7 +210,7 @@ func (p *Parser) parseLetStatement() *ast.LetStatement {
 	if !p.expectPeek(token.IDENT) { // let = 5;みたいなやつはだめ
 		return nil
 	}
-	stmt.Name = &ast.Identifier{Token: p.curToken, Value: p.curToken.Literal}
+	stmt.Name = p.parseBindingIdentifier()
 	if !p.expectPeek(token.ASSIGN) { // let x 5;みたいなやつはだめ
 		return nil
 	}
@@ -155,7 +220,12 @@ func (p *Parser) parseLetStatement() *ast.LetStatement {
 
 	stmt.Value = p.parseExpression(LOWEST)
 
-	if p.peekTokenIs(token.SEMICOLON) {
+	// 関数リテラルを束縛する場合は関数に名前を付けておく（スタックトレースなどで使う）
+	if fl, ok := stmt.Value.(*ast.FunctionLiteral); ok {
+		fl.Name = stmt.Name.Value
+	}
+
+	if !p.panicking && p.peekTokenIs(token.SEMICOLON) {
 		p.nextToken()
 	}
 
@@ -196,7 +266,117 @@ func (p *Parser) parseReturnStatement() *ast.ReturnStatement {
 	// returnに続くトークンをパースした結果得られるExpression型のASTノードをstmtのReturnValueとして追加
 	stmt.ReturnValue = p.parseExpression(LOWEST)
 
-	for !p.curTokenIs(token.SEMICOLON) {
+	if !p.panicking && p.peekTokenIs(token.SEMICOLON) {
+		p.nextToken()
+	}
+	return stmt
+}
+
+// WHILE文をパースしてWhileStatement型のASTノードを返す
+func (p *Parser) parseWhileStatement() *ast.WhileStatement {
+	// while (<condition>) <body>
+	// while (i < 10) { i += 1; }
+	stmt := &ast.WhileStatement{Token: p.curToken}
+
+	// 「(」が来るはず
+	if !p.expectPeek(token.LPAREN) {
+		return nil
+	}
+	p.nextToken()
+	stmt.Condition = p.parseExpression(LOWEST)
+
+	// 「)」と「{」が来るはず
+	if !p.expectPeek(token.RPAREN) {
+		return nil
+	}
+	if !p.expectPeek(token.LBRACE) {
+		return nil
+	}
+
+	// 本体の中でだけbreakとcontinueを使える
+	p.loopDepth++
+	stmt.Body = p.parseBlockStatement()
+	p.loopDepth--
+
+	// 末尾の「;」は省略可能
+	if !p.panicking && p.peekTokenIs(token.SEMICOLON) {
+		p.nextToken()
+	}
+	return stmt
+}
+
+// FOR文をパースしてForStatement型のASTノードを返す
+func (p *Parser) parseForStatement() *ast.ForStatement {
+	// for (<identifier> in <expression>) <body>
+	// for (<identifier>, <identifier> in <expression>) <body>
+	stmt := &ast.ForStatement{Token: p.curToken}
+
+	// 「(」とループ変数が来るはず
+	if !p.expectPeek(token.LPAREN) {
+		return nil
+	}
+	if !p.expectPeek(token.IDENT) {
+		return nil
+	}
+	stmt.Value = p.parseBindingIdentifier()
+
+	// 「,」があれば変数は二つで、一つ目がキー
+	if p.peekTokenIs(token.COMMA) {
+		p.nextToken()
+		if !p.expectPeek(token.IDENT) {
+			return nil
+		}
+		stmt.Key = stmt.Value
+		stmt.Value = p.parseBindingIdentifier()
+	}
+
+	// 「in」が来るはず
+	if !p.expectPeek(token.IN) {
+		return nil
+	}
+	p.nextToken()
+	stmt.Iterable = p.parseExpression(LOWEST)
+
+	// 「)」と「{」が来るはず
+	if !p.expectPeek(token.RPAREN) {
+		return nil
+	}
+	if !p.expectPeek(token.LBRACE) {
+		return nil
+	}
+
+	p.loopDepth++
+	stmt.Body = p.parseBlockStatement()
+	p.loopDepth--
+
+	// 末尾の「;」は省略可能
+	if !p.panicking && p.peekTokenIs(token.SEMICOLON) {
+		p.nextToken()
+	}
+	return stmt
+}
+
+// BREAK文をパースしてBreakStatement型のASTノードを返す
+func (p *Parser) parseBreakStatement() *ast.BreakStatement {
+	stmt := &ast.BreakStatement{Token: p.curToken}
+	if p.loopDepth == 0 {
+		p.addError(p.curToken, nil, "break outside loop")
+		return nil
+	}
+	if p.peekTokenIs(token.SEMICOLON) {
+		p.nextToken()
+	}
+	return stmt
+}
+
+// CONTINUE文をパースしてContinueStatement型のASTノードを返す
+func (p *Parser) parseContinueStatement() *ast.ContinueStatement {
+	stmt := &ast.ContinueStatement{Token: p.curToken}
+	if p.loopDepth == 0 {
+		p.addError(p.curToken, nil, "continue outside loop")
+		return nil
+	}
+	if p.peekTokenIs(token.SEMICOLON) {
 		p.nextToken()
 	}
 	return stmt
@@ -229,7 +409,7 @@ func (p *Parser) parseExpressionStatement() *ast.ExpressionStatement {
 
 	stmt.Expression = p.parseExpression(LOWEST)
 
-	if p.peekTokenIs(token.SEMICOLON) {
+	if !p.panicking && p.peekTokenIs(token.SEMICOLON) {
 		p.nextToken()
 	}
 
@@ -252,6 +432,11 @@ func (p *Parser) parseExpression(precedence int) ast.Expression {
 	// 現在見ているトークンを解析する
 	leftExp := prefix()
 
+	// エラーを検出していたらそれ以上トークンを読み進めない
+	if p.panicking {
+		return leftExp
+	}
+
 	// 現在見ているトークンの右結合力（precedence）と左結合力（peekPrecedence()）を確認
 	// 左結合力が高いということは1つネストするということになる
 	for !p.peekTokenIs(token.SEMICOLON) && precedence < p.peekPrecedence() {
@@ -265,6 +450,12 @@ func (p *Parser) parseExpression(precedence int) ast.Expression {
 	return leftExp
 }
 
+// ILLEGALトークンは読んだ時点でエラーを報告済みなので、新たなエラーは追加せずに読み飛ばしに入る
+func (p *Parser) parseIllegal() ast.Expression {
+	p.panicking = true
+	return nil
+}
+
 // 識別子をパースしてExpression型のASTノードを返す
 func (p *Parser) parseIdentifier() ast.Expression {
 	return &ast.Identifier{Token: p.curToken, Value: p.curToken.Literal}
@@ -280,11 +471,9 @@ func (p *Parser) parseIntegerLiteral() ast.Expression {
 	// 今見ているトークンのリテラルが整数リテラルであることを確認する
 	value, err := strconv.ParseInt(p.curToken.Literal, 0, 64)
 
-	// 整数リテラルでなければエラーメッセージをパーサ内に記録したのちnilのExpression型ASTノードを返す
+	// 整数リテラルでなければエラーをパーサ内に記録したのちnilのExpression型ASTノードを返す
 	if err != nil {
-		msg := fmt.Sprintf("could not parse %q as integer",
-			p.curToken.Literal)
-		p.errors = append(p.errors, msg)
+		p.addError(p.curToken, nil, "could not parse %q as integer", p.curToken.Literal)
 		return nil
 	}
 
@@ -293,10 +482,21 @@ func (p *Parser) parseIntegerLiteral() ast.Expression {
 	return lit
 }
 
-// 該当する前置演算子トークンに対してそれをパースする関数が紐づけられていなかった時にエラーメッセージを出力するヘルパー関数
+// 浮動小数点数リテラルをパースしてExpression型のASTノードを返す
+func (p *Parser) parseFloatLiteral() ast.Expression {
+	lit := &ast.FloatLiteral{Token: p.curToken}
+	value, err := strconv.ParseFloat(p.curToken.Literal, 64)
+	if err != nil {
+		p.addError(p.curToken, nil, "could not parse %q as float", p.curToken.Literal)
+		return nil
+	}
+	lit.Value = value
+	return lit
+}
+
+// 該当する前置演算子トークンに対してそれをパースする関数が紐づけられていなかった時にエラーを記録するヘルパー関数
 func (p *Parser) noPrefixParseFnError(t token.TokenType) {
-	msg := fmt.Sprintf("no prefix parse function for %s found", t)
-	p.errors = append(p.errors, msg)
+	p.addError(p.curToken, nil, "no prefix parse function for %s found", t)
 }
 
 // 前置演算子トークンをパースしてExpression型のASTノードを返す
@@ -318,6 +518,29 @@ func (p *Parser) parsePrefixExpression() ast.Expression {
 	return expression
 }
 
+// 代入演算子をパースしてAssignExpression型のASTノードを返す
+// 代入は右結合（a = b = 1はa = (b = 1)）
+func (p *Parser) parseAssignExpression(left ast.Expression) ast.Expression {
+	expression := &ast.AssignExpression{
+		Token:    p.curToken,
+		Operator: p.curToken.Literal,
+		Target:   left,
+	}
+
+	// 代入できるのは変数と添字式だけ
+	switch left.(type) {
+	case *ast.Identifier, *ast.IndexExpression:
+	default:
+		p.addErrorAt(left.Pos(), p.curToken, nil, "cannot assign to %s", left.String())
+		return nil
+	}
+
+	// 右結合にするため自身より一つ低い優先順位で右辺をパースする
+	p.nextToken()
+	expression.Value = p.parseExpression(ASSIGN - 1)
+	return expression
+}
+
 // 次に見るべきトークンの優先順位を返すヘルパー関数
 func (p *Parser) peekPrecedence() int {
 	if p, ok := precedences[p.peekToken.Type]; ok {
@@ -366,13 +589,38 @@ func (p *Parser) parseBoolean() ast.Expression {
 }
 
 // 丸括弧でまとめられたトークンをパースしてExpression型のASTノードを返す
+// 括弧の中にコンマがあればタプルリテラルとしてパースする
+// (x)は括弧の式、(x,)と(x, y)と()はタプル
 func (p *Parser) parseGroupedExpression() ast.Expression {
+	tuple := &ast.TupleLiteral{Token: p.curToken, Elements: []ast.Expression{}}
+	if p.peekTokenIs(token.RPAREN) {
+		p.nextToken()
+		return tuple
+	}
+
 	p.nextToken()
 	exp := p.parseExpression(LOWEST)
+	if !p.peekTokenIs(token.COMMA) {
+		if !p.expectPeek(token.RPAREN) {
+			return nil
+		}
+		return exp
+	}
+
+	tuple.Elements = append(tuple.Elements, exp)
+	for p.peekTokenIs(token.COMMA) {
+		p.nextToken()
+		// 最後の要素の後ろのコンマは読み飛ばす
+		if p.peekTokenIs(token.RPAREN) {
+			break
+		}
+		p.nextToken()
+		tuple.Elements = append(tuple.Elements, p.parseExpression(LOWEST))
+	}
 	if !p.expectPeek(token.RPAREN) {
 		return nil
 	}
-	return exp
+	return tuple
 }
 
 // IF式をパースしてExpression型のASTノードを返す
@@ -430,17 +678,29 @@ func (p *Parser) parseBlockStatement() *ast.BlockStatement {
 	// BlockStatement型のASTノードを生成
 	block := &ast.BlockStatement{Token: p.curToken}
 	block.Statements = []ast.Statement{}
+	depth := p.depth // ブロックの中の文の深さ
 
 	p.nextToken()
 
 	// 「}」かEOFに到達するまでに遭遇する文をパースしながらblockのStatementフィールドにその結果のASTを追加していく
 	for !p.curTokenIs(token.RBRACE) && !p.curTokenIs(token.EOF) {
 		stmt := p.parseStatement()
-		if stmt != nil {
+		if p.panicking {
+			p.synchronize(depth)
+			if p.depth < depth { // 読み飛ばした結果ブロックの終わりに到達した
+				break
+			}
+		} else if stmt != nil {
 			block.Statements = append(block.Statements, stmt)
 		}
 		p.nextToken()
 	}
+
+	// 「}」が来ないまま入力が終わった
+	if p.curTokenIs(token.EOF) {
+		p.addError(p.curToken, []token.TokenType{token.RBRACE},
+			"expected next token to be %s, got %s instead", token.RBRACE, token.EOF)
+	}
 	return block
 }
 
@@ -466,7 +726,12 @@ func (p *Parser) parseFunctionLiteral() ast.Expression {
 	}
 
 	// block statementである関数の本体をパースして得られるASTをFunctionLiteral型のASTノードlitのBodyフィールドに登録
+	// 関数の外側のループに対してbreakやcontinueはできない
+	outerLoopDepth := p.loopDepth
+	p.loopDepth = 0
 	lit.Body = p.parseBlockStatement()
+	p.loopDepth = outerLoopDepth
+	markTailCalls(lit.Body)
 
 	return lit
 }
@@ -486,7 +751,7 @@ func (p *Parser) parseFunctionParameters() []*ast.Identifier {
 	p.nextToken()
 
 	// 一つ目の識別子に遭遇
-	ident := &ast.Identifier{Token: p.curToken, Value: p.curToken.Literal}
+	ident := p.parseBindingIdentifier()
 
 	// Identifier型のASTノードを生成したので追加
 	identifiers = append(identifiers, ident)
@@ -495,7 +760,7 @@ func (p *Parser) parseFunctionParameters() []*ast.Identifier {
 	for p.peekTokenIs(token.COMMA) {
 		p.nextToken()
 		p.nextToken()
-		ident := &ast.Identifier{Token: p.curToken, Value: p.curToken.Literal}
+		ident := p.parseBindingIdentifier()
 		identifiers = append(identifiers, ident)
 	}
 
@@ -507,6 +772,16 @@ func (p *Parser) parseFunctionParameters() []*ast.Identifier {
 	return identifiers
 }
 
+// 変数を束縛する識別子（letの名前、関数の引数、forのループ変数）をパースするヘルパー関数
+// "strings.upper"のような名前空間付きの名前は組み込み関数を参照するためだけのもので、束縛には使えない
+// エラーを記録したうえで識別子は返し、後続のパースは続ける
+func (p *Parser) parseBindingIdentifier() *ast.Identifier {
+	if strings.Contains(p.curToken.Literal, ".") {
+		p.addError(p.curToken, nil, "qualified name %s cannot be bound", p.curToken.Literal)
+	}
+	return &ast.Identifier{Token: p.curToken, Value: p.curToken.Literal}
+}
+
 // 関数呼び出し式をパースしてExpression型のASTノードを返す
 func (p *Parser) parseCallExpression(function ast.Expression) ast.Expression {
 
@@ -617,6 +892,7 @@ func (p *Parser) parseHashLiteral() ast.Expression {
 		p.nextToken()
 		value := p.parseExpression(LOWEST)
 		hash.Pairs[key] = value
+		hash.Keys = append(hash.Keys, key)
 		if !p.peekTokenIs(token.RBRACE) && !p.expectPeek(token.COMMA) {
 			return nil
 		}
@@ -4,6 +4,7 @@ import (
 	"fmt"
 	"monkey/ast"
 	"monkey/lexer"
+	"monkey/token"
 	"testing"
 )
 
@@ -255,6 +256,42 @@ func TestIntegerLiteralExpression(t *testing.T) {
 	}
 }
 
+// 浮動小数点数リテラルのASTノードのパースをテスト
+func TestFloatLiteralExpression(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected float64
+	}{
+		{"1.5;", 1.5},
+		{"1e-9;", 1e-9},
+		{"2.5E+3;", 2500},
+	}
+
+	for _, tt := range tests {
+		l := lexer.New(tt.input)
+		p := New(l)
+		program := p.ParseProgram()
+		checkParserErrors(t, p)
+
+		if len(program.Statements) != 1 {
+			t.Fatalf("program has not ecnough statements. got=%d",
+				len(program.Statements))
+		}
+		stmt, ok := program.Statements[0].(*ast.ExpressionStatement)
+		if !ok {
+			t.Fatalf("program.Statements[0] is not ast.ExpressionStatement. got=%T",
+				program.Statements[0])
+		}
+		literal, ok := stmt.Expression.(*ast.FloatLiteral)
+		if !ok {
+			t.Fatalf("exp not *ast.FloatLiteral. got=%T", stmt.Expression)
+		}
+		if literal.Value != tt.expected {
+			t.Errorf("literal.Value not %g. got=%g", tt.expected, literal.Value)
+		}
+	}
+}
+
 // 前置演算子式のASTノードのパースをテスト
 func TestParsingPrefixExpressions(t *testing.T) {
 
@@ -380,6 +417,16 @@ func TestParsingInfixExpression(t *testing.T) {
 		{"5 < 5", 5, "<", 5},
 		{"5 == 5", 5, "==", 5},
 		{"5 != 5", 5, "!=", 5},
+		{"5 <= 5", 5, "<=", 5},
+		{"5 >= 5", 5, ">=", 5},
+		{"5 % 5", 5, "%", 5},
+		{"5 & 5", 5, "&", 5},
+		{"5 | 5", 5, "|", 5},
+		{"5 ^ 5", 5, "^", 5},
+		{"5 << 5", 5, "<<", 5},
+		{"5 >> 5", 5, ">>", 5},
+		{"true && false", true, "&&", false},
+		{"true || false", true, "||", false},
 		{"true == true", true, "==", true},
 		{"true != false", true, "!=", false},
 		{"false == false", false, "==", false},
@@ -420,6 +467,271 @@ func TestParsingInfixExpression(t *testing.T) {
 	}
 }
 
+// WHILE文のパースのテスト
+func TestWhileStatement(t *testing.T) {
+	input := `while (x < 10) { if (x == 5) { break; } x += 1; continue; }`
+
+	l := lexer.New(input)
+	p := New(l)
+	program := p.ParseProgram()
+	checkParserErrors(t, p)
+
+	if len(program.Statements) != 1 {
+		t.Fatalf("program has not ecnough statements. got=%d", len(program.Statements))
+	}
+	stmt, ok := program.Statements[0].(*ast.WhileStatement)
+	if !ok {
+		t.Fatalf("program.Statements[0] is not ast.WhileStatement. got=%T", program.Statements[0])
+	}
+	if !testInfixExpression(t, stmt.Condition, "x", "<", 10) {
+		return
+	}
+	if len(stmt.Body.Statements) != 3 {
+		t.Fatalf("body has wrong number of statements. got=%d", len(stmt.Body.Statements))
+	}
+	ifExp, ok := stmt.Body.Statements[0].(*ast.ExpressionStatement).Expression.(*ast.IfExpression)
+	if !ok {
+		t.Fatalf("body.Statements[0] is not ast.IfExpression. got=%T", stmt.Body.Statements[0])
+	}
+	if _, ok := ifExp.Consequence.Statements[0].(*ast.BreakStatement); !ok {
+		t.Errorf("consequence is not ast.BreakStatement. got=%T", ifExp.Consequence.Statements[0])
+	}
+	if _, ok := stmt.Body.Statements[2].(*ast.ContinueStatement); !ok {
+		t.Errorf("body.Statements[2] is not ast.ContinueStatement. got=%T", stmt.Body.Statements[2])
+	}
+
+	// 末尾の「;」は省略可能
+	p = New(lexer.New("while (i < 3) { i += 1 };\nlet x = 1;"))
+	program = p.ParseProgram()
+	checkParserErrors(t, p)
+	if len(program.Statements) != 2 {
+		t.Fatalf("program has wrong number of statements. got=%d", len(program.Statements))
+	}
+}
+
+// FOR文のパースのテスト
+func TestForStatement(t *testing.T) {
+	tests := []struct {
+		input         string
+		expectedKey   string
+		expectedValue string
+		expected      string
+	}{
+		{"for (x in xs) { puts(x); }", "", "x", "for (x in xs) \n\tputs(x)\n"},
+		{"for (k, v in h) { continue; }", "k", "v", "for (k, v in h) \n\tcontinue;\n"},
+		{"for (i in range(0, 10, 2)) { if (i > 4) { break; } }", "", "i", "for (i in range(0, 10, 2)) \n\tifi > 4 \n\tbreak;\n\n"},
+		// 末尾の「;」は省略可能
+		{"for (x in [1, 2]) { x };", "", "x", "for (x in [1, 2]) \n\tx\n"},
+	}
+
+	for _, tt := range tests {
+		l := lexer.New(tt.input)
+		p := New(l)
+		program := p.ParseProgram()
+		checkParserErrors(t, p)
+
+		if len(program.Statements) != 1 {
+			t.Fatalf("program has not ecnough statements. got=%d", len(program.Statements))
+		}
+		stmt, ok := program.Statements[0].(*ast.ForStatement)
+		if !ok {
+			t.Fatalf("program.Statements[0] is not ast.ForStatement. got=%T", program.Statements[0])
+		}
+		if tt.expectedKey == "" {
+			if stmt.Key != nil {
+				t.Errorf("stmt.Key is not nil. got=%q", stmt.Key)
+			}
+		} else if !testIdentifier(t, stmt.Key, tt.expectedKey) {
+			return
+		}
+		if !testIdentifier(t, stmt.Value, tt.expectedValue) {
+			return
+		}
+		if stmt.String() != tt.expected {
+			t.Errorf("expected=%q, got=%q", tt.expected, stmt.String())
+		}
+	}
+}
+
+// 末尾位置の呼び出しに印が付くことのテスト
+func TestTailCallMarking(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected []string // 末尾位置にある呼び出しの関数部分
+	}{
+		{"fn() { t() }", []string{"t"}},
+		{"fn() { a(); t(); }", []string{"t"}},
+		{"fn() { return t(a()); }", []string{"t"}},
+		{"fn() { if (x) { t() } else { a(); u() } }", []string{"t", "u"}},
+		{"fn() { if (x) { return t(); } a(); u() }", []string{"t", "u"}},
+		{"fn() { while (x) { a(); if (y) { return t(); } } u() }", []string{"t", "u"}},
+		{"fn() { for (x in xs) { a() } let y = b(); t() }", []string{"t"}},
+		{"fn() { a() + 1 }", []string{}},
+		{"fn() { a()(b()) }", []string{"a()"}},
+		{"a()", []string{}},
+	}
+
+	for _, tt := range tests {
+		l := lexer.New(tt.input)
+		p := New(l)
+		program := p.ParseProgram()
+		checkParserErrors(t, p)
+
+		tails := []string{}
+		for _, call := range collectCalls(program) {
+			if call.Tail {
+				tails = append(tails, call.Function.String())
+			}
+		}
+		if fmt.Sprint(tails) != fmt.Sprint(tt.expected) {
+			t.Errorf("%q: wrong tail calls. expected=%v, got=%v", tt.input, tt.expected, tails)
+		}
+	}
+}
+
+// ASTに含まれる関数呼び出しを集めるヘルパー関数
+func collectCalls(node ast.Node) []*ast.CallExpression {
+	calls := []*ast.CallExpression{}
+	var walk func(node ast.Node)
+	walk = func(node ast.Node) {
+		switch node := node.(type) {
+		case *ast.Program:
+			for _, s := range node.Statements {
+				walk(s)
+			}
+		case *ast.BlockStatement:
+			for _, s := range node.Statements {
+				walk(s)
+			}
+		case *ast.ExpressionStatement:
+			walk(node.Expression)
+		case *ast.ReturnStatement:
+			walk(node.ReturnValue)
+		case *ast.LetStatement:
+			walk(node.Value)
+		case *ast.WhileStatement:
+			walk(node.Body)
+		case *ast.ForStatement:
+			walk(node.Body)
+		case *ast.InfixExpression:
+			walk(node.Left)
+			walk(node.Right)
+		case *ast.IfExpression:
+			walk(node.Consequence)
+			if node.Alternative != nil {
+				walk(node.Alternative)
+			}
+		case *ast.FunctionLiteral:
+			walk(node.Body)
+		case *ast.CallExpression:
+			calls = append(calls, node)
+			walk(node.Function)
+			for _, a := range node.Arguments {
+				walk(a)
+			}
+		}
+	}
+	walk(node)
+	return calls
+}
+
+// 代入式のパースのテスト
+func TestAssignExpressions(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{"x = 5;", "x = 5"},
+		{"x += 1;", "x += 1"},
+		{"x -= y * 2;", "x -= y * 2"},
+		{"x *= 2;", "x *= 2"},
+		{"x /= 2;", "x /= 2"},
+		{"arr[0] = 1;", "(arr[0]) = 1"},
+		{`h["k"] += 1;`, "(h[k]) += 1"},
+		{"a = b = 1;", "a = b = 1"},
+	}
+
+	for _, tt := range tests {
+		l := lexer.New(tt.input)
+		p := New(l)
+		program := p.ParseProgram()
+		checkParserErrors(t, p)
+
+		if len(program.Statements) != 1 {
+			t.Fatalf("program has not ecnough statements. got=%d", len(program.Statements))
+		}
+		stmt, ok := program.Statements[0].(*ast.ExpressionStatement)
+		if !ok {
+			t.Fatalf("program.Statements[0] is not ast.ExpressionStatement. got=%T",
+				program.Statements[0])
+		}
+		assign, ok := stmt.Expression.(*ast.AssignExpression)
+		if !ok {
+			t.Fatalf("exp not *ast.AssignExpression. got=%T", stmt.Expression)
+		}
+		if assign.String() != tt.expected {
+			t.Errorf("expected=%q, got=%q", tt.expected, assign.String())
+		}
+	}
+
+	// 代入は右結合
+	program := New(lexer.New("a = b += 1")).ParseProgram()
+	outer := program.Statements[0].(*ast.ExpressionStatement).Expression.(*ast.AssignExpression)
+	inner, ok := outer.Value.(*ast.AssignExpression)
+	if !ok {
+		t.Fatalf("assignment is not right associative. got=%T", outer.Value)
+	}
+	if outer.Operator != "=" || inner.Operator != "+=" || inner.BinaryOperator() != "+" {
+		t.Errorf("wrong operators. got=%q and %q", outer.Operator, inner.Operator)
+	}
+}
+
+// 論理演算子・ビット演算子の優先順位のテスト（Goと同じ）
+func TestLogicalAndBitwisePrecedence(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{"a || b && c", "(a || (b && c))"},
+		{"a && b || c", "((a && b) || c)"},
+		{"a == b && c != d", "((a == b) && (c != d))"},
+		{"a < b || c >= d", "((a < b) || (c >= d))"},
+		{"a <= b == c > d", "((a <= b) == (c > d))"},
+		{"a + b < c % d", "((a + b) < (c % d))"},
+		{"a | b & c", "(a | (b & c))"},
+		{"a ^ b << c", "(a ^ (b << c))"},
+		{"a + b >> c", "(a + (b >> c))"},
+		{"a * b % c", "((a * b) % c)"},
+		{"a & b == c", "((a & b) == c)"},
+		{"-a % b", "((-a) % b)"},
+	}
+
+	for _, tt := range tests {
+		l := lexer.New(tt.input)
+		p := New(l)
+		program := p.ParseProgram()
+		checkParserErrors(t, p)
+
+		stmt := program.Statements[0].(*ast.ExpressionStatement)
+		actual := parenthesize(stmt.Expression)
+		if actual != tt.expected {
+			t.Errorf("wrong precedence. expected=%q, got=%q", tt.expected, actual)
+		}
+	}
+}
+
+// 中置式と前置式をすべて括弧で囲んだ文字列にするヘルパー関数
+func parenthesize(exp ast.Expression) string {
+	switch exp := exp.(type) {
+	case *ast.InfixExpression:
+		return "(" + parenthesize(exp.Left) + " " + exp.Operator + " " + parenthesize(exp.Right) + ")"
+	case *ast.PrefixExpression:
+		return "(" + exp.Operator + parenthesize(exp.Right) + ")"
+	default:
+		return exp.String()
+	}
+}
+
 func TestOperatorPrecedenceParsing(t *testing.T) {
 	tests := []struct {
 		input    string
@@ -1099,6 +1411,48 @@ func TestParsingArrayLiterals(t *testing.T) {
 	testInfixExpression(t, array.Elements[2], 3, "+", 3)
 }
 
+// タプルリテラルを正しくパースできるかをテスト
+func TestParsingTupleLiterals(t *testing.T) {
+	tests := []struct {
+		input       string
+		numElements int
+		expected    string // ASTのString()
+	}{
+		{"()", 0, "()"},
+		{"(1,)", 1, "(1,)"},
+		{"(1, 2 * 2)", 2, "(1, 2 * 2)"},
+		{"(1, 2 * 2,)", 2, "(1, 2 * 2)"},
+		{"((1, 2), [3])", 2, "((1, 2), [3])"},
+	}
+
+	for _, tt := range tests {
+		l := lexer.New(tt.input)
+		p := New(l)
+		program := p.ParseProgram()
+		checkParserErrors(t, p)
+
+		stmt := program.Statements[0].(*ast.ExpressionStatement)
+		tuple, ok := stmt.Expression.(*ast.TupleLiteral)
+		if !ok {
+			t.Fatalf("exp not *ast.TupleLiteral. got=%T", stmt.Expression)
+		}
+		if len(tuple.Elements) != tt.numElements {
+			t.Errorf("len(tuple.Elements) not %d. got=%d", tt.numElements, len(tuple.Elements))
+		}
+		if tuple.String() != tt.expected {
+			t.Errorf("tuple.String() wrong. want=%q, got=%q", tt.expected, tuple.String())
+		}
+	}
+
+	// コンマのない括弧はタプルにならない
+	l := lexer.New("(1)")
+	p := New(l)
+	program := p.ParseProgram()
+	checkParserErrors(t, p)
+	stmt := program.Statements[0].(*ast.ExpressionStatement)
+	testIntegerLiteral(t, stmt.Expression, 1)
+}
+
 // IndexExpressionを正しくパースできるかをテスト
 func TestParsingIndexExpression(t *testing.T) {
 	input := "myArray[1 + 1]"
@@ -1253,3 +1607,187 @@ func TestParsingHashLiteralsWithExpressions(t *testing.T) {
 		testFunc(value)
 	}
 }
+
+// ASTノードが位置情報を保持していることをテストする
+func TestNodePositions(t *testing.T) {
+	input := `let x = 5;
+x + add(1, 2);
+xs[0];`
+
+	l := lexer.NewWithFilename("pos.mk", input)
+	p := New(l)
+	program := p.ParseProgram()
+	checkParserErrors(t, p)
+
+	letStmt := program.Statements[0].(*ast.LetStatement)
+	exprStmt := program.Statements[1].(*ast.ExpressionStatement)
+	infix := exprStmt.Expression.(*ast.InfixExpression)
+	call := infix.Right.(*ast.CallExpression)
+	index := program.Statements[2].(*ast.ExpressionStatement).Expression.(*ast.IndexExpression)
+
+	tests := []struct {
+		node     ast.Node
+		expected string
+	}{
+		{program, "pos.mk:1:1"},
+		{letStmt, "pos.mk:1:1"},
+		{letStmt.Name, "pos.mk:1:5"},
+		{letStmt.Value, "pos.mk:1:9"},
+		// 中置式・呼び出し・添字式の位置は演算子や括弧ではなく先頭のトークンの位置
+		{infix, "pos.mk:2:1"},
+		{infix.Left, "pos.mk:2:1"},
+		{call, "pos.mk:2:5"},
+		{call.Arguments[1], "pos.mk:2:12"},
+		{index, "pos.mk:3:1"},
+	}
+	for i, tt := range tests {
+		if got := tt.node.Pos().String(); got != tt.expected {
+			t.Errorf("tests[%d] - position wrong. expected=%q, got=%q", i, tt.expected, got)
+		}
+	}
+}
+
+// 構文エラーの位置・期待トークン・復帰をテストする
+func TestParseErrors(t *testing.T) {
+	tests := []struct {
+		input            string
+		expectedMessages []string
+		expectedExpected [][]token.TokenType
+		expectedFound    []token.TokenType
+	}{
+		{
+			"let = 5;",
+			[]string{"1:5: expected next token to be IDENT, got = instead"},
+			[][]token.TokenType{{token.IDENT}},
+			[]token.TokenType{token.ASSIGN},
+		},
+		{
+			"add(1, 2;\nlet y = 10;",
+			[]string{"1:9: expected next token to be ), got ; instead"},
+			[][]token.TokenType{{token.RPAREN}},
+			[]token.TokenType{token.SEMICOLON},
+		},
+		{
+			// 一つの誤りから連鎖的にエラーが発生しない
+			"let x = 5 * ;\nlet y = ) + );\nlet z = 1;",
+			[]string{
+				"1:13: no prefix parse function for ; found",
+				"2:9: no prefix parse function for ) found",
+			},
+			[][]token.TokenType{nil, nil},
+			[]token.TokenType{token.SEMICOLON, token.RPAREN},
+		},
+		{
+			"let f = fn(x) {\n  x +\n};\nf(1);",
+			[]string{"3:1: no prefix parse function for } found"},
+			[][]token.TokenType{nil},
+			[]token.TokenType{token.RBRACE},
+		},
+		{
+			// 条件式のエラーから復帰するときは、ifの後ろのブロックを丸ごと読み飛ばす
+			"if (x > ) { 1 } else { 2 }; let z = 3;",
+			[]string{"1:9: no prefix parse function for ) found"},
+			[][]token.TokenType{nil},
+			[]token.TokenType{token.RPAREN},
+		},
+		{
+			// ハッシュを閉じる「}」で関数の本体を抜けない
+			"let f = fn() { let h = {1: }; let y = 2; };\nlet z = 1;",
+			[]string{"1:28: no prefix parse function for } found"},
+			[][]token.TokenType{nil},
+			[]token.TokenType{token.RBRACE},
+		},
+		{
+			"if (x) { x",
+			[]string{"1:11: expected next token to be }, got EOF instead"},
+			[][]token.TokenType{{token.RBRACE}},
+			[]token.TokenType{token.EOF},
+		},
+		{
+			// 字句解析エラーはそのまま構文エラーとして報告される
+			"let x = 1 @ 2;\nlet y = 2;",
+			[]string{"1:11: unexpected character '@'"},
+			[][]token.TokenType{nil},
+			[]token.TokenType{token.ILLEGAL},
+		},
+		{
+			"let x = 1; /* 閉じない",
+			[]string{"1:12: unterminated block comment"},
+			[][]token.TokenType{nil},
+			[]token.TokenType{token.ILLEGAL},
+		},
+		{
+			// 名前空間付きの名前は組み込み関数を参照するためだけのもの
+			"let x.y = 1;\nlet z = 2;",
+			[]string{"1:5: qualified name x.y cannot be bound"},
+			[][]token.TokenType{nil},
+			[]token.TokenType{token.IDENT},
+		},
+		{
+			"let f = fn(a, b.c) { b.c };",
+			[]string{"1:15: qualified name b.c cannot be bound"},
+			[][]token.TokenType{nil},
+			[]token.TokenType{token.IDENT},
+		},
+		{
+			"for (k, s.v in [1]) { }",
+			[]string{"1:9: qualified name s.v cannot be bound"},
+			[][]token.TokenType{nil},
+			[]token.TokenType{token.IDENT},
+		},
+		{
+			"5 = x;\nlet y = 1;",
+			[]string{"1:1: cannot assign to 5"},
+			[][]token.TokenType{nil},
+			[]token.TokenType{token.ASSIGN},
+		},
+		{
+			"break;\nlet y = 1;",
+			[]string{"1:1: break outside loop"},
+			[][]token.TokenType{nil},
+			[]token.TokenType{token.BREAK},
+		},
+		{
+			// 関数の外側のループに対してcontinueはできない
+			"while (true) { fn() { continue; } }",
+			[]string{"1:23: continue outside loop"},
+			[][]token.TokenType{nil},
+			[]token.TokenType{token.CONTINUE},
+		},
+		{
+			"return 99999999999999999999;",
+			[]string{`1:8: could not parse "99999999999999999999" as integer`},
+			[][]token.TokenType{nil},
+			[]token.TokenType{token.INT},
+		},
+	}
+
+	for i, tt := range tests {
+		l := lexer.New(tt.input)
+		p := New(l)
+		p.ParseProgram()
+
+		errors := p.Errors()
+		if len(errors) != len(tt.expectedMessages) {
+			for _, err := range errors {
+				t.Errorf("tests[%d] - parser error: %q", i, err)
+			}
+			t.Fatalf("tests[%d] - wrong number of errors. expected=%d, got=%d",
+				i, len(tt.expectedMessages), len(errors))
+		}
+		for j, err := range errors {
+			if err.Error() != tt.expectedMessages[j] {
+				t.Errorf("tests[%d] - wrong error message. expected=%q, got=%q",
+					i, tt.expectedMessages[j], err.Error())
+			}
+			if fmt.Sprint(err.Expected) != fmt.Sprint(tt.expectedExpected[j]) {
+				t.Errorf("tests[%d] - wrong expected tokens. expected=%v, got=%v",
+					i, tt.expectedExpected[j], err.Expected)
+			}
+			if err.Found.Type != tt.expectedFound[j] {
+				t.Errorf("tests[%d] - wrong found token. expected=%q, got=%q",
+					i, tt.expectedFound[j], err.Found.Type)
+			}
+		}
+	}
+}
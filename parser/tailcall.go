@@ -0,0 +1,48 @@
+package parser
+
+import "monkey/ast"
+
+// 関数本体の中で末尾位置にある呼び出しにTailの印を付ける
+// 末尾位置とは、呼び出しの結果がそのまま関数の戻り値になる位置のこと
+//   - return文の値
+//   - 本体の最後の式文（if式ならその各分岐の最後の式文）
+//
+// ループの本体の最後の式文は末尾位置ではないが、その中のreturn文の値は末尾位置になる
+func markTailCalls(body *ast.BlockStatement) {
+	markTailCallsInBlock(body, true)
+}
+
+// tailはブロックの値が関数の戻り値になるか
+func markTailCallsInBlock(block *ast.BlockStatement, tail bool) {
+	if block == nil {
+		return
+	}
+	for i, stmt := range block.Statements {
+		markTailCallsInStatement(stmt, tail && i == len(block.Statements)-1)
+	}
+}
+
+func markTailCallsInStatement(stmt ast.Statement, tail bool) {
+	switch stmt := stmt.(type) {
+	case *ast.ReturnStatement:
+		markTailCallsInExpression(stmt.ReturnValue, true)
+	case *ast.ExpressionStatement:
+		markTailCallsInExpression(stmt.Expression, tail)
+	case *ast.LetStatement:
+		markTailCallsInExpression(stmt.Value, false)
+	case *ast.WhileStatement:
+		markTailCallsInBlock(stmt.Body, false)
+	case *ast.ForStatement:
+		markTailCallsInBlock(stmt.Body, false)
+	}
+}
+
+func markTailCallsInExpression(exp ast.Expression, tail bool) {
+	switch exp := exp.(type) {
+	case *ast.CallExpression:
+		exp.Tail = tail
+	case *ast.IfExpression:
+		markTailCallsInBlock(exp.Consequence, tail)
+		markTailCallsInBlock(exp.Alternative, tail)
+	}
+}
@@ -11,6 +11,7 @@ import (
 	// "monkey/object"
 	"monkey/parser"
 	"monkey/vm"
+	"strings"
 )
 
 const PROMPT = ">> "
@@ -34,9 +35,7 @@ func Start(in io.Reader, out io.Writer) {
 	constants := []object.Object{}
 	globals := make([]object.Object, vm.GlobalsSize)
 	symbolTable := compiler.NewSymbolTable()
-	for i, v := range object.Builtins {
-		symbolTable.DefineBuiltin(i, v.Name)
-	}
+	symbolTable.DefineBuiltins(object.NewStandardRegistry())
 
 	for {
 
@@ -69,7 +68,7 @@ func Start(in io.Reader, out io.Writer) {
 
 		// パース中のエラーを出力
 		if len(p.Errors()) != 0 {
-			printParserErrors(out, p.Errors())
+			printParserErrors(out, line, p.Errors())
 			continue
 		}
 
@@ -100,6 +99,11 @@ func Start(in io.Reader, out io.Writer) {
 		if err != nil {
 			io.WriteString(out, MONKEY)
 			fmt.Fprintf(out, "Woops! Executing bytecode failed:\n\t%s\n", err)
+			if rerr, ok := err.(*vm.RuntimeError); ok {
+				for _, f := range rerr.StackTrace {
+					fmt.Fprintf(out, "\t\tat %s\n", f)
+				}
+			}
 			continue
 		}
 		stackTop := machine.LastPoppedStackElem()
@@ -109,11 +113,38 @@ func Start(in io.Reader, out io.Writer) {
 }
 
 // パース中のエラーを出力するヘルパー関数
-func printParserErrors(out io.Writer, errors []string) {
+// エラー箇所の行を表示して、その下の該当する列に「^」を付ける
+func printParserErrors(out io.Writer, input string, errors []*parser.ParseError) {
 	io.WriteString(out, MONKEY)
 	io.WriteString(out, "Woops! We ran into some monkey business here!\n")
 	io.WriteString(out, " parser errors:\n")
-	for _, msg := range errors {
-		io.WriteString(out, "\t"+msg+"\n")
+	lines := strings.Split(input, "\n")
+	for _, err := range errors {
+		io.WriteString(out, "\t"+err.Error()+"\n")
+		if err.Pos.Line < 1 || err.Pos.Line > len(lines) {
+			continue
+		}
+		io.WriteString(out, "\t\t"+lines[err.Pos.Line-1]+"\n")
+		io.WriteString(out, "\t\t"+caretLine(lines[err.Pos.Line-1], err.Pos.Column)+"\n")
+	}
+}
+
+// column列目の下に「^」を置くための行を返すヘルパー関数
+// タブはそのまま残して、表示上の位置がずれないようにする
+func caretLine(line string, column int) string {
+	var out strings.Builder
+	col := 1
+	for _, r := range line {
+		if col >= column {
+			break
+		}
+		if r == '\t' {
+			out.WriteRune('\t')
+		} else {
+			out.WriteRune(' ')
+		}
+		col++
 	}
+	out.WriteString("^")
+	return out.String()
 }
@@ -1,9 +1,41 @@
 package token
 
+import "fmt"
+
 type TokenType string
 type Token struct {
-	Type    TokenType
-	Literal string
+	Type     TokenType
+	Literal  string
+	Pos      Position // トークンの先頭文字のソース上の位置
+	Comments []string // トークンの直前に書かれていたコメント（フォーマッタなどが使うためのもの）
+}
+
+// ソースコード上の位置
+type Position struct {
+	Filename string // ファイル名（無ければ空）
+	Offset   int    // 先頭からのバイトオフセット（0始まり）
+	Line     int    // 行番号（1始まり）
+	Column   int    // 列番号（1始まり、文字単位）
+}
+
+// 位置情報が記録されているかを返す
+func (p Position) IsValid() bool {
+	return p.Line > 0
+}
+
+// 「file:line:column」あるいは「line:column」の形式で位置を返す
+func (p Position) String() string {
+	s := p.Filename
+	if p.IsValid() {
+		if s != "" {
+			s += ":"
+		}
+		s += fmt.Sprintf("%d:%d", p.Line, p.Column)
+	}
+	if s == "" {
+		s = "-"
+	}
+	return s
 }
 
 const (
@@ -13,22 +45,41 @@ const (
 	// 識別子 + リテラル
 	IDENT  = "IDENT" // add, result, x, y, etc.
 	INT    = "INT"   // 12, 34, ...
+	FLOAT  = "FLOAT" // 1.5, 1e-9, ...
 	STRING = "STRING"
 
 	// 演算子
-	ASSIGN   = "="
-	PLUS     = "+"
-	MINUS    = "-"
-	BANG     = "!"
-	ASTERISK = "*"
-	SLASH    = "/"
+	ASSIGN          = "="
+	PLUS_ASSIGN     = "+="
+	MINUS_ASSIGN    = "-="
+	ASTERISK_ASSIGN = "*="
+	SLASH_ASSIGN    = "/="
+	PLUS            = "+"
+	MINUS           = "-"
+	BANG            = "!"
+	ASTERISK        = "*"
+	SLASH           = "/"
+	PERCENT         = "%"
 
-	LT = "<" // Less Than
-	GT = ">" // Greater Than
+	LT    = "<"  // Less Than
+	GT    = ">"  // Greater Than
+	LT_EQ = "<=" // Less Than or Equal
+	GT_EQ = ">=" // Greater Than or Equal
 
 	EQ     = "=="
 	NOT_EQ = "!="
 
+	// 論理演算子
+	AND = "&&"
+	OR  = "||"
+
+	// ビット演算子
+	AMPERSAND   = "&"
+	PIPE        = "|"
+	CARET       = "^"
+	SHIFT_LEFT  = "<<"
+	SHIFT_RIGHT = ">>"
+
 	// デリミタ
 	COMMA     = ","
 	COLON     = ":"
@@ -49,17 +100,27 @@ const (
 	IF       = "IF"
 	ELSE     = "ELSE"
 	RETURN   = "RETURN"
+	WHILE    = "WHILE"
+	BREAK    = "BREAK"
+	CONTINUE = "CONTINUE"
+	FOR      = "FOR"
+	IN       = "IN"
 )
 
 // ユーザー定義の識別子と言語のキーワードを区別する機能
 var keywords = map[string]TokenType{
-	"fn":     FUNCTION,
-	"let":    LET,
-	"true":   TRUE,
-	"false":  FALSE,
-	"if":     IF,
-	"else":   ELSE,
-	"return": RETURN,
+	"fn":       FUNCTION,
+	"let":      LET,
+	"true":     TRUE,
+	"false":    FALSE,
+	"if":       IF,
+	"else":     ELSE,
+	"return":   RETURN,
+	"while":    WHILE,
+	"break":    BREAK,
+	"continue": CONTINUE,
+	"for":      FOR,
+	"in":       IN,
 }
 
 // 渡された識別子とされるものがキーワードではないかを確認する
@@ -0,0 +1,45 @@
+package vm
+
+// Defaults used for the zero fields of Config.
+const (
+	StackSize           = 2048    // is the number of stack slots allocated when a VM is created.
+	DefaultMaxStackSize = 1 << 20 // is the number of stack slots the stack may grow to.
+	DefaultMaxCallDepth = 1 << 16 // is the number of nested function calls allowed.
+)
+
+// initialFrames is the number of frames allocated when a VM is created. The frame stack grows on demand.
+const initialFrames = 64
+
+// Config holds the limits of a VM. A zero field means its default.
+type Config struct {
+	StackSize    int // is the number of stack slots allocated up front; the stack grows on demand up to MaxStackSize.
+	MaxStackSize int // is the maximum number of stack slots. Exceeding it fails with ErrStackOverflow.
+	MaxCallDepth int // is the maximum number of active frames, the main one included. Exceeding it fails with ErrCallDepthExceeded.
+
+	// MaxInstructions is the number of instructions a single run may execute; 0 means unlimited.
+	// Exceeding it fails with object.ErrBudgetExhausted.
+	MaxInstructions int64
+
+	// MaxAllocatedBytes is the approximate number of bytes a single run may allocate for new arrays, strings
+	// and hashes (see object.SizeOf); 0 means unlimited. Memory is counted when allocated and never given back,
+	// so this bounds the total allocation of a run rather than its live heap.
+	// Exceeding it fails with object.ErrAllocationLimit.
+	MaxAllocatedBytes int64
+}
+
+// withDefaults returns a copy of c whose zero fields are replaced with the defaults.
+func (c Config) withDefaults() Config {
+	if c.MaxStackSize <= 0 {
+		c.MaxStackSize = DefaultMaxStackSize
+	}
+	if c.StackSize <= 0 {
+		c.StackSize = StackSize
+	}
+	if c.StackSize > c.MaxStackSize {
+		c.StackSize = c.MaxStackSize
+	}
+	if c.MaxCallDepth <= 0 {
+		c.MaxCallDepth = DefaultMaxCallDepth
+	}
+	return c
+}
@@ -0,0 +1,59 @@
+package vm
+
+import (
+	"errors"
+	"fmt"
+)
+
+// Errors returned when a VM runs out of the limits of its Config.
+var (
+	ErrStackOverflow     = errors.New("stack overflow")
+	ErrCallDepthExceeded = errors.New("maximum call depth exceeded")
+)
+
+// RuntimeError is an error raised while executing bytecode. It remembers where in the Monkey program it happened.
+type RuntimeError struct {
+	Err        error        // is the underlying error.
+	StackTrace []StackFrame // lists the frames which were active when the error occurred, innermost first, at most maxStackTrace of them.
+}
+
+// maxStackTrace is the number of frames kept in a stack trace, so that deep recursion does not produce huge errors.
+const maxStackTrace = 100
+
+// StackFrame describes one function activation of a stack trace.
+type StackFrame struct {
+	Function string // is the name of the function; "<main>" for the top level and "<anonymous>" for unnamed functions.
+	Line     int    // is the source line being executed in the function, 0 if unknown.
+}
+
+func (e *RuntimeError) Error() string {
+	return e.Err.Error()
+}
+
+func (e *RuntimeError) Unwrap() error {
+	return e.Err
+}
+
+func (f StackFrame) String() string {
+	if f.Line == 0 {
+		return f.Function
+	}
+	return fmt.Sprintf("%s (line %d)", f.Function, f.Line)
+}
+
+// newRuntimeError wraps err with the stack trace built from the currently active frames.
+func (vm *VM) newRuntimeError(err error) *RuntimeError {
+	trace := []StackFrame{}
+	for i := vm.frameIndex - 1; i >= 0 && len(trace) < maxStackTrace; i-- {
+		frame := vm.frames[i]
+		name := frame.cl.Fn.Name
+		switch {
+		case i == 0:
+			name = "<main>"
+		case name == "":
+			name = "<anonymous>"
+		}
+		trace = append(trace, StackFrame{Function: name, Line: frame.cl.Fn.Lines.LineFor(frame.ip)})
+	}
+	return &RuntimeError{Err: err, StackTrace: trace}
+}
@@ -5,8 +5,6 @@ import (
 	"monkey/object"
 )
 
-const MaxFrame = 1024
-
 // Frame is a data structure that holds execution-relevant information, like the instructions and the instruction pointer.
 // In compiler or interpreter literature, this data structure is also called activation record.
 type Frame struct {
@@ -0,0 +1,133 @@
+package vm
+
+import (
+	"context"
+	"fmt"
+	"monkey/code"
+	"monkey/object"
+)
+
+// Global returns the value of the global variable name. It reports false if the program defines no such variable
+// or it has not been assigned yet.
+func (vm *VM) Global(name string) (object.Object, bool) {
+	index, ok := vm.globalNames[name]
+	if !ok || vm.globals[index] == nil {
+		return nil, false
+	}
+	return vm.globals[index], true
+}
+
+// SetGlobal assigns value, converted with object.FromGo, to the global variable name.
+// The variable has to be known to the compiler, e.g. declared with compiler.DefineGlobal.
+func (vm *VM) SetGlobal(name string, value interface{}) error {
+	index, ok := vm.globalNames[name]
+	if !ok {
+		return fmt.Errorf("undefined global: %s", name)
+	}
+	obj, err := object.FromGo(value)
+	if err != nil {
+		return err
+	}
+	vm.globals[index] = obj
+	return nil
+}
+
+// Register makes the Go function fn callable from Monkey as the global name.
+// Arguments and results are converted as described in object.NewHostFunction.
+func (vm *VM) Register(name string, fn interface{}) error {
+	builtin, err := object.NewHostFunction(fn)
+	if err != nil {
+		return fmt.Errorf("%s: %w", name, err)
+	}
+	return vm.SetGlobal(name, builtin)
+}
+
+// Call calls fn, a closure or builtin, with args converted with object.FromGo and returns its result.
+// It is meant for calling back into functions the program defined once Run has returned.
+func (vm *VM) Call(fn object.Object, args ...interface{}) (object.Object, error) {
+	return vm.CallContext(context.Background(), fn, args...)
+}
+
+// CallContext is like Call, but stops once ctx is cancelled. Each call runs within the limits of the VM's Config.
+// Errors are returned as *RuntimeError; the VM stays usable after one.
+func (vm *VM) CallContext(ctx context.Context, fn object.Object, args ...interface{}) (object.Object, error) {
+	if len(args) > 255 {
+		return nil, fmt.Errorf("too many arguments: %d", len(args))
+	}
+	objs := make([]object.Object, len(args))
+	for i, arg := range args {
+		obj, err := object.FromGo(arg)
+		if err != nil {
+			return nil, fmt.Errorf("argument %d: %w", i+1, err)
+		}
+		objs[i] = obj
+	}
+
+	frameIndex, sp := vm.frameIndex, vm.sp
+	var result object.Object
+	err := vm.execute(ctx, func() error {
+		var err error
+		result, err = vm.call(fn, objs, "<host>")
+		return err
+	})
+	if err != nil {
+		// drop whatever the failed call left behind, so that the VM can be called again.
+		vm.closeUpvalues(sp)
+		vm.frameIndex, vm.sp = frameIndex, sp
+		return nil, err
+	}
+	return result, nil
+}
+
+// invoke is the object.Invoker passed to builtins. It calls fn back on top of the frames of the running program.
+// A failure is reported to the builtin as an *object.Error and remembered in callbackErr,
+// so that callBuiltin raises it as a runtime error once the builtin returns.
+func (vm *VM) invoke(fn object.Object, args ...object.Object) object.Object {
+	if vm.callbackErr != nil {
+		return &object.Error{Message: vm.callbackErr.Error()}
+	}
+	if len(args) > 255 {
+		vm.callbackErr = vm.newRuntimeError(fmt.Errorf("too many arguments: %d", len(args)))
+		return &object.Error{Message: vm.callbackErr.Error()}
+	}
+	frameIndex, sp := vm.frameIndex, vm.sp
+	result, err := vm.call(fn, args, "<builtin>")
+	if err != nil {
+		if rerr, ok := err.(*RuntimeError); ok {
+			vm.callbackErr = rerr // raised by a nested callback.
+		} else {
+			vm.callbackErr = vm.newRuntimeError(err)
+		}
+		vm.closeUpvalues(sp)
+		vm.frameIndex, vm.sp = frameIndex, sp
+		return &object.Error{Message: err.Error()}
+	}
+	return result
+}
+
+// call runs fn with args to completion on top of the current frames.
+// fn is called from a frame of its own, named caller in stack traces, whose only instruction is OpCall,
+// so the run loop stops as soon as fn returns.
+func (vm *VM) call(fn object.Object, args []object.Object, caller string) (object.Object, error) {
+	callerFrame := &object.Closure{Fn: &object.CompiledFunction{
+		Instructions: code.Make(code.OpCall, len(args)),
+		Name:         caller,
+	}}
+	if err := vm.pushFrame(NewFrame(callerFrame, vm.sp)); err != nil {
+		return nil, err
+	}
+	if err := vm.push(fn); err != nil {
+		return nil, err
+	}
+	for _, arg := range args {
+		if err := vm.push(arg); err != nil {
+			return nil, err
+		}
+	}
+	if err := vm.run(); err != nil {
+		return nil, err
+	}
+	result := vm.pop()
+	vm.popFrame()
+	return result, nil
+}
@@ -1,44 +1,58 @@
 package vm
 
 import (
+	"context"
 	"fmt"
+	"math"
 	"monkey/code"
 	"monkey/compiler"
 	"monkey/object"
 )
 
-const (
-	StackSize   = 2048
-	GlobalsSize = 65536
-)
+const GlobalsSize = 65536
 
 type VM struct {
-	constants  []object.Object
-	stack      []object.Object
-	sp         int             // is always pointing to the next value. Top of the stack is stack[sp-1]
-	globals    []object.Object // stores global variables
-	frames     []*Frame
-	frameIndex int
+	constants    []object.Object
+	stack        []object.Object
+	sp           int              // is always pointing to the next value. Top of the stack is stack[sp-1]
+	globals      []object.Object  // stores global variables
+	globalNames  map[string]int   // maps the names of global variables to their index in globals.
+	builtins     *object.Registry // holds the builtins OpGetBuiltin refers to, the ones the bytecode was compiled against.
+	frames       []*Frame
+	frameIndex   int
+	openUpvalues []*object.Upvalue // are the upvalue cells still pointing into the stack, sorted by their stack slot.
+	config       Config
+	budget       *object.Budget // limits the current run; nil when it is unlimited.
+	callbackErr  *RuntimeError  // is the error of a function called back from a builtin, raised once the builtin returns.
 }
 
-var True = &object.Boolean{Value: true}
-var False = &object.Boolean{Value: false}
-var Null = &object.Null{}
+var True = object.TRUE
+var False = object.FALSE
+var Null = object.NULL
 
 // New returns a pointer to the VM which is initialized with compiler.Bytecode.
 func New(bytecode *compiler.Bytecode) *VM {
-	mainFn := &object.CompiledFunction{Instructions: bytecode.Instructions}
+	return NewWithConfig(bytecode, Config{})
+}
+
+// NewWithConfig returns a pointer to the VM which is initialized with compiler.Bytecode and runs within the limits of config.
+func NewWithConfig(bytecode *compiler.Bytecode, config Config) *VM {
+	config = config.withDefaults()
+	mainFn := &object.CompiledFunction{Instructions: bytecode.Instructions, Lines: bytecode.Lines}
 	mainClosure := &object.Closure{Fn: mainFn}
 	mainFrame := NewFrame(mainClosure, 0)
-	frames := make([]*Frame, MaxFrame)
+	frames := make([]*Frame, initialFrames)
 	frames[0] = mainFrame
 	return &VM{
-		constants:  bytecode.Constants,
-		stack:      make([]object.Object, StackSize),
-		sp:         0,
-		globals:    make([]object.Object, GlobalsSize),
-		frames:     frames,
-		frameIndex: 1,
+		constants:   bytecode.Constants,
+		stack:       make([]object.Object, config.StackSize),
+		sp:          0,
+		globals:     make([]object.Object, GlobalsSize),
+		globalNames: bytecode.Globals,
+		builtins:    bytecode.Builtins,
+		frames:      frames,
+		frameIndex:  1,
+		config:      config,
 	}
 }
 
@@ -60,12 +74,52 @@ func (vm *VM) LastPoppedStackElem() object.Object {
 	return vm.stack[vm.sp]
 }
 
+// Run executes the bytecode. Errors are returned as *RuntimeError carrying a Monkey-level stack trace.
 func (vm *VM) Run() error {
+	return vm.RunContext(context.Background())
+}
+
+// RunContext executes the bytecode like Run, but stops with an error wrapping ctx.Err() once ctx is cancelled,
+// with object.ErrBudgetExhausted once Config.MaxInstructions instructions have been executed,
+// and with object.ErrAllocationLimit once more than Config.MaxAllocatedBytes bytes have been allocated.
+// Cancellation is checked periodically, so it takes effect within a bounded number of instructions.
+func (vm *VM) RunContext(ctx context.Context) error {
+	return vm.execute(ctx, vm.run)
+}
+
+// execute calls run within the limits of the VM's Config and ctx.
+// Errors, and Go panics as well, are reported as *RuntimeError.
+func (vm *VM) execute(ctx context.Context, run func() error) (err error) {
+	vm.budget = nil
+	if ctx.Done() != nil || vm.config.MaxInstructions > 0 || vm.config.MaxAllocatedBytes > 0 {
+		vm.budget = object.NewBudget(ctx, vm.config.MaxInstructions).LimitAllocation(vm.config.MaxAllocatedBytes)
+	}
+	// a Go panic while executing a script must not take down the host; report it as a runtime error instead.
+	defer func() {
+		if r := recover(); r != nil {
+			err = vm.newRuntimeError(fmt.Errorf("internal error: %v", r))
+		}
+	}()
+	if err = run(); err != nil {
+		if rerr, ok := err.(*RuntimeError); ok {
+			return rerr // raised by a function called back from a builtin, with the stack trace of where it happened.
+		}
+		return vm.newRuntimeError(err)
+	}
+	return nil
+}
+
+func (vm *VM) run() error {
 	var ip int // ip stands for instruction pointer
 	var ins code.Instructions
 	var op code.Opcode
 	// fetch-decode-execute cycle.
 	for vm.currentFrame().ip < len(vm.currentFrame().Instructions())-1 {
+		if vm.budget != nil {
+			if err := vm.budget.Step(); err != nil {
+				return err
+			}
+		}
 		vm.currentFrame().ip++
 		ip = vm.currentFrame().ip
 		ins = vm.currentFrame().Instructions()
@@ -78,8 +132,9 @@ func (vm *VM) Run() error {
 			if err != nil {
 				return err
 			}
-		case code.OpAdd, code.OpSub, code.OpMul, code.OpDiv:
-			err := vm.executeBinaryOperation(op) // delegate executeBinaryOperation to execute +, -, *, /.
+		case code.OpAdd, code.OpSub, code.OpMul, code.OpDiv, code.OpMod,
+			code.OpBitAnd, code.OpBitOr, code.OpBitXor, code.OpShiftLeft, code.OpShiftRight:
+			err := vm.executeBinaryOperation(op) // delegate executeBinaryOperation to execute +, -, *, /, %, &, |, ^, <<, >>.
 			if err != nil {
 				return err
 			}
@@ -95,8 +150,9 @@ func (vm *VM) Run() error {
 			if err != nil {
 				return err
 			}
-		case code.OpEqual, code.OpNotEqual, code.OpGreaterThan:
-			err := vm.executeComparison(op) // delegate executeComparison to execute ==, !=, >.
+		case code.OpEqual, code.OpNotEqual, code.OpGreaterThan,
+			code.OpLessThan, code.OpLessThanOrEqual, code.OpGreaterThanOrEqual:
+			err := vm.executeComparison(op) // delegate executeComparison to execute ==, !=, >, <, <=, >=.
 			if err != nil {
 				return err
 			}
@@ -126,14 +182,43 @@ func (vm *VM) Run() error {
 				return err
 			}
 		case code.OpGetBuiltin:
-			builtinIndex := code.ReadUint8(ins[ip+1:]) // decode index of builtin function object
-			vm.currentFrame().ip += 1
-			definition := object.Builtins[builtinIndex] // search builtin function object
-			err := vm.push(definition.Builtin)          // load builtin function object onto the stack
+			builtinIndex := code.ReadUint16(ins[ip+1:]) // decode index of builtin function object
+			vm.currentFrame().ip += 2
+			builtin, ok := vm.builtins.At(int(builtinIndex)) // search builtin function object
+			if !ok {
+				return fmt.Errorf("undefined builtin: %d", builtinIndex)
+			}
+			err := vm.push(builtin) // load builtin function object onto the stack
 			if err != nil {
 				return err
 			}
 		case code.OpGetFree:
+			freeIndex := code.ReadUint8(ins[ip+1:])
+			vm.currentFrame().ip += 1
+			currentClosure := vm.currentFrame().cl
+			err := vm.push(vm.getUpvalue(currentClosure.Free[freeIndex]))
+			if err != nil {
+				return err
+			}
+		case code.OpSetFree:
+			freeIndex := code.ReadUint8(ins[ip+1:])
+			vm.currentFrame().ip += 1
+			currentClosure := vm.currentFrame().cl
+			vm.setUpvalue(currentClosure.Free[freeIndex], vm.pop())
+		case code.OpCaptureLocal:
+			localIndex := code.ReadUint8(ins[ip+1:])
+			vm.currentFrame().ip += 1
+			frame := vm.currentFrame()
+			err := vm.push(vm.captureUpvalue(frame.basePointer + int(localIndex)))
+			if err != nil {
+				return err
+			}
+		case code.OpCurrentClosure:
+			err := vm.push(vm.currentFrame().cl)
+			if err != nil {
+				return err
+			}
+		case code.OpCaptureFree:
 			freeIndex := code.ReadUint8(ins[ip+1:])
 			vm.currentFrame().ip += 1
 			currentClosure := vm.currentFrame().cl
@@ -169,11 +254,26 @@ func (vm *VM) Run() error {
 			numElements := int(code.ReadUint16(ins[ip+1:]))
 			vm.currentFrame().ip += 2
 			array := vm.buildArray(vm.sp-numElements, vm.sp) // delegate buildArray to execute OpArray.
+			if err := vm.allocate(array); err != nil {
+				return err
+			}
 			vm.sp = vm.sp - numElements
 			err := vm.push(array)
 			if err != nil {
 				return err
 			}
+		case code.OpTuple:
+			numElements := int(code.ReadUint16(ins[ip+1:]))
+			vm.currentFrame().ip += 2
+			tuple := vm.buildTuple(vm.sp-numElements, vm.sp)
+			if err := vm.allocate(tuple); err != nil {
+				return err
+			}
+			vm.sp = vm.sp - numElements
+			err := vm.push(tuple)
+			if err != nil {
+				return err
+			}
 		case code.OpHash:
 			numElements := int(code.ReadUint16(ins[ip+1:]))
 			vm.currentFrame().ip += 2
@@ -181,6 +281,9 @@ func (vm *VM) Run() error {
 			if err != nil {
 				return err
 			}
+			if err := vm.allocate(hash); err != nil {
+				return err
+			}
 			vm.sp = vm.sp - numElements
 			err = vm.push(hash)
 			if err != nil {
@@ -193,6 +296,40 @@ func (vm *VM) Run() error {
 			if err != nil {
 				return err
 			}
+		case code.OpSetIndex:
+			value := vm.pop()
+			index := vm.pop()
+			left := vm.pop()
+			err := vm.executeSetIndex(left, index, value)
+			if err != nil {
+				return err
+			}
+		case code.OpDup2:
+			err := vm.push(vm.stack[vm.sp-2])
+			if err == nil {
+				err = vm.push(vm.stack[vm.sp-2])
+			}
+			if err != nil {
+				return err
+			}
+		case code.OpIter:
+			iterable := vm.pop()
+			iterator, ok := object.NewIterator(iterable)
+			if !ok {
+				return fmt.Errorf("cannot iterate over %s", iterable.Type())
+			}
+			err := vm.push(iterator)
+			if err != nil {
+				return err
+			}
+		case code.OpIterNext:
+			pos := int(code.ReadUint16(ins[ip+1:]))
+			numVars := code.ReadUint8(ins[ip+3:])
+			vm.currentFrame().ip += 3
+			err := vm.executeIterNext(pos, int(numVars))
+			if err != nil {
+				return err
+			}
 		case code.OpCall:
 			numArgs := code.ReadUint8(ins[ip+1:])
 			vm.currentFrame().ip += 1
@@ -200,9 +337,22 @@ func (vm *VM) Run() error {
 			if err != nil {
 				return err
 			}
+		case code.OpTailCall:
+			numArgs := code.ReadUint8(ins[ip+1:])
+			vm.currentFrame().ip += 1
+			err := vm.executeTailCall(int(numArgs))
+			if err != nil {
+				return err
+			}
 		case code.OpReturnValue:
 			returnValue := vm.pop()
+			if vm.frameIndex == 1 {
+				// `return` at the top level ends the program with the returned value, as in the evaluator.
+				vm.currentFrame().ip = len(vm.currentFrame().Instructions()) - 1
+				continue
+			}
 			frame := vm.popFrame()
+			vm.closeUpvalues(frame.basePointer)
 			vm.sp = frame.basePointer - 1
 			err := vm.push(returnValue)
 			if err != nil {
@@ -210,6 +360,7 @@ func (vm *VM) Run() error {
 			}
 		case code.OpReturn:
 			frame := vm.popFrame()
+			vm.closeUpvalues(frame.basePointer)
 			vm.sp = frame.basePointer - 1
 			err := vm.push(Null)
 			if err != nil {
@@ -234,8 +385,11 @@ func (vm *VM) executeBinaryOperation(op code.Opcode) error {
 	leftType := left.Type()
 	rightType := right.Type()
 	switch {
-	case leftType == object.INTEGER_OBJ && rightType == object.INTEGER_OBJ:
+	case object.IsInteger(left) && object.IsInteger(right):
 		return vm.executeBinaryIntegerOperation(op, left, right)
+	case object.IsNumber(left) && object.IsNumber(right):
+		// mixed integer/float operands are computed as floats.
+		return vm.executeBinaryFloatOperation(op, left, right)
 	case leftType == object.STRING_OBJ && rightType == object.STRING_OBJ:
 		return vm.executeBinaryStringOperation(op, left, right)
 	default:
@@ -243,10 +397,49 @@ func (vm *VM) executeBinaryOperation(op code.Opcode) error {
 	}
 }
 
+// executeBinaryIntegerOperation promotes results that overflow int64 to
+// BigInt, and demotes BigInt results that fit back to Integer.
 func (vm *VM) executeBinaryIntegerOperation(op code.Opcode, left, right object.Object) error {
-	leftValue := left.(*object.Integer).Value
-	rightValue := right.(*object.Integer).Value
-	var result int64
+	var result object.Object
+	var err error
+	switch op {
+	case code.OpAdd:
+		result = object.AddIntegers(left, right)
+	case code.OpSub:
+		result = object.SubIntegers(left, right)
+	case code.OpMul:
+		result = object.MulIntegers(left, right)
+	case code.OpDiv:
+		result, err = object.DivIntegers(left, right)
+	case code.OpMod:
+		result, err = object.ModIntegers(left, right)
+	case code.OpBitAnd:
+		result = object.AndIntegers(left, right)
+	case code.OpBitOr:
+		result = object.OrIntegers(left, right)
+	case code.OpBitXor:
+		result = object.XorIntegers(left, right)
+	case code.OpShiftLeft:
+		result, err = object.ShiftLeft(left, right)
+	case code.OpShiftRight:
+		result, err = object.ShiftRight(left, right)
+	default:
+		return fmt.Errorf("unknown operator: %d", op)
+	}
+	if err != nil {
+		return err
+	}
+	// BigInt results can grow without bound (e.g. repeated squaring), so they count as allocations.
+	if err := vm.allocate(result); err != nil {
+		return err
+	}
+	return vm.push(result)
+}
+
+func (vm *VM) executeBinaryFloatOperation(op code.Opcode, left, right object.Object) error {
+	leftValue := object.ToFloat(left)
+	rightValue := object.ToFloat(right)
+	var result float64
 	switch op {
 	case code.OpAdd:
 		result = leftValue + rightValue
@@ -256,10 +449,12 @@ func (vm *VM) executeBinaryIntegerOperation(op code.Opcode, left, right object.O
 		result = leftValue * rightValue
 	case code.OpDiv:
 		result = leftValue / rightValue
+	case code.OpMod:
+		result = math.Mod(leftValue, rightValue)
 	default:
-		return fmt.Errorf("unknown operator: %d", op)
+		return fmt.Errorf("unsupported types for binary operation: %s %s", left.Type(), right.Type())
 	}
-	return vm.push(&object.Integer{Value: result})
+	return vm.push(&object.Float{Value: result})
 }
 
 func (vm *VM) executeBinaryStringOperation(op code.Opcode, left, right object.Object) error {
@@ -268,12 +463,18 @@ func (vm *VM) executeBinaryStringOperation(op code.Opcode, left, right object.Ob
 	}
 	leftValue := left.(*object.String).Value
 	rightValue := right.(*object.String).Value
-	return vm.push(&object.String{Value: leftValue + rightValue})
+	result := &object.String{Value: leftValue + rightValue}
+	if err := vm.allocate(result); err != nil {
+		return err
+	}
+	return vm.push(result)
 }
 
 func (vm *VM) push(o object.Object) error {
-	if vm.sp >= StackSize {
-		return fmt.Errorf("stack overflow")
+	if vm.sp >= len(vm.stack) {
+		if err := vm.growStack(vm.sp + 1); err != nil {
+			return err
+		}
 	}
 	vm.stack[vm.sp] = o
 	vm.sp++
@@ -290,9 +491,39 @@ func (vm *VM) currentFrame() *Frame {
 	return vm.frames[vm.frameIndex-1]
 }
 
-func (vm *VM) pushFrame(f *Frame) {
-	vm.frames[vm.frameIndex] = f
+// growStack makes room for at least size stack slots, doubling the stack up to the configured maximum.
+// Open upvalues refer to stack slots by index, so they stay valid when the stack is reallocated.
+func (vm *VM) growStack(size int) error {
+	if size <= len(vm.stack) {
+		return nil
+	}
+	if size > vm.config.MaxStackSize {
+		return ErrStackOverflow
+	}
+	newSize := 2 * len(vm.stack)
+	if newSize < size {
+		newSize = size
+	}
+	if newSize > vm.config.MaxStackSize {
+		newSize = vm.config.MaxStackSize
+	}
+	stack := make([]object.Object, newSize)
+	copy(stack, vm.stack[:vm.sp])
+	vm.stack = stack
+	return nil
+}
+
+func (vm *VM) pushFrame(f *Frame) error {
+	if vm.frameIndex >= vm.config.MaxCallDepth {
+		return ErrCallDepthExceeded
+	}
+	if vm.frameIndex == len(vm.frames) {
+		vm.frames = append(vm.frames, f)
+	} else {
+		vm.frames[vm.frameIndex] = f
+	}
 	vm.frameIndex++
+	return nil
 }
 
 func (vm *VM) popFrame() *Frame {
@@ -303,22 +534,45 @@ func (vm *VM) popFrame() *Frame {
 func (vm *VM) executeComparison(op code.Opcode) error {
 	right := vm.pop()
 	left := vm.pop()
-	if left.Type() == object.INTEGER_OBJ || right.Type() == object.INTEGER_OBJ {
+	if object.IsInteger(left) && object.IsInteger(right) {
 		return vm.executeIntegerComparison(op, left, right)
 	}
+	if object.IsNumber(left) && object.IsNumber(right) {
+		return vm.executeFloatComparison(op, left, right)
+	}
 	switch op {
 	case code.OpEqual:
-		return vm.push(nativeBoolToBooleanObject(right == left))
+		return vm.push(nativeBoolToBooleanObject(object.Equal(left, right)))
 	case code.OpNotEqual:
-		return vm.push(nativeBoolToBooleanObject(right != left))
+		return vm.push(nativeBoolToBooleanObject(!object.Equal(left, right)))
 	default:
 		return fmt.Errorf("unknown operator: %d (%s %s)", op, left.Type(), right.Type())
 	}
 }
 
 func (vm *VM) executeIntegerComparison(op code.Opcode, left, right object.Object) error {
-	leftValue := left.(*object.Integer).Value
-	rightValue := right.(*object.Integer).Value
+	cmp := object.CompareIntegers(left, right)
+	switch op {
+	case code.OpEqual:
+		return vm.push(nativeBoolToBooleanObject(cmp == 0))
+	case code.OpNotEqual:
+		return vm.push(nativeBoolToBooleanObject(cmp != 0))
+	case code.OpGreaterThan:
+		return vm.push(nativeBoolToBooleanObject(cmp > 0))
+	case code.OpLessThan:
+		return vm.push(nativeBoolToBooleanObject(cmp < 0))
+	case code.OpGreaterThanOrEqual:
+		return vm.push(nativeBoolToBooleanObject(cmp >= 0))
+	case code.OpLessThanOrEqual:
+		return vm.push(nativeBoolToBooleanObject(cmp <= 0))
+	default:
+		return fmt.Errorf("unknown operator: %d", op)
+	}
+}
+
+func (vm *VM) executeFloatComparison(op code.Opcode, left, right object.Object) error {
+	leftValue := object.ToFloat(left)
+	rightValue := object.ToFloat(right)
 	switch op {
 	case code.OpEqual:
 		return vm.push(nativeBoolToBooleanObject(leftValue == rightValue))
@@ -326,6 +580,12 @@ func (vm *VM) executeIntegerComparison(op code.Opcode, left, right object.Object
 		return vm.push(nativeBoolToBooleanObject(leftValue != rightValue))
 	case code.OpGreaterThan:
 		return vm.push(nativeBoolToBooleanObject(leftValue > rightValue))
+	case code.OpLessThan:
+		return vm.push(nativeBoolToBooleanObject(leftValue < rightValue))
+	case code.OpGreaterThanOrEqual:
+		return vm.push(nativeBoolToBooleanObject(leftValue >= rightValue))
+	case code.OpLessThanOrEqual:
+		return vm.push(nativeBoolToBooleanObject(leftValue <= rightValue))
 	default:
 		return fmt.Errorf("unknown operator: %d", op)
 	}
@@ -354,11 +614,18 @@ func (vm *VM) executeBangOperator() error {
 
 func (vm *VM) executeMinusOperator() error {
 	operand := vm.pop()
-	if operand.Type() != object.INTEGER_OBJ {
+	switch operand := operand.(type) {
+	case *object.Integer, *object.BigInt:
+		result := object.NegateInteger(operand)
+		if err := vm.allocate(result); err != nil {
+			return err
+		}
+		return vm.push(result)
+	case *object.Float:
+		return vm.push(&object.Float{Value: -operand.Value})
+	default:
 		return fmt.Errorf("unsupported type for negation: %s", operand.Type())
 	}
-	value := operand.(*object.Integer).Value
-	return vm.push(&object.Integer{Value: -value})
 }
 
 func (vm *VM) buildArray(startIndex, endIndex int) object.Object {
@@ -369,25 +636,32 @@ func (vm *VM) buildArray(startIndex, endIndex int) object.Object {
 	return &object.Array{Elements: elements}
 }
 
+func (vm *VM) buildTuple(startIndex, endIndex int) object.Object {
+	elements := make([]object.Object, endIndex-startIndex)
+	copy(elements, vm.stack[startIndex:endIndex])
+	return &object.Tuple{Elements: elements}
+}
+
 func (vm *VM) buildHash(startIndex, endIndex int) (object.Object, error) {
-	hashedPairs := make(map[object.HashKey]object.HashPair)
+	hash := &object.Hash{}
 	for i := startIndex; i < endIndex; i += 2 {
 		key := vm.stack[i]
 		value := vm.stack[i+1]
-		pair := object.HashPair{Key: key, Value: value}
-		hashKey, ok := key.(object.Hashable)
+		hashKey, ok := object.AsHashable(key)
 		if !ok {
 			return nil, fmt.Errorf("unusable as hash key: %s", key.Type())
 		}
-		hashedPairs[hashKey.HashKey()] = pair
+		hash.Set(hashKey, value)
 	}
-	return &object.Hash{Pairs: hashedPairs}, nil
+	return hash, nil
 }
 
 func (vm *VM) executeIndexExpression(left, index object.Object) error {
 	switch {
 	case left.Type() == object.ARRAY_OBJ && index.Type() == object.INTEGER_OBJ:
-		return vm.executeArrayIndex(left, index)
+		return vm.executeArrayIndex(left.(*object.Array).Elements, index)
+	case left.Type() == object.TUPLE_OBJ && index.Type() == object.INTEGER_OBJ:
+		return vm.executeArrayIndex(left.(*object.Tuple).Elements, index)
 	case left.Type() == object.HASH_OBJ:
 		return vm.executeHashIndex(left, index)
 	default:
@@ -395,29 +669,87 @@ func (vm *VM) executeIndexExpression(left, index object.Object) error {
 	}
 }
 
-func (vm *VM) executeArrayIndex(array, index object.Object) error {
-	arrayObject := array.(*object.Array)
+// executeArrayIndex indexes the elements of an array or a tuple.
+func (vm *VM) executeArrayIndex(elements []object.Object, index object.Object) error {
 	i := index.(*object.Integer).Value
-	max := int64(len(arrayObject.Elements) - 1)
+	max := int64(len(elements) - 1)
 	if i < 0 || i > max {
 		return vm.push(Null)
 	}
-	return vm.push(arrayObject.Elements[i])
+	return vm.push(elements[i])
 }
 
 func (vm *VM) executeHashIndex(hash, index object.Object) error {
 	hashObject := hash.(*object.Hash)
-	key, ok := index.(object.Hashable) // check whether the given index can be used as an object.HashKey.
+	key, ok := object.AsHashable(index) // check whether the given index can be used as an object.HashKey.
 	if !ok {
 		return fmt.Errorf("unusable as hash key: %s", index.Type())
 	}
-	pair, ok := hashObject.Pairs[key.HashKey()]
+	pair, ok := hashObject.Get(key)
 	if !ok {
 		return vm.push(Null)
 	}
 	return vm.push(pair.Value)
 }
 
+// executeIterNext advances the iterator on the top of the stack, leaving it in place.
+// It pushes the next element, or its key and value when numVars is 2.
+// Once the iterator is exhausted it pops the iterator and jumps to pos.
+func (vm *VM) executeIterNext(pos int, numVars int) error {
+	iterator := vm.stack[vm.sp-1].(*object.Iterator)
+	if numVars == 2 {
+		key, value, ok := iterator.Next()
+		if !ok {
+			vm.pop()
+			vm.currentFrame().ip = pos - 1
+			return nil
+		}
+		err := vm.push(key)
+		if err != nil {
+			return err
+		}
+		return vm.push(value)
+	}
+	element, ok := iterator.NextElement()
+	if !ok {
+		vm.pop()
+		vm.currentFrame().ip = pos - 1
+		return nil
+	}
+	return vm.push(element)
+}
+
+func (vm *VM) executeSetIndex(left, index, value object.Object) error {
+	switch left := left.(type) {
+	case *object.Array:
+		if left.Frozen {
+			return fmt.Errorf("index assignment not supported: frozen %s", left.Type())
+		}
+		idx, ok := index.(*object.Integer)
+		if !ok {
+			return fmt.Errorf("index must be INTEGER, got %s", index.Type())
+		}
+		if idx.Value < 0 || int64(len(left.Elements)) <= idx.Value {
+			return fmt.Errorf("index out of range: %d", idx.Value)
+		}
+		left.Elements[idx.Value] = value
+	case *object.Hash:
+		key, ok := object.AsHashable(index)
+		if !ok {
+			return fmt.Errorf("unusable as hash key: %s", index.Type())
+		}
+		if _, exists := left.Get(key); !exists && vm.budget != nil {
+			if err := vm.budget.AllocateBytes(object.HashEntrySize); err != nil {
+				return err
+			}
+		}
+		left.Set(key, value)
+	default:
+		return fmt.Errorf("index assignment not supported: %s", left.Type())
+	}
+	return vm.push(value)
+}
+
 func (vm *VM) executeCall(numArgs int) error {
 	callee := vm.stack[vm.sp-1-numArgs]
 	switch callee := callee.(type) {
@@ -430,20 +762,62 @@ func (vm *VM) executeCall(numArgs int) error {
 	}
 }
 
+// executeTailCall calls the function below the numArgs arguments on the top of the stack in place of the current one.
+// The callee and its arguments are moved down over the current frame, which is then reused,
+// so a chain of tail calls runs in constant stack space.
+func (vm *VM) executeTailCall(numArgs int) error {
+	callee, ok := vm.stack[vm.sp-1-numArgs].(*object.Closure)
+	if !ok {
+		// builtins do not need a frame; the OpReturnValue after OpTailCall returns their result.
+		return vm.executeCall(numArgs)
+	}
+	if numArgs != callee.Fn.NumParameters {
+		return fmt.Errorf("wrong number of arguments: want=%d, got=%d", callee.Fn.NumParameters, numArgs)
+	}
+	frame := vm.currentFrame()
+	if err := vm.growStack(frame.basePointer + callee.Fn.NumLocals); err != nil {
+		return err
+	}
+	vm.closeUpvalues(frame.basePointer) // the slots of the current frame are about to be overwritten.
+	copy(vm.stack[frame.basePointer-1:], vm.stack[vm.sp-1-numArgs:vm.sp])
+	frame.cl = callee
+	frame.ip = -1
+	vm.sp = frame.basePointer + callee.Fn.NumLocals
+	return nil
+}
+
 func (vm *VM) callClosure(cl *object.Closure, numArgs int) error {
 	if numArgs != cl.Fn.NumParameters {
 		return fmt.Errorf("wrong number of arguments: want=%d, got=%d", cl.Fn.NumParameters, numArgs)
 	}
 	frame := NewFrame(cl, vm.sp-numArgs)
-	vm.pushFrame(frame)                         // load function on to the stack frame.
+	if err := vm.growStack(frame.basePointer + cl.Fn.NumLocals); err != nil {
+		return err
+	}
+	if err := vm.pushFrame(frame); err != nil { // load function on to the stack frame.
+		return err
+	}
 	vm.sp = frame.basePointer + cl.Fn.NumLocals // make "hole" to store local bindings.
 	return nil
 }
 
 func (vm *VM) callBuiltin(builtin *object.Builtin, numArgs int) error {
 	args := vm.stack[vm.sp-numArgs : vm.sp] // take the arguments from the stack without removing them yet
-	result := builtin.Fn(args...)           // and pass them to the builtin function being called now
-	vm.sp = vm.sp - numArgs - 1             // decrease stack pointer in order to take the arguments and the executed function itself off the stack.
+	if err := builtin.Reserve(vm.budget, args); err != nil {
+		return err // the result would exceed Config.MaxAllocatedBytes, so it is never built.
+	}
+	result := builtin.Call(vm.invoke, args...) // and pass them to the builtin function being called now
+	if err := vm.callbackErr; err != nil {
+		vm.callbackErr = nil
+		return err
+	}
+	vm.sp = vm.sp - numArgs - 1 // decrease stack pointer in order to take the arguments and the executed function itself off the stack.
+	// results of builtins with a Size were already charged by Reserve.
+	if builtin.Allocates && builtin.Size == nil && result != nil {
+		if err := vm.allocate(result); err != nil {
+			return err
+		}
+	}
 	if result != nil {
 		vm.push(result)
 	} else {
@@ -452,21 +826,82 @@ func (vm *VM) callBuiltin(builtin *object.Builtin, numArgs int) error {
 	return nil
 }
 
+// allocate charges a newly created array, string or hash against Config.MaxAllocatedBytes.
+func (vm *VM) allocate(obj object.Object) error {
+	if vm.budget == nil {
+		return nil
+	}
+	return vm.budget.Allocate(obj)
+}
+
 func (vm *VM) pushClosure(constIndex, numFree int) error {
 	constant := vm.constants[constIndex]
 	function, ok := constant.(*object.CompiledFunction)
 	if !ok {
 		return fmt.Errorf("not a function: %+v", constant)
 	}
-	free := make([]object.Object, numFree)
+	free := make([]*object.Upvalue, numFree)
 	for i := 0; i < numFree; i++ {
-		free[i] = vm.stack[vm.sp-numFree+i]
+		switch captured := vm.stack[vm.sp-numFree+i].(type) {
+		case *object.Upvalue:
+			free[i] = captured
+		default:
+			// a value which is not a cell (the current closure) never changes, so it is captured in a closed cell.
+			free[i] = &object.Upvalue{Closed: true, Value: captured}
+		}
 	}
 	vm.sp = vm.sp - numFree
 	closure := &object.Closure{Fn: function, Free: free}
 	return vm.push(closure)
 }
 
+// captureUpvalue returns the open upvalue cell for the stack slot at index, creating it when no closure has captured the slot yet.
+// Reusing the cell is what lets closures and the defining function see each other's writes.
+func (vm *VM) captureUpvalue(index int) *object.Upvalue {
+	// openUpvalues is sorted by index, so the new cell is inserted at its place.
+	i := len(vm.openUpvalues)
+	for i > 0 && vm.openUpvalues[i-1].Index >= index {
+		if vm.openUpvalues[i-1].Index == index {
+			return vm.openUpvalues[i-1]
+		}
+		i--
+	}
+	uv := &object.Upvalue{Index: index}
+	vm.openUpvalues = append(vm.openUpvalues, nil)
+	copy(vm.openUpvalues[i+1:], vm.openUpvalues[i:])
+	vm.openUpvalues[i] = uv
+	return uv
+}
+
+// closeUpvalues moves the values of the open upvalues at or above the stack slot base into the cells themselves.
+// It is called when a frame returns, before its stack slots are reused.
+func (vm *VM) closeUpvalues(base int) {
+	for len(vm.openUpvalues) > 0 {
+		uv := vm.openUpvalues[len(vm.openUpvalues)-1]
+		if uv.Index < base {
+			break
+		}
+		uv.Value = vm.stack[uv.Index]
+		uv.Closed = true
+		vm.openUpvalues = vm.openUpvalues[:len(vm.openUpvalues)-1]
+	}
+}
+
+func (vm *VM) getUpvalue(uv *object.Upvalue) object.Object {
+	if uv.Closed {
+		return uv.Value
+	}
+	return vm.stack[uv.Index]
+}
+
+func (vm *VM) setUpvalue(uv *object.Upvalue, value object.Object) {
+	if uv.Closed {
+		uv.Value = value
+		return
+	}
+	vm.stack[uv.Index] = value
+}
+
 func isTruthy(obj object.Object) bool {
 	switch obj := obj.(type) {
 	case *object.Boolean:
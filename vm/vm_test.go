@@ -1,13 +1,17 @@
 package vm
 
 import (
+	"context"
+	"errors"
 	"fmt"
+	"math/big"
 	"monkey/ast"
 	"monkey/compiler"
 	"monkey/lexer"
 	"monkey/object"
 	"monkey/parser"
 	"testing"
+	"time"
 )
 
 type vmTestCase struct {
@@ -38,6 +42,45 @@ func TestIntegerArithmetic(t *testing.T) {
 	runVmTests(t, tests)
 }
 
+func TestBigIntArithmetic(t *testing.T) {
+	tests := []vmTestCase{
+		{"9223372036854775807 + 1", bigInt("9223372036854775808")},
+		{"-9223372036854775807 - 2", bigInt("-9223372036854775809")},
+		{"9223372036854775807 * 9223372036854775807", bigInt("85070591730234615847396907784232501249")},
+		{"(9223372036854775807 + 1) - 1", 9223372036854775807},
+		{"(9223372036854775807 * 4) / 4", 9223372036854775807},
+		{"-(-9223372036854775807 - 1)", bigInt("9223372036854775808")},
+		{"9223372036854775807 + 1 > 9223372036854775807", true},
+		{"9223372036854775807 + 1 == 9223372036854775807 + 1", true},
+		{"9223372036854775807 + 1 == 9223372036854775807", false},
+		{"(9223372036854775807 + 1) * 0.5", 4611686018427387904.0},
+		{`{9223372036854775807 + 1: "big"}[9223372036854775807 + 1]`, "big"},
+	}
+	runVmTests(t, tests)
+}
+
+func bigInt(s string) *big.Int {
+	v, _ := new(big.Int).SetString(s, 10)
+	return v
+}
+
+func TestFloatArithmetic(t *testing.T) {
+	tests := []vmTestCase{
+		{"1.5", 1.5},
+		{"-2.5", -2.5},
+		{"1.5 + 2.25", 3.75},
+		{"1 + 0.5", 1.5},
+		{"0.5 * 4", 2.0},
+		{"3 / 2.0", 1.5},
+		{"10 - 2.5 * 2", 5.0},
+		{"1.5 < 2", true},
+		{"2 > 1.5", true},
+		{"1.0 == 1", true},
+		{"1.5 != 1.5", false},
+	}
+	runVmTests(t, tests)
+}
+
 func TestBooleanExpressions(t *testing.T) {
 	tests := []vmTestCase{
 		{"true", true},
@@ -70,6 +113,63 @@ func TestBooleanExpressions(t *testing.T) {
 	runVmTests(t, tests)
 }
 
+func TestStructuralEquality(t *testing.T) {
+	tests := []vmTestCase{
+		{`"mon" + "key" == "monkey"`, true},
+		{`"a" != "b"`, true},
+		{`[1, 2] == [1, 2]`, true},
+		{`[1, 2] != [1, 2]`, false},
+		{`[1, 2] == [2, 1]`, false},
+		{`[1, [2, "a"]] == [1, [2, "a"]]`, true},
+		{`[1] == [1.0]`, true},
+		{`[] == {}`, false},
+		{`{"a": 1, "b": [2]} == {"b": [2], "a": 1}`, true},
+		{`{"a": 1} == {"a": 2}`, false},
+		{`{"a": 1} == {"a": 1, "b": 2}`, false},
+		{`let a = [1]; let b = a; a == b`, true},
+		{`fn() {} == fn() {}`, false},
+		{`let f = fn() {}; f == f`, true},
+		{`1 == "1"`, false},
+		{`puts() == puts()`, true},
+	}
+	runVmTests(t, tests)
+}
+
+func TestExtendedOperators(t *testing.T) {
+	tests := []vmTestCase{
+		{"1 <= 2", true},
+		{"2 <= 2", true},
+		{"3 <= 2", false},
+		{"1 >= 2", false},
+		{"2 >= 2", true},
+		{"1.5 <= 1", false},
+		{"1.5 >= 1", true},
+		{"7 % 3", 1},
+		{"-7 % 3", -1},
+		{"7.5 % 2", 1.5},
+		{"6 & 3", 2},
+		{"6 | 3", 7},
+		{"6 ^ 3", 5},
+		{"1 << 4", 16},
+		{"-16 >> 2", -4},
+		{"1 << 64", bigInt("18446744073709551616")},
+		{"(1 << 64) >> 63", 2},
+		{"1 + 2 * 3 % 4 == 3", true},
+		{"true && true", true},
+		{"true && false", false},
+		{"false || true", true},
+		{"false || false", false},
+		{"1 && 2", true},
+		{"0 || false", true},
+		{"1 < 2 && 2 < 3", true},
+		{"1 > 2 || 2 > 3", false},
+		// the right operand is not evaluated once the result is known.
+		{"let boom = fn() { 1 / 0 }; false && boom()", false},
+		{"let boom = fn() { 1 / 0 }; true || boom()", true},
+	}
+	runVmTests(t, tests)
+}
+
 func TestConditionals(t *testing.T) {
 	tests := []vmTestCase{
 		{"if (true) { 10; }", 10},
@@ -100,10 +200,103 @@ func TestStringExpressions(t *testing.T) {
 		{`"monkey"`, "monkey"},
 		{`"mon" + "key"`, "monkey"},
 		{`"mon" + "key" + " banana"`, "monkey banana"},
+		{`"say \"hi\"\n" + "\u{1F412}"`, "say \"hi\"\n\U0001F412"},
+		{"`raw\\n` + `\n`", "raw\\n\n"},
+	}
+	runVmTests(t, tests)
+}
+
+func TestAssignExpressions(t *testing.T) {
+	tests := []vmTestCase{
+		{"let x = 1; x = 2; x", 2},
+		{"let x = 1; x = 2", 2},
+		{"let x = 10; x += 5; x -= 3; x *= 2; x /= 4; x", 6},
+		{"let a = 1; let b = 2; a = b = 3; a + b", 6},
+		{"let x = 1; let f = fn() { x = 5 }; f(); x", 5},
+		{"let f = fn(n) { n += 1; n * 2 }; f(1)", 4},
+		{"let f = fn() { let n = 1; let g = fn() { n = 7; n }; g() }; f()", 7},
+		{"let a = [1, 2, 3]; a[1] = 5; a[1]", 5},
+		{"let a = [1, 2, 3]; a[2] *= 10; a", []int{1, 2, 30}},
+		{`let h = {"a": 1}; h["a"] += 1; h["b"] = 10; h["a"] + h["b"]`, 12},
+		{`let s = "a"; s += "b"; s`, "ab"},
+		{`let a = [1]; a[0] = a; sprintf("%v", a)`, "[[...]]"},
+		{`let h = {}; h["h"] = h; sprintf("%v", h)`, "{h: {...}}"},
+		// a global function refers to its own name through the global binding, which can be reassigned.
+		{`let f = fn(n) { if (n == 0) { "orig" } else { f(n - 1) } }; let g = f; f = fn(n) { "new" }; g(1)`, "new"},
+		{"let f = fn() { f = 1; 2 }; f() + f", 3},
 	}
 	runVmTests(t, tests)
 }
 
+func TestAssignErrors(t *testing.T) {
+	tests := []vmErrorTestCase{
+		{"let a = [1]; a[1] = 2", "index out of range: 1"},
+		{`let a = [1]; a["x"] = 2`, "index must be INTEGER, got STRING"},
+		{`let h = {}; h[fn() {}] = 1`, "unusable as hash key: CLOSURE"},
+		{"let x = 1; x[0] = 1", "index assignment not supported: INTEGER"},
+		{"let t = (1, 2); t[0] = 3", "index assignment not supported: TUPLE"},
+		{"let a = freeze([1]); a[0] = 2", "index assignment not supported: frozen ARRAY"},
+		{"let h = {}; h[[1]] = 1", "unusable as hash key: ARRAY"},
+	}
+
+	runVmErrorTests(t, tests)
+}
+
+func TestWhileStatements(t *testing.T) {
+	tests := []vmTestCase{
+		{"let i = 0; while (i < 10) { i += 1; } i", 10},
+		{"let i = 0; let sum = 0; while (i < 10) { i += 1; if (i % 2 == 0) { continue; } sum += i; } sum", 25},
+		{"let i = 0; while (true) { if (i == 5) { break; } i += 1; } i", 5},
+		{"let f = fn() { let i = 0; while (true) { i += 1; if (i == 3) { return i * 10; } } }; f()", 30},
+		{"let f = fn(n) { let i = 0; let sum = 0; while (i < n) { i += 1; sum += i; } sum }; f(100)", 5050},
+		{"let i = 0; let n = 0; while (i < 3) { let j = 0; while (true) { if (j == 2) { break; } j += 1; n += 1; } i += 1; } n", 6},
+		{"let f = fn() { while (false) { 1 } }; f()", Null},
+		{"if (true) { let x = 1; }", Null},
+		// a loop statement produces null, not the last condition.
+		{"let i = 0; while (i < 2) { i += 1; }", Null},
+		{"while (true) { break; }", Null},
+		// many iterations must not grow the stack.
+		{"let i = 0; while (i < 10000) { if (i > -1) { i += 1; } } i", 10000},
+	}
+	runVmTests(t, tests)
+}
+
+func TestForStatements(t *testing.T) {
+	tests := []vmTestCase{
+		{"let sum = 0; for (x in [1, 2, 3]) { sum += x; } sum", 6},
+		{"let sum = 0; for (i, x in [10, 20, 30]) { sum += i * x; } sum", 80},
+		{`let s = ""; for (c in "añb") { s = c + s; } s`, "bña"},
+		{`let n = 0; for (i, c in "añb") { n = i; } n`, 2},
+		{`let sum = 0; for (k in {1: 10, 2: 20}) { sum += k; } sum`, 3},
+		{`let sum = 0; for (k, v in {1: 10, 2: 20}) { sum += k * v; } sum`, 50},
+		{"let sum = 0; for (i in range(5)) { sum += i; } sum", 10},
+		{"let sum = 0; for (i in range(1, 10, 3)) { sum += i; } sum", 12},
+		{"let sum = 0; for (i in range(5, 0, -2)) { sum += i; } sum", 9},
+		{"let sum = 0; for (i in range(10)) { if (i % 2 == 0) { continue; } if (i > 7) { break; } sum += i; } sum", 16},
+		{"let n = 0; for (i in range(3)) { for (j in range(3)) { if (j > i) { break; } n += 1; } } n", 6},
+		{"let f = fn(xs) { for (x in xs) { if (x > 1) { return x * 10; } } }; f([1, 2, 3])", 20},
+		{"let f = fn(n) { let sum = 0; for (i in range(n)) { sum += i; } sum }; f(101)", 5050},
+		{"let f = fn() { for (x in []) { 1 } }; f()", Null},
+		{"let f = fn() { for (x in [1]) { break; } }; f()", Null},
+		{"let f = fn(b) { if (b) { for (x in [1]) { x } } }; f(true)", Null},
+		// a loop statement produces null, not the exhausted iterator.
+		{"for (x in [1]) { x }", Null},
+		{"for (x in [1, 2]) { break; }", Null},
+		// the iterator must be removed from the stack once the loop is done.
+		{"let n = 0; for (i in range(10000)) { for (j in [1]) { if (j > 0) { break; } } n += 1; } n", 10000},
+	}
+	runVmTests(t, tests)
+}
+
+func TestForStatementErrors(t *testing.T) {
+	tests := []vmErrorTestCase{
+		{"for (x in 1) { x }", "cannot iterate over INTEGER"},
+		{"let f = fn() { for (x in true) { x } }; f()", "cannot iterate over BOOLEAN"},
+	}
+
+	runVmErrorTests(t, tests)
+}
+
 func TestArrayLiterals(t *testing.T) {
 	tests := []vmTestCase{
 		{"[]", []int{}},
@@ -336,7 +529,7 @@ func TestCallingFunctionsWithArgumentsAndBindings(t *testing.T) {
 }
 
 func TestCallingFunctionsWithWrongArguments(t *testing.T) {
-	tests := []vmTestCase{
+	tests := []vmErrorTestCase{
 		{
 			input:    `fn() { 1; }(1);`,
 			expected: `wrong number of arguments: want=0, got=1`,
@@ -350,22 +543,7 @@ func TestCallingFunctionsWithWrongArguments(t *testing.T) {
 			expected: `wrong number of arguments: want=2, got=1`,
 		},
 	}
-	for _, tt := range tests {
-		program := parse(tt.input)
-		comp := compiler.New()
-		err := comp.Compile(program)
-		if err != nil {
-			t.Fatalf("compiler error: %s", err)
-		}
-		vm := New(comp.Bytecode())
-		err = vm.Run()
-		if err == nil {
-			t.Fatalf("expected VM error but resulted in none.")
-		}
-		if err.Error() != tt.expected {
-			t.Fatalf("wrong VM error: want=%q, got=%q", tt.expected, err)
-		}
-	}
+	runVmErrorTests(t, tests)
 }
 
 func TestBuiltinFunctions(t *testing.T) {
@@ -536,6 +714,171 @@ func TestClosures(t *testing.T) {
 	runVmTests(t, tests)
 }
 
+func TestRecursiveClosures(t *testing.T) {
+	tests := []vmTestCase{
+		{
+			input: `let countDown = fn(x) { if (x == 0) { return 0; } else { countDown(x - 1); } };
+					let wrapper = fn() { countDown(1); };
+					wrapper();`,
+			expected: 0,
+		},
+		{
+			input: `let wrapper = fn() {
+						let countDown = fn(x) { if (x == 0) { return 0; } else { countDown(x - 1); } };
+						countDown(1);
+					};
+					wrapper();`,
+			expected: 0,
+		},
+		{
+			input: `let wrapper = fn() {
+						let fib = fn(n) { if (n < 2) { n } else { fib(n - 1) + fib(n - 2) } };
+						fib(15)
+					};
+					wrapper();`,
+			expected: 610,
+		},
+		{
+			// the inner function calls the enclosing one by name.
+			input: `let wrapper = fn() {
+						let sum = fn(n) { let step = fn() { sum(n - 1) }; if (n == 0) { 0 } else { n + step() } };
+						sum(10)
+					};
+					wrapper();`,
+			expected: 55,
+		},
+		{
+			// a recursive closure returned from its defining function keeps working.
+			input: `let make = fn(base) { let pow = fn(n) { if (n == 0) { 1 } else { base * pow(n - 1) } }; pow };
+					let powerOfTwo = make(2);
+					powerOfTwo(10);`,
+			expected: 1024,
+		},
+	}
+	runVmTests(t, tests)
+}
+
+func TestTailCalls(t *testing.T) {
+	tests := []vmTestCase{
+		// far deeper than the default maximum call depth.
+		{"let countDown = fn(n) { if (n == 0) { 0 } else { countDown(n - 1) } }; countDown(100000)", 0},
+		{"let sum = fn(n, acc) { if (n == 0) { return acc; } return sum(n - 1, acc + n); }; sum(100000, 0)", 5000050000},
+		{
+			input: `let isOdd = 0;
+					let isEven = fn(n) { if (n == 0) { true } else { isOdd(n - 1) } };
+					isOdd = fn(n) { if (n == 0) { false } else { isEven(n - 1) } };
+					isEven(100001)`,
+			expected: false,
+		},
+		{
+			input: `let wrapper = fn() {
+						let loop = fn(i, acc) { if (i == 0) { acc } else { loop(i - 1, acc + 2) } };
+						loop(50000, 0)
+					};
+					wrapper()`,
+			expected: 100000,
+		},
+		{"let f = fn(a) { len(a) }; f([1, 2, 3])", 3},
+		{"let f = fn(n) { if (n == 0) { 0 } else { 1 + f(n - 1) } }; f(100)", 100},
+		{
+			// the captured argument must survive the frame being reused by the tail call.
+			input: `let f = fn(n, last) { if (n == 0) { last() } else { f(n - 1, fn() { n }) } };
+					f(3, fn() { 0 })`,
+			expected: 1,
+		},
+		// a top-level return ends the program with its value.
+		{"let f = fn(x) { x * 2 }; return f(21); 5", 42},
+		{"return 10; 9;", 10},
+	}
+	runVmTests(t, tests)
+}
+
+func TestMutableCaptures(t *testing.T) {
+	tests := []vmTestCase{
+		{
+			// the captured local outlives the frame which defined it.
+			input: `let newCounter = fn() {
+						let c = 0;
+						fn() { c = c + 1 };
+					};
+					let counter = newCounter();
+					counter();
+					counter();
+					counter();`,
+			expected: 3,
+		},
+		{
+			// every call of newCounter gets a cell of its own.
+			input: `let newCounter = fn() { let c = 0; fn() { c += 1 } };
+					let a = newCounter();
+					let b = newCounter();
+					a(); a(); b();
+					[a(), b()]`,
+			expected: []int{3, 2},
+		},
+		{
+			// closures capturing the same variable share it.
+			input: `let pair = fn() {
+						let n = 0;
+						[fn() { n += 10 }, fn() { n }]
+					};
+					let p = pair();
+					p[0]();
+					p[0]();
+					p[1]()`,
+			expected: 20,
+		},
+		{
+			// the defining function sees the closure's writes and vice versa.
+			input: `let f = fn() {
+						let x = 1;
+						let get = fn() { x };
+						let set = fn(v) { x = v };
+						set(5);
+						let seen = x;
+						x = 7;
+						seen * 10 + get()
+					};
+					f()`,
+			expected: 57,
+		},
+		{
+			// writes through a free variable of a free variable.
+			input: `let outer = fn() {
+						let x = 0;
+						let middle = fn() { fn() { x += 1 } };
+						let inner = middle();
+						inner();
+						inner();
+						x
+					};
+					outer()`,
+			expected: 2,
+		},
+		{
+			// a local function can refer to itself once it is bound.
+			input: `let f = fn() {
+						let countdown = fn(n) { if (n == 0) { 0 } else { 1 + countdown(n - 1) } };
+						countdown(5)
+					};
+					f()`,
+			expected: 5,
+		},
+		{
+			input: `let f = fn() {
+						let fns = [];
+						let i = 0;
+						while (i < 3) { let j = i; fns = push(fns, fn() { j }); i += 1; }
+						fns[0]() + fns[2]()
+					};
+					f()`,
+			// locals are scoped to the function, so every closure sees the last j.
+			expected: 4,
+		},
+	}
+	runVmTests(t, tests)
+}
+
 func TestRecursiveFibonacci(t *testing.T) {
 	tests := []vmTestCase{
 		{
@@ -577,6 +920,39 @@ func runVmTests(t *testing.T, tests []vmTestCase) {
 	}
 }
 
+type vmErrorTestCase struct {
+	input    string
+	expected string
+}
+
+// runVmErrorTests checks that each input compiles and then fails in the VM with the expected error message.
+func runVmErrorTests(t *testing.T, tests []vmErrorTestCase) {
+	t.Helper()
+	for _, tt := range tests {
+		err := runVmError(t, tt.input)
+		if err.Error() != tt.expected {
+			t.Errorf("wrong VM error: want=%q, got=%q", tt.expected, err)
+		}
+	}
+}
+
+// runVmError compiles and runs input, and returns the error the VM fails with.
+func runVmError(t *testing.T, input string) error {
+	t.Helper()
+	program := parse(input)
+	comp := compiler.New()
+	err := comp.Compile(program)
+	if err != nil {
+		t.Fatalf("compiler error: %s", err)
+	}
+	vm := New(comp.Bytecode())
+	err = vm.Run()
+	if err == nil {
+		t.Fatalf("expected VM error but resulted in none.")
+	}
+	return err
+}
+
 func testExpectedObject(t *testing.T, expected interface{}, actual object.Object) {
 	t.Helper()
 	switch expected := expected.(type) {
@@ -585,6 +961,16 @@ func testExpectedObject(t *testing.T, expected interface{}, actual object.Object
 		if err != nil {
 			t.Errorf("testIntegerObject failed: %s", err)
 		}
+	case *big.Int:
+		err := testBigIntObject(expected, actual)
+		if err != nil {
+			t.Errorf("testBigIntObject failed: %s", err)
+		}
+	case float64:
+		err := testFloatObject(expected, actual)
+		if err != nil {
+			t.Errorf("testFloatObject failed: %s", err)
+		}
 	case bool:
 		err := testBooleanObject(bool(expected), actual)
 		if err != nil {
@@ -611,20 +997,37 @@ func testExpectedObject(t *testing.T, expected interface{}, actual object.Object
 				t.Errorf("testIntegerObject failed: %s", err)
 			}
 		}
+	case []string:
+		array, ok := actual.(*object.Array)
+		if !ok {
+			t.Errorf("object not Array: %T (%+v)", actual, actual)
+			return
+		}
+		if len(array.Elements) != len(expected) {
+			t.Errorf("wrong num of elements. want=%d, got=%d", len(expected), len(array.Elements))
+			return
+		}
+		for i, expectedElem := range expected {
+			err := testStringObject(expectedElem, array.Elements[i])
+			if err != nil {
+				t.Errorf("testStringObject failed: %s", err)
+			}
+		}
 	case map[object.HashKey]int64:
 		hash, ok := actual.(*object.Hash)
 		if !ok {
 			t.Errorf("object is not Hash. got=%T (%+v)", actual, actual)
 			return
 		}
-		if len(hash.Pairs) != len(expected) {
-			t.Errorf("hash has wrong number of Pairs. want=%d, got=%d", len(expected), len(hash.Pairs))
+		if hash.Len() != len(expected) {
+			t.Errorf("hash has wrong number of Pairs. want=%d, got=%d", len(expected), hash.Len())
 			return
 		}
-		for expectedKey, expectedValue := range expected {
-			pair, ok := hash.Pairs[expectedKey]
+		for _, pair := range hash.Pairs() {
+			expectedValue, ok := expected[pair.Key.(object.Hashable).HashKey()]
 			if !ok {
-				t.Errorf("no pair for given key in Pairs")
+				t.Errorf("unexpected key in Pairs: %s", pair.Key.Inspect())
+				continue
 			}
 			err := testIntegerObject(expectedValue, pair.Value)
 			if err != nil {
@@ -664,6 +1067,28 @@ func testIntegerObject(expected int64, actual object.Object) error {
 	return nil
 }
 
+func testBigIntObject(expected *big.Int, actual object.Object) error {
+	result, ok := actual.(*object.BigInt)
+	if !ok {
+		return fmt.Errorf("object is not BigInt. got=%T (%+v)", actual, actual)
+	}
+	if result.Value.Cmp(expected) != 0 {
+		return fmt.Errorf("object has wrong value. got=%s, want=%s", result.Value, expected)
+	}
+	return nil
+}
+
+func testFloatObject(expected float64, actual object.Object) error {
+	result, ok := actual.(*object.Float)
+	if !ok {
+		return fmt.Errorf("object is not Float. got=%T (%+v)", actual, actual)
+	}
+	if result.Value != expected {
+		return fmt.Errorf("object has wrong value. got=%g, want=%g", result.Value, expected)
+	}
+	return nil
+}
+
 func testBooleanObject(expected bool, actual object.Object) error {
 	result, ok := actual.(*object.Boolean)
 	if !ok {
@@ -685,3 +1110,455 @@ func testStringObject(expected string, actual object.Object) error {
 	}
 	return nil
 }
+
+func TestRuntimeErrorStackTrace(t *testing.T) {
+	input := `let add = fn(a, b) {
+	a + b
+};
+let apply = fn(f) {
+	let x = 1;
+	let result = f(x, "two");
+	result
+};
+apply(add);`
+	err := runVmError(t, input)
+	rerr, ok := err.(*RuntimeError)
+	if !ok {
+		t.Fatalf("error is not *RuntimeError. got=%T (%+v)", err, err)
+	}
+	if rerr.Error() != "unsupported types for binary operation: INTEGER STRING" {
+		t.Errorf("wrong error message. got=%q", rerr.Error())
+	}
+	expected := []StackFrame{
+		{Function: "add", Line: 2},
+		{Function: "apply", Line: 6},
+		{Function: "<main>", Line: 9},
+	}
+	if len(rerr.StackTrace) != len(expected) {
+		t.Fatalf("wrong stack trace length. want=%d, got=%d (%v)", len(expected), len(rerr.StackTrace), rerr.StackTrace)
+	}
+	for i, frame := range expected {
+		if rerr.StackTrace[i] != frame {
+			t.Errorf("wrong stack frame %d. want=%v, got=%v", i, frame, rerr.StackTrace[i])
+		}
+	}
+}
+
+func TestTailCallStackTrace(t *testing.T) {
+	// apply calls f in tail position, so its frame is reused and does not show up in the trace.
+	input := `let add = fn(a, b) { a + b };
+let apply = fn(f) { f(1, "two") };
+apply(add);`
+	program := parse(input)
+	comp := compiler.New()
+	err := comp.Compile(program)
+	if err != nil {
+		t.Fatalf("compiler error: %s", err)
+	}
+	vm := New(comp.Bytecode())
+	err = vm.Run()
+	rerr, ok := err.(*RuntimeError)
+	if !ok {
+		t.Fatalf("error is not *RuntimeError. got=%T (%+v)", err, err)
+	}
+	expected := []StackFrame{
+		{Function: "add", Line: 1},
+		{Function: "<main>", Line: 3},
+	}
+	if len(rerr.StackTrace) != len(expected) {
+		t.Fatalf("wrong stack trace length. want=%d, got=%d (%v)", len(expected), len(rerr.StackTrace), rerr.StackTrace)
+	}
+	for i, frame := range expected {
+		if rerr.StackTrace[i] != frame {
+			t.Errorf("wrong stack frame %d. want=%v, got=%v", i, frame, rerr.StackTrace[i])
+		}
+	}
+}
+
+func TestArithmeticErrors(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+		sentinel error // is matched with errors.Is if not nil.
+	}{
+		{"1 / 0", "division by zero", object.ErrDivisionByZero},
+		{"let f = fn(x) { 10 / x }; f(0)", "division by zero", object.ErrDivisionByZero},
+		{"(9223372036854775807 + 1) / 0", "division by zero", object.ErrDivisionByZero},
+		{"1 % 0", "division by zero", object.ErrDivisionByZero},
+		{"1 << -1", "negative shift count", object.ErrNegativeShift},
+		{"1 >> -1", "negative shift count", object.ErrNegativeShift},
+		{"1.5 & 1", "unsupported types for binary operation: FLOAT INTEGER", nil},
+	}
+
+	for _, tt := range tests {
+		err := runVmError(t, tt.input)
+		if tt.sentinel != nil && !errors.Is(err, tt.sentinel) {
+			t.Errorf("error is not %v. got=%T (%+v)", tt.sentinel, err, err)
+		}
+		if err.Error() != tt.expected {
+			t.Errorf("wrong VM error: want=%q, got=%q", tt.expected, err)
+		}
+	}
+
+	// MinInt64 / -1 is promoted instead of wrapping around.
+	runVmTests(t, []vmTestCase{
+		{"(-9223372036854775807 - 1) / -1", bigInt("9223372036854775808")},
+	})
+}
+
+func TestConfigLimits(t *testing.T) {
+	countDown := "let f = fn(n) { if (n == 0) { 0 } else { 1 + f(n - 1) } };"
+	tests := []struct {
+		input    string
+		config   Config
+		expected interface{} // is the result, or the error matched with errors.Is.
+	}{
+		// the stack and the frames grow past their initial sizes.
+		{countDown + "f(5000)", Config{}, 5000},
+		{"[1, 2, 3, 4, 5, 6, 7, 8][7]", Config{StackSize: 2}, 8},
+		{countDown + "f(100000)", Config{}, ErrCallDepthExceeded},
+		{countDown + "f(8)", Config{MaxCallDepth: 10}, 8},
+		{countDown + "f(9)", Config{MaxCallDepth: 10}, ErrCallDepthExceeded},
+		{countDown + "f(200)", Config{MaxStackSize: 100}, ErrStackOverflow},
+		{"[1, 2, 3, 4, 5, 6, 7, 8]", Config{MaxStackSize: 4}, ErrStackOverflow},
+	}
+
+	for _, tt := range tests {
+		program := parse(tt.input)
+		comp := compiler.New()
+		err := comp.Compile(program)
+		if err != nil {
+			t.Fatalf("compiler error: %s", err)
+		}
+		vm := NewWithConfig(comp.Bytecode(), tt.config)
+		err = vm.Run()
+		sentinel, ok := tt.expected.(error)
+		if !ok {
+			if err != nil {
+				t.Fatalf("vm error: %s", err)
+			}
+			testExpectedObject(t, tt.expected, vm.LastPoppedStackElem())
+			continue
+		}
+		if !errors.Is(err, sentinel) {
+			t.Fatalf("error is not %v. got=%T (%+v)", sentinel, err, err)
+		}
+		rerr := err.(*RuntimeError)
+		if len(rerr.StackTrace) > maxStackTrace {
+			t.Errorf("stack trace too long. got=%d", len(rerr.StackTrace))
+		}
+	}
+}
+
+func TestExecutionLimits(t *testing.T) {
+	cancelled, cancel := context.WithCancel(context.Background())
+	cancel()
+	tests := []struct {
+		input    string
+		config   Config
+		timeout  time.Duration   // bounds the run with a deadline if not zero.
+		ctx      context.Context // is used instead of context.Background() if not nil.
+		expected error
+	}{
+		{input: "while (true) { }", config: Config{MaxInstructions: 10000}, expected: object.ErrBudgetExhausted},
+		{input: "let f = fn() { f() }; f()", config: Config{MaxInstructions: 10000}, expected: object.ErrBudgetExhausted},
+		{input: "while (true) { }", timeout: 10 * time.Millisecond, expected: context.DeadlineExceeded},
+		{input: "let f = fn(n) { f(n + 1) }; f(0)", timeout: 10 * time.Millisecond, expected: context.DeadlineExceeded},
+		{input: "let i = 0; while (true) { i += 1; }", ctx: cancelled, expected: context.Canceled},
+		{input: "let a = []; while (true) { a = push(a, 1); }", config: Config{MaxAllocatedBytes: 1 << 20}, expected: object.ErrAllocationLimit},
+		{input: `let s = "ab"; while (true) { s += s; }`, config: Config{MaxAllocatedBytes: 1 << 20}, expected: object.ErrAllocationLimit},
+		{input: "let h = {}; let i = 0; while (true) { h[i] = i; i += 1; }", config: Config{MaxAllocatedBytes: 1 << 20}, expected: object.ErrAllocationLimit},
+		{input: "while (true) { [1, 2, 3]; {1: 2}; }", config: Config{MaxAllocatedBytes: 1 << 20}, expected: object.ErrAllocationLimit},
+		// the result of a size-parameterized builtin is checked before it is built.
+		{input: `repeat("x", 1000000000)`, config: Config{MaxAllocatedBytes: 1 << 20}, expected: object.ErrAllocationLimit},
+		{input: `replace(repeat("x", 1000), "", repeat("y", 100000))`, config: Config{MaxAllocatedBytes: 1 << 20}, expected: object.ErrAllocationLimit},
+		{input: `let s = repeat("x", 10000); let a = []; for (i in range(200)) { a = push(a, s); } join(a, "")`, config: Config{MaxAllocatedBytes: 1 << 20}, expected: object.ErrAllocationLimit},
+		{input: `let a = range(10); for (i in range(20)) { a = [a, a]; } freeze(a)`, config: Config{MaxAllocatedBytes: 1 << 20}, expected: object.ErrAllocationLimit},
+		{input: `let a = 1 << 1000000; let i = 0; while (i < 7) { a = a * a; i += 1 } 1`, config: Config{MaxInstructions: 1000, MaxAllocatedBytes: 1 << 20}, expected: object.ErrAllocationLimit},
+	}
+
+	for _, tt := range tests {
+		program := parse(tt.input)
+		comp := compiler.New()
+		err := comp.Compile(program)
+		if err != nil {
+			t.Fatalf("compiler error: %s", err)
+		}
+		ctx := context.Background()
+		if tt.ctx != nil {
+			ctx = tt.ctx
+		}
+		if tt.timeout != 0 {
+			var cancel context.CancelFunc
+			ctx, cancel = context.WithTimeout(ctx, tt.timeout)
+			defer cancel()
+		}
+		vm := NewWithConfig(comp.Bytecode(), tt.config)
+		err = vm.RunContext(ctx)
+		if !errors.Is(err, tt.expected) {
+			t.Errorf("%q: error is not %v. got=%T (%+v)", tt.input, tt.expected, err, err)
+		}
+		if _, ok := err.(*RuntimeError); !ok {
+			t.Errorf("%q: error is not *RuntimeError. got=%T", tt.input, err)
+		}
+	}
+
+	// a run within the limits is not affected.
+	program := parse("let a = []; for (i in range(100)) { a = push(a, i); } let sum = 0; for (x in a) { sum += x; } sum")
+	comp := compiler.New()
+	if err := comp.Compile(program); err != nil {
+		t.Fatalf("compiler error: %s", err)
+	}
+	vm := NewWithConfig(comp.Bytecode(), Config{MaxInstructions: 100000, MaxAllocatedBytes: 1 << 20})
+	if err := vm.RunContext(context.Background()); err != nil {
+		t.Fatalf("vm error: %s", err)
+	}
+	testExpectedObject(t, 4950, vm.LastPoppedStackElem())
+}
+
+func TestEmbedding(t *testing.T) {
+	input := `
+let greeting = greet("monkey");
+let counter = 0;
+let add = fn(a, b) { counter += 1; a + b };
+let fail = fn() { 1 + "one" };
+`
+	comp := compiler.New()
+	comp.DefineGlobal("greet")
+	if err := comp.Compile(parse(input)); err != nil {
+		t.Fatalf("compiler error: %s", err)
+	}
+	vm := New(comp.Bytecode())
+	if err := vm.Register("greet", func(name string) string { return "hello " + name }); err != nil {
+		t.Fatalf("register failed: %s", err)
+	}
+	if err := vm.Run(); err != nil {
+		t.Fatalf("vm error: %s", err)
+	}
+
+	greeting, ok := vm.Global("greeting")
+	if !ok {
+		t.Fatalf("global greeting not found")
+	}
+	testExpectedObject(t, "hello monkey", greeting)
+	if _, ok := vm.Global("missing"); ok {
+		t.Errorf("global missing should not be found")
+	}
+
+	add, ok := vm.Global("add")
+	if !ok {
+		t.Fatalf("global add not found")
+	}
+	result, err := vm.Call(add, 1, 2)
+	if err != nil {
+		t.Fatalf("call failed: %s", err)
+	}
+	testExpectedObject(t, 3, result)
+
+	// a failing call reports a runtime error and leaves the VM usable.
+	fail, _ := vm.Global("fail")
+	_, err = vm.Call(fail)
+	if _, ok := err.(*RuntimeError); !ok {
+		t.Fatalf("error is not *RuntimeError. got=%T (%+v)", err, err)
+	}
+	if _, err := vm.Call(add, 1); err == nil {
+		t.Errorf("calling with the wrong number of arguments should fail")
+	}
+	result, err = vm.Call(add, "a", "b")
+	if err != nil {
+		t.Fatalf("call failed: %s", err)
+	}
+	testExpectedObject(t, "ab", result)
+	counter, _ := vm.Global("counter")
+	testExpectedObject(t, 2, counter)
+
+	// builtins and host functions can be called as well.
+	greet, _ := vm.Global("greet")
+	result, err = vm.Call(greet, "go")
+	if err != nil {
+		t.Fatalf("call failed: %s", err)
+	}
+	testExpectedObject(t, "hello go", result)
+
+	if err := vm.SetGlobal("undeclared", 1); err == nil {
+		t.Errorf("setting an undeclared global should fail")
+	}
+}
+
+func TestHigherOrderBuiltins(t *testing.T) {
+	tests := []vmTestCase{
+		{`map([1, 2, 3], fn(x) { x * 2 })`, []int{2, 4, 6}},
+		{`map([], fn(x) { x })`, []int{}},
+		{`let k = 3; map([1, 2], fn(x) { x * k })`, []int{3, 6}},
+		{`map([[1], [1, 2]], len)`, []int{1, 2}},
+		{`filter([1, 2, 3, 4], fn(x) { x % 2 == 0 })`, []int{2, 4}},
+		{`filter([1, 2], fn(x) { false })`, []int{}},
+		{`reduce([1, 2, 3, 4], 0, fn(acc, x) { acc + x })`, 10},
+		{`reduce([], 5, fn(acc, x) { acc + x })`, 5},
+		{`sort_by([3, 1, 2], fn(x) { x })`, []int{1, 2, 3}},
+		{`sort_by([3, 1, 2], fn(x) { -x })`, []int{3, 2, 1}},
+		{`sort_by([13, 2, 11, 4], fn(x) { x % 2 })`, []int{2, 4, 13, 11}},
+		{`sort_by([30, 1, 200], fn(x) { 0 })`, []int{30, 1, 200}},
+		// callbacks can call builtins which call back again.
+		{`map([[1, 2], [3]], fn(a) { reduce(a, 0, fn(s, x) { s + x }) })`, []int{3, 3}},
+		// a builtin called in tail position.
+		{`let inc = fn(a) { map(a, fn(x) { x + 1 }) }; inc([1, 2])`, []int{2, 3}},
+		{`let count = 0; map([1, 2, 3], fn(x) { count += x }); count`, 6},
+		{`map(1, fn(x) { x })`, &object.Error{Message: "argument to `map` must be ARRAY, got INTEGER"}},
+		{`reduce([1], fn(x) { x })`, &object.Error{Message: "wrong number of arguments. got=2, want=3"}},
+		{`sort_by([1, "a"], fn(x) { x })`, &object.Error{Message: "sort_by keys must be all numbers or all strings, got INTEGER and STRING"}},
+	}
+
+	runVmTests(t, tests)
+}
+
+func TestCallbackRuntimeError(t *testing.T) {
+	input := `let total = 0;
+let add = fn(x) {
+	x + "one"
+};
+map([1, 2], add);
+total = 1;`
+	program := parse(input)
+	comp := compiler.New()
+	if err := comp.Compile(program); err != nil {
+		t.Fatalf("compiler error: %s", err)
+	}
+	vm := New(comp.Bytecode())
+	err := vm.Run()
+	rerr, ok := err.(*RuntimeError)
+	if !ok {
+		t.Fatalf("error is not *RuntimeError. got=%T (%+v)", err, err)
+	}
+	if rerr.Error() != "unsupported types for binary operation: INTEGER STRING" {
+		t.Errorf("wrong error message. got=%q", rerr.Error())
+	}
+	expected := []StackFrame{
+		{Function: "add", Line: 3},
+		{Function: "<builtin>"},
+		{Function: "<main>", Line: 5},
+	}
+	if len(rerr.StackTrace) != len(expected) {
+		t.Fatalf("wrong stack trace length. want=%d, got=%d (%v)", len(expected), len(rerr.StackTrace), rerr.StackTrace)
+	}
+	for i, frame := range expected {
+		if rerr.StackTrace[i] != frame {
+			t.Errorf("wrong stack frame %d. want=%v, got=%v", i, frame, rerr.StackTrace[i])
+		}
+	}
+	// the program stops at the failing builtin call.
+	total, _ := vm.Global("total")
+	testExpectedObject(t, 0, total)
+}
+
+func TestCustomBuiltins(t *testing.T) {
+	r := object.NewStandardRegistry()
+	for i := 0; i < 300; i++ {
+		r.Register(fmt.Sprintf("pad_%c%c", 'a'+i/26, 'a'+i%26), &object.Builtin{})
+	}
+	r.Namespace("math").Register("double", &object.Builtin{
+		Fn: func(call object.Invoker, args ...object.Object) object.Object {
+			return &object.Integer{Value: args[0].(*object.Integer).Value * 2}
+		},
+		Signature: &object.Signature{MinArgs: 1, MaxArgs: 1, Types: []object.ObjectType{object.INTEGER_OBJ}},
+	})
+
+	tests := []vmTestCase{
+		{"math.double(21)", 42},
+		{"map([1, 2], math.double)", []int{2, 4}},
+		{`math.double("a")`, &object.Error{Message: "argument to `math.double` must be INTEGER, got STRING"}},
+		{"math.double()", &object.Error{Message: "wrong number of arguments. got=0, want=1"}},
+	}
+	for _, tt := range tests {
+		comp := compiler.NewWithBuiltins(r)
+		if err := comp.Compile(parse(tt.input)); err != nil {
+			t.Fatalf("compiler error: %s", err)
+		}
+		vm := New(comp.Bytecode())
+		if err := vm.Run(); err != nil {
+			t.Fatalf("vm error: %s", err)
+		}
+		testExpectedObject(t, tt.expected, vm.LastPoppedStackElem())
+	}
+}
+
+func TestStringBuiltins(t *testing.T) {
+	tests := []vmTestCase{
+		{`len("héllo")`, 5},
+		{`split("a,b,c", ",")`, []string{"a", "b", "c"}},
+		{`split("ab", "")`, []string{"a", "b"}},
+		{`split("a,b", 1)`, &object.Error{Message: "argument to `split` must be STRING, got INTEGER"}},
+		{`join(["a", "b", "c"], "-")`, "a-b-c"},
+		{`join([], "-")`, ""},
+		{`join(["a", 1], "-")`, &object.Error{Message: "argument to `join` must be ARRAY of STRING, got INTEGER in ARRAY"}},
+		{`trim("  monkey \n")`, "monkey"},
+		{`replace("banana", "a", "o")`, "bonono"},
+		{`contains("monkey", "key")`, true},
+		{`contains("monkey", "ape")`, false},
+		{`index_of("héllo", "l")`, 2},
+		{`index_of("hello", "z")`, -1},
+		{`starts_with("monkey", "mon")`, true},
+		{`ends_with("monkey", "mon")`, false},
+		{`upper("héllo")`, "HÉLLO"},
+		{`lower("MONKEY")`, "monkey"},
+		{`repeat("ab", 3)`, "ababab"},
+		{`repeat("ab", 0)`, ""},
+		{`repeat("ab", -1)`, &object.Error{Message: "repeat count must not be negative, got -1"}},
+		{`replace(repeat("x", 100000), "", repeat("y", 20000))`, &object.Error{Message: "replace result too long: 2000120000 bytes"}},
+		{`substring("héllo", 1)`, "éllo"},
+		{`substring("héllo", 1, 3)`, "él"},
+		{`substring("héllo", 3, 9)`, &object.Error{Message: "substring out of range: [3:9] with length 5"}},
+		{`substring("héllo")`, &object.Error{Message: "wrong number of arguments. got=1, want=2..3"}},
+		{`chars("héllo")`, []string{"h", "é", "l", "l", "o"}},
+		{`format("%s is %d (%.1f) %v", "x", 1, 2.25, true)`, "x is 1 (2.2) true"},
+		{`sprintf("%s and %s", [1, 2], "y")`, "[1, 2] and y"},
+		{`format()`, &object.Error{Message: "wrong number of arguments. got=0, want>=1"}},
+	}
+
+	runVmTests(t, tests)
+}
+
+func TestTuples(t *testing.T) {
+	tests := []vmTestCase{
+		{`sprintf("%v", (1, "a", [2]))`, "(1, a, [2])"},
+		{`sprintf("%v", (1,))`, "(1,)"},
+		{`sprintf("%v", tuple())`, "()"},
+		{`(1, 2)[1]`, 2},
+		{`(1, 2)[2]`, Null},
+		{`len((1, 2, 3))`, 3},
+		{`(1, [2]) == tuple(1, [2])`, true},
+		{`(1, 2) == [1, 2]`, false},
+		{`let memo = {}; memo[(1, 2)] = "a"; memo[(2, 1)] = "b"; memo[(1, 2)]`, "a"},
+		{`let memo = {(0, 0): 1}; memo[tuple(0, 0)] = 2; len(keys(memo))`, 1},
+		{`has({}, (1, [2]))`, &object.Error{Message: "unusable as hash key: TUPLE"}},
+		{`let h = {}; h[freeze([1, [2, 3]])] = "x"; h[freeze([1, [2, 3]])]`, "x"},
+		{`let a = [1, [2]]; let b = freeze(a); a[1][0] = 3; b[1][0]`, 2},
+		{`freeze([1]) == [1]`, true},
+		{`let a = [1]; a[0] = a; freeze(a)`, &object.Error{Message: "cannot freeze self-referencing ARRAY"}},
+		{`let sum = 0; for (i, x in (10, 20)) { sum = sum + i + x; } sum`, 31},
+	}
+
+	runVmTests(t, tests)
+}
+
+func TestHashBuiltins(t *testing.T) {
+	tests := []vmTestCase{
+		{`keys({"b": 1, "a": 2, "c": 3})`, []string{"b", "a", "c"}},
+		{`values({"b": 1, "a": 2, "c": 3})`, []int{1, 2, 3}},
+		{`keys({})`, []string{}},
+		{`sprintf("%v", entries({"a": 1, 2: "b"}))`, "[[a, 1], [2, b]]"},
+		{`has({"a": 1}, "a")`, true},
+		{`has({"a": 1}, "b")`, false},
+		{`has({"a": 1}, [1])`, &object.Error{Message: "unusable as hash key: ARRAY"}},
+		{`has([1], 1)`, &object.Error{Message: "argument to `has` must be HASH, got ARRAY"}},
+		{`let h = {"a": 1, "b": 2}; delete(h, "a")`, true},
+		{`let h = {"a": 1, "b": 2}; delete(h, "c")`, false},
+		{`let h = {"a": 1, "b": 2, "c": 3}; delete(h, "b"); h["b"] = 4; keys(h)`, []string{"a", "c", "b"}},
+		{`let h = {"a": 1}; h["a"] = 2; h["b"] = 3; sprintf("%v", h)`, "{a: 2, b: 3}"},
+		{`sprintf("%v", merge({"a": 1, "b": 2}, {"c": 3, "a": 4}))`, "{a: 4, b: 2, c: 3}"},
+		{`let h = {"a": 1}; merge(h, {"b": 2}); keys(h)`, []string{"a"}},
+		{`merge({}, 1)`, &object.Error{Message: "argument to `merge` must be HASH, got INTEGER"}},
+	}
+
+	runVmTests(t, tests)
+}